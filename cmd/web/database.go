@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/driver"
+)
+
+// connectDB connects to the database of the settings
+func connectDB(c config.Database) (*driver.DB, error) {
+	if c.Driver == "sqlite" {
+		return driver.ConnectSQLite(c.File)
+	}
+
+	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
+		c.Host, c.Port, c.Name, c.User, c.Password, c.SSLMode)
+	return driver.ConnectSQL(connectionString)
+}
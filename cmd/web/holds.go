@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/taldrori/bookings/internal/repository"
+)
+
+// sweepExpiredHolds deletes expired holds every interval, so the rooms become available again,
+// until ctx is done
+func sweepExpiredHolds(ctx context.Context, repo repository.DatabaseRepo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := repo.DeleteExpiredHolds(ctx)
+		if err != nil {
+			app.ErrorLog.Println(err)
+			continue
+		}
+		if n > 0 {
+			app.InfoLog.Printf("Released %d expired holds\n", n)
+		}
+	}
+}
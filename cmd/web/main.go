@@ -1,48 +1,119 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/taldrori/bookings/internal/calsync"
 	"github.com/taldrori/bookings/internal/config"
 	"github.com/taldrori/bookings/internal/driver"
 	"github.com/taldrori/bookings/internal/handlers"
 	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/mailer"
 	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/outbox"
 	"github.com/taldrori/bookings/internal/render"
+	"github.com/taldrori/bookings/internal/webhooks"
 )
 
-const portNumber = ":8080"
-
 var app config.Appconfig
 var session *scs.SessionManager
 
+// subcommands run instead of the server when their name is the first argument
+var subcommands = map[string]func(args []string) error{
+	"migrate": runMigrate,
+	"config":  runConfig,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			err := command(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	db, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
 
-	defer close(app.MailChan)
-	fmt.Println("Starting channel for emails")
-	listenForMail()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mail, err := mailer.New(app.Mail, app.SMTP, app.InfoLog)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Starting the outbox dispatcher, sending emails with %s\n", app.Mail.Transport)
+	dispatcher := outbox.New(handlers.Repo.DB, mail.Send)
+	dispatcher.InfoLog = app.InfoLog
+	dispatcher.ErrorLog = app.ErrorLog
+	dispatcher.Start()
+
+	fmt.Println("Starting the webhook dispatcher")
+	hooks := webhooks.New(handlers.Repo.DB)
+	hooks.InfoLog = app.InfoLog
+	hooks.ErrorLog = app.ErrorLog
+	hooks.Start()
+
+	// the database is closed only once the sweeper and the syncer are done with it
+	var background sync.WaitGroup
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		sweepExpiredHolds(ctx, handlers.Repo.DB, time.Minute)
+	}()
+
+	syncer := calsync.New(handlers.Repo.DB)
+	syncer.InfoLog = app.InfoLog
+	syncer.ErrorLog = app.ErrorLog
 
-	fmt.Printf("Starting at port %s\n", portNumber)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		syncer.Run(ctx)
+	}()
+
+	fmt.Printf("Starting at port %d\n", app.Port)
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    fmt.Sprintf(":%d", app.Port),
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		app.ErrorLog.Println(err)
+	case <-ctx.Done():
+		app.InfoLog.Println("Shutting down")
+	}
+	stop()
+
+	ok := shutdown(srv, dispatcher, hooks, &background, db)
+	if err != nil || !ok {
+		os.Exit(1)
+	}
 }
 
 func run() (*driver.DB, error) {
@@ -52,29 +123,23 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]int{})
 
-	// read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useChache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database SSL setting")
-
+	// read the config file, environment and flags
+	loader := config.NewLoader(flag.CommandLine)
 	flag.Parse()
 
-	if *dbName == "" || *dbUser == "" || *dbPass == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+	settings, err := loader.Load(os.Getenv)
+	if err != nil {
+		return nil, err
 	}
+	app = settings
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	app.InProduction = *inProduction
-	app.UseChache = *useChache
+	err = app.DB.Validate()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -92,14 +157,19 @@ func run() (*driver.DB, error) {
 
 	// connect to database
 	log.Println("Connecting to DB")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
-		*dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
+	db, err := connectDB(app.DB)
 	if err != nil {
 		log.Fatal("Cannot connect to DB. Exiting")
 	}
 	log.Println("Connected to DB")
 
+	if db.Driver == "postgres" {
+		err = checkSchema(db, app.DBTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		return nil, err
@@ -107,6 +177,13 @@ func run() (*driver.DB, error) {
 
 	app.TemplateCache = tc
 
+	etc, err := render.CreateEmailTemplateCache()
+	if err != nil {
+		return nil, err
+	}
+
+	app.EmailTemplateCache = etc
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
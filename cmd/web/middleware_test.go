@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -33,3 +35,34 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestNoSurfExemptsAPITokens(t *testing.T) {
+	var myH myHandler
+
+	h := NoSurf(&myH)
+
+	var tests = []struct {
+		name         string
+		path         string
+		auth         string
+		expectedCode int
+	}{
+		{"api with a token", "/api/v1/reservations", "Bearer staff-token", http.StatusOK},
+		{"api without a token", "/api/v1/reservations", "", http.StatusBadRequest},
+		{"site with a token", "/admin/rooms/new", "Bearer staff-token", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", e.path, strings.NewReader("{}"))
+		if e.auth != "" {
+			req.Header.Set("Authorization", e.auth)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("NoSurf returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/driver"
+	"github.com/taldrori/bookings/internal/migrate"
+	"github.com/taldrori/bookings/migrations"
+)
+
+const migrateUsage = `Usage: bookings migrate <command> [flags]
+
+Commands:
+  up             apply the pending migrations
+  down [n]       roll back the latest n migrations, 1 by default
+  status         list the migrations and whether they are applied
+  create <name>  add empty up and down files for a new migration
+
+Flags:`
+
+// runMigrate runs the bookings migrate subcommand with its arguments
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	dir := fs.String("dir", "migrations", "Directory new migrations are created in")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing migrate command")
+	}
+	command := args[0]
+	operands := parseInterspersed(fs, args[1:])
+
+	switch command {
+	case "up", "down", "status", "create":
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+
+	if command == "create" {
+		if len(operands) != 1 {
+			return errors.New("usage: bookings migrate create <name>")
+		}
+		up, down, err := migrate.Create(*dir, operands[0], time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s\nCreated %s\n", up, down)
+		return nil
+	}
+
+	settings, err := loader.Load(os.Getenv)
+	if err != nil {
+		return err
+	}
+	err = settings.DB.Validate()
+	if err != nil {
+		return err
+	}
+	if settings.DB.Driver != "postgres" {
+		return errors.New("migrations are for Postgres; a SQLite database gets its schema when it is opened")
+	}
+
+	m, err := newMigrator(settings.DB)
+	if err != nil {
+		return err
+	}
+	defer m.DB.Close()
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, x := range done {
+			fmt.Printf("Applied %s_%s\n", x.Version, x.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Nothing to apply, the schema is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(operands) > 0 {
+			steps, err = strconv.Atoi(operands[0])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", operands[0])
+			}
+		}
+		done, err := m.Down(ctx, steps)
+		for _, x := range done {
+			fmt.Printf("Rolled back %s_%s\n", x.Version, x.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Nothing to roll back")
+		}
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, x := range statuses {
+			state := "pending"
+			if x.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%s  %-8s %s\n", x.Version, state, x.Name)
+		}
+	}
+
+	return nil
+}
+
+// parseInterspersed parses the flags in args, which may come before or after the operands, and
+// returns the operands
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var operands []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return operands
+		}
+		operands = append(operands, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// newMigrator connects to the database and returns a migrator of the embedded migrations
+func newMigrator(dbConf config.Database) (*migrate.Migrator, error) {
+	loaded, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := connectDB(dbConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(db.SQL, loaded), nil
+}
+
+// checkSchema refuses a database that misses migrations this binary expects
+func checkSchema(db *driver.DB, timeout time.Duration) error {
+	loaded, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return migrate.New(db.SQL, loaded).Check(ctx)
+}
@@ -19,38 +19,111 @@ func routes(app *config.Appconfig) http.Handler {
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
 	mux.Get("/contact", handlers.Repo.Contact)
-	mux.Get("/jonins-quarters", handlers.Repo.Jonins)
-	mux.Get("/hokages-suite", handlers.Repo.Hokages)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendarFeed)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJson)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/choose-rooms", handlers.Repo.PostChooseRooms)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/manage-booking", handlers.Repo.ManageBooking)
+	mux.Post("/manage-booking", handlers.Repo.PostManageBooking)
+	mux.Get("/manage-booking/show", handlers.Repo.ManageBookingShow)
+	mux.Post("/manage-booking/change", handlers.Repo.PostManageBookingChange)
+	mux.Post("/manage-booking/cancel", handlers.Repo.PostManageBookingCancel)
+
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	mux.Get("/api/openapi.json", handlers.Repo.OpenAPI)
+	mux.Get("/api/docs", handlers.Repo.APIDocs)
+	mux.Mount("/api/v1", apiRoutes())
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/resend-email/{id}/do", handlers.Repo.AdminResendEmail)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalander)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalander)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Get("/cancel-reservation/{src}/{id}/do", handlers.Repo.AdminCancelReservation)
+
+		mux.Get("/blocks/new", handlers.Repo.AdminNewBlock)
+		mux.Post("/blocks/new", handlers.Repo.AdminPostNewBlock)
+		mux.Get("/blocks/{id}/show", handlers.Repo.AdminShowBlock)
+		mux.Post("/blocks/{id}", handlers.Repo.AdminPostShowBlock)
+		mux.Get("/delete-block/{id}/do", handlers.Repo.AdminDeleteBlock)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}/show", handlers.Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+		mux.Get("/delete-room/{id}/do", handlers.Repo.AdminDeleteRoom)
+		mux.Get("/rooms/{id}/rates", handlers.Repo.AdminRoomRates)
+		mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostSeasonalRate)
+		mux.Post("/rooms/{id}/rate-modifiers", handlers.Repo.AdminPostRateModifiers)
+		mux.Get("/delete-seasonal-rate/{room_id}/{id}/do", handlers.Repo.AdminDeleteSeasonalRate)
+		mux.Get("/rooms/{id}/stay-rules", handlers.Repo.AdminRoomStayRules)
+		mux.Post("/rooms/{id}/stay-rules", handlers.Repo.AdminPostStayRule)
+		mux.Get("/delete-stay-rule/{room_id}/{id}/do", handlers.Repo.AdminDeleteStayRule)
+		mux.Get("/rooms/{id}/calendars", handlers.Repo.AdminRoomCalendars)
+		mux.Post("/rooms/{id}/calendars", handlers.Repo.AdminPostCalendarFeed)
+		mux.Get("/sync-calendar-feed/{room_id}/{id}/do", handlers.Repo.AdminSyncCalendarFeed)
+		mux.Get("/delete-calendar-feed/{room_id}/{id}/do", handlers.Repo.AdminDeleteCalendarFeed)
+		mux.Get("/reset-calendar-token/{id}/do", handlers.Repo.AdminResetCalendarToken)
+
+		mux.Get("/amenities", handlers.Repo.AdminAmenities)
+		mux.Post("/amenities", handlers.Repo.AdminPostAmenities)
+		mux.Get("/delete-amenity/{id}/do", handlers.Repo.AdminDeleteAmenity)
+
+		mux.Get("/api-tokens", handlers.Repo.AdminAPITokens)
+		mux.Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+		mux.Get("/delete-api-token/{id}/do", handlers.Repo.AdminDeleteAPIToken)
+
+		mux.Get("/webhooks", handlers.Repo.AdminWebhooks)
+		mux.Post("/webhooks", handlers.Repo.AdminPostWebhook)
+		mux.Get("/delete-webhook/{id}/do", handlers.Repo.AdminDeleteWebhook)
+		mux.Get("/webhooks/deliveries", handlers.Repo.AdminWebhookDeliveries)
+		mux.Get("/resend-webhook-delivery/{id}/do", handlers.Repo.AdminResendWebhookDelivery)
+	})
+
+	return mux
+}
+
+// apiRoutes is the JSON API. Reservations and restrictions are for the staff, whose programs
+// send the bearer token of an API token.
+func apiRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Get("/rooms", handlers.Repo.APIRooms)
+	mux.Get("/availability", handlers.Repo.APIAvailability)
+	mux.Get("/availability/month", handlers.Repo.APIAvailabilityMonth)
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(handlers.Repo.RequireAPIToken)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.Get("/reservations/{code}", handlers.Repo.APIReservation)
+		mux.Post("/reservations/{code}/cancel", handlers.Repo.APICancelReservation)
+		mux.Get("/restrictions", handlers.Repo.APIRestrictions)
 	})
 
 	return mux
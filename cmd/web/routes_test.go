@@ -1,11 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/handlers"
+	"github.com/taldrori/bookings/internal/helpers"
 )
 
 func TestRoutes(t *testing.T) {
@@ -20,3 +27,54 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not chi.Mux, but is %T", v))
 	}
 }
+
+func TestAdminRoutesNeedLogin(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+
+	mux := routes(&app)
+
+	err := chi.Walk(mux.(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if method != "GET" || !strings.HasPrefix(route, "/admin/") {
+			return nil
+		}
+
+		path := strings.NewReplacer("{id}", "1", "{room_id}", "1", "{src}", "all").Replace(route)
+		req := httptest.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+			t.Errorf("expected %s %s to send a guest to the login page, got %d to %q", method, route, rr.Code, rr.Header().Get("Location"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIRoutesAreDocumented(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handlers.Repo.OpenAPI).ServeHTTP(rr, req)
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = chi.Walk(apiRoutes().(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if _, ok := doc.Paths["/api/v1"+route][strings.ToLower(method)]; !ok {
+			t.Errorf("%s /api/v1%s is not in the OpenAPI document", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/taldrori/bookings/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `Usage: bookings config show [flags]
+
+Prints the settings the server would run with, from the config file, BOOKINGS_* environment
+variables and flags, with the passwords hidden.
+
+Flags:`
+
+// runConfig runs the bookings config subcommand with its arguments
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), configUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "show" {
+		fs.Usage()
+		return errors.New("usage: bookings config show [flags]")
+	}
+	fs.Parse(args[1:])
+
+	settings, err := loader.Load(os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	err = enc.Encode(settings.Redacted())
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
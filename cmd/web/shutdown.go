@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/taldrori/bookings/internal/driver"
+	"github.com/taldrori/bookings/internal/outbox"
+	"github.com/taldrori/bookings/internal/webhooks"
+)
+
+// shutdown stops the server in order within app.ShutdownTimeout: it stops accepting requests and
+// lets the ones in flight finish, sends the emails and the webhook deliveries that are due, waits
+// for the background jobs, whose context is already cancelled, then closes the database. A
+// dispatcher out of time may still be recording its last attempts, so the database is then left
+// for the exit to close. It reports whether everything was flushed.
+func shutdown(srv *http.Server, dispatcher *outbox.Dispatcher, hooks *webhooks.Dispatcher, background *sync.WaitGroup, db *driver.DB) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	ok := true
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		app.ErrorLog.Println("Cutting off the requests still running:", err)
+		srv.Close()
+		ok = false
+	} else {
+		app.InfoLog.Println("Finished the requests in flight")
+	}
+
+	dispatched := true
+
+	stats, finished := dispatcher.Stop(ctx)
+	app.InfoLog.Printf("Flushed the outbox: %d sent, %d to retry, %d failed\n", stats.Delivered, stats.Retried, stats.Failed)
+	if !finished {
+		app.ErrorLog.Println("Ran out of time flushing the outbox, the emails left in it are sent on the next start")
+		ok = false
+		dispatched = false
+	}
+
+	hookStats, finished := hooks.Stop(ctx)
+	app.InfoLog.Printf("Flushed the webhooks: %d delivered, %d to retry, %d failed\n", hookStats.Delivered, hookStats.Retried, hookStats.Failed)
+	if !finished {
+		app.ErrorLog.Println("Ran out of time flushing the webhooks, the deliveries left are made on the next start")
+		ok = false
+		dispatched = false
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+		app.InfoLog.Println("Stopped the background jobs")
+	case <-ctx.Done():
+		app.ErrorLog.Println("Ran out of time waiting for the background jobs")
+		ok = false
+	}
+
+	if !dispatched {
+		app.ErrorLog.Println("Leaving the database open, the dispatchers may still be using it")
+		return false
+	}
+
+	err = db.SQL.Close()
+	if err != nil {
+		app.ErrorLog.Println(err)
+		ok = false
+	} else {
+		app.InfoLog.Println("Closed the database")
+	}
+
+	return ok
+}
@@ -0,0 +1,221 @@
+// Package calsync imports the calendars other booking sites publish of the rooms, as blocks
+// that keep a room from being booked here for the dates it is taken there
+package calsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/taldrori/bookings/internal/ical"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+)
+
+// maxFeedSize bounds how much of a calendar is read
+const maxFeedSize = 10 << 20
+
+// maxTextLength is the length of the reason and the external UID columns of a block
+const maxTextLength = 255
+
+// Syncer polls the external calendars of the rooms and turns their events into blocks
+type Syncer struct {
+	DB     repository.DatabaseRepo
+	Client *http.Client
+	// Interval is how often every calendar is synced
+	Interval time.Duration
+
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// New returns a syncer of the external calendars in db with the default settings
+func New(db repository.DatabaseRepo) *Syncer {
+	return &Syncer{
+		DB:       db,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Interval: 15 * time.Minute,
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+}
+
+// Run syncs all the calendars right away, then every Interval until ctx is done
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every external calendar. A calendar that fails doesn't stop the others.
+func (s *Syncer) SyncAll(ctx context.Context) {
+	feeds, err := s.DB.AllCalendarFeeds(ctx)
+	if err != nil {
+		s.ErrorLog.Println("Listing the external calendars:", err)
+		return
+	}
+
+	for _, feed := range feeds {
+		if ctx.Err() != nil {
+			return
+		}
+
+		result, err := s.Sync(ctx, feed)
+		if err != nil && ctx.Err() != nil {
+			// cut short by the shutdown; the calendar is synced again on the next start
+			return
+		}
+		if err != nil {
+			s.ErrorLog.Printf("Syncing the calendar %s of %s: %v\n", feed.Name, feed.Room.RoomName, err)
+			continue
+		}
+		if result.Added+result.Moved+result.Removed+len(result.Conflicts) > 0 {
+			s.InfoLog.Printf("Synced the calendar %s of %s: %d added, %d moved, %d removed, %d conflicts\n",
+				feed.Name, feed.Room.RoomName, result.Added, result.Moved, result.Removed, len(result.Conflicts))
+		}
+	}
+}
+
+// Sync fetches an external calendar and makes the blocks of its room match its events. When
+// it can't, the blocks are left as they are and the error is recorded on the feed. Events
+// that fall on dates the room is already taken for are recorded too.
+func (s *Syncer) Sync(ctx context.Context, feed models.CalendarFeed) (models.CalendarFeedSync, error) {
+	var result models.CalendarFeedSync
+
+	c, err := s.fetch(ctx, feed.URL)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.recordError(ctx, feed, err.Error())
+		}
+		return result, err
+	}
+
+	result, err = s.DB.SyncCalendarFeed(ctx, feed.ID, Blocks(feed, c.Events))
+	if err != nil {
+		return result, err
+	}
+
+	var notes []string
+	if len(result.Conflicts) > 0 {
+		dates := make([]string, 0, len(result.Conflicts))
+		for _, x := range result.Conflicts {
+			dates = append(dates, fmt.Sprintf("%s - %s", x.StartDate.Format("01/02/2006"), x.EndDate.Format("01/02/2006")))
+		}
+		notes = append(notes, fmt.Sprintf("The room is already taken for %s", strings.Join(dates, ", ")))
+	}
+
+	// the rules of recurring events aren't expanded, so only their first dates are blocked
+	recurring := 0
+	for _, e := range c.Events {
+		if e.Recurring && e.Status != ical.StatusCancelled {
+			recurring++
+		}
+	}
+	if recurring == 1 {
+		notes = append(notes, "A repeating event is blocked on its first dates only")
+	} else if recurring > 1 {
+		notes = append(notes, fmt.Sprintf("%d repeating events are blocked on their first dates only", recurring))
+	}
+
+	if len(notes) > 0 {
+		s.recordError(ctx, feed, strings.Join(notes, ". "))
+	}
+
+	return result, nil
+}
+
+func (s *Syncer) recordError(ctx context.Context, feed models.CalendarFeed, lastError string) {
+	err := s.DB.SetCalendarFeedError(ctx, feed.ID, lastError)
+	if err != nil {
+		s.ErrorLog.Println("Recording the error of a calendar sync:", err)
+	}
+}
+
+// fetch downloads and reads the calendar at url
+func (s *Syncer) fetch(ctx context.Context, url string) (ical.Calendar, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ical.Calendar{}, err
+	}
+	req.Header.Set("Accept", ical.ContentType)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return ical.Calendar{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ical.Calendar{}, fmt.Errorf("the calendar address answered %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// Blocks turns the events of the calendar of feed into blocks of its room. Cancelled events
+// are left out. An event without a UID is known by its dates, and one whose UID was already
+// used, like an occurrence of a recurring event, by its UID and its start.
+func Blocks(feed models.CalendarFeed, events []ical.Event) []models.RoomRestriction {
+	var blocks []models.RoomRestriction
+	seen := make(map[string]bool)
+
+	for _, e := range events {
+		if e.Status == ical.StatusCancelled {
+			continue
+		}
+
+		uid := e.UID
+		if uid == "" {
+			uid = fmt.Sprintf("%s-%s", e.Start.Format("20060102"), e.End.Format("20060102"))
+		}
+		uid = truncate(uid)
+		if seen[uid] {
+			uid = truncate(fmt.Sprintf("%s#%s", e.UID, e.Start.Format("20060102")))
+			if seen[uid] {
+				continue
+			}
+		}
+		seen[uid] = true
+
+		reason := feed.Name
+		if e.Summary != "" {
+			reason = fmt.Sprintf("%s: %s", feed.Name, e.Summary)
+		}
+
+		blocks = append(blocks, models.RoomRestriction{
+			StartDate:   e.Start,
+			EndDate:     e.End,
+			RoomID:      feed.RoomID,
+			Reason:      truncate(reason),
+			FeedID:      feed.ID,
+			ExternalUID: uid,
+		})
+	}
+
+	return blocks
+}
+
+// truncate cuts s to the length of a text column, without splitting a UTF-8 character
+func truncate(s string) string {
+	if len(s) <= maxTextLength {
+		return s
+	}
+	cut := maxTextLength
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut]
+}
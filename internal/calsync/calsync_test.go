@@ -0,0 +1,251 @@
+package calsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/ical"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/repository/dbrepo/dbtest"
+)
+
+// day returns a day of January 2050
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+// testFeed serves the calendar it is given, or a page that isn't a calendar
+type testFeed struct {
+	mu   sync.Mutex
+	body string
+}
+
+func (f *testFeed) set(events ...ical.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body = string(ical.Calendar{Events: events}.Bytes())
+}
+
+func (f *testFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprint(w, f.body)
+}
+
+// newTestSync returns a SQLite repository where room 1 is booked from the 10th to the 12th of
+// January 2050 and has an external calendar served by the returned feed
+func newTestSync(t *testing.T) (repository.DatabaseRepo, models.CalendarFeed, *testFeed) {
+	t.Helper()
+
+	repo := dbtest.NewSQLiteRepo(t)
+	ctx := context.Background()
+
+	_, _, err := repo.CreateReservation(ctx, models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  day(10),
+		EndDate:    day(12),
+		TotalPrice: 10000,
+		Rooms:      []models.ReservationRoom{{RoomID: 1, TotalPrice: 10000}},
+	}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	feed := &testFeed{}
+	srv := httptest.NewServer(feed)
+	t.Cleanup(srv.Close)
+
+	id, err := repo.InsertCalendarFeed(ctx, models.CalendarFeed{RoomID: 1, Name: "Other Site", URL: srv.URL + "/1.ics"})
+	if err != nil {
+		t.Fatalf("InsertCalendarFeed: %v", err)
+	}
+	f, err := repo.GetCalendarFeedByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetCalendarFeedByID: %v", err)
+	}
+
+	return repo, f, feed
+}
+
+// importedBlocks returns the blocks of room 1 imported from the external calendar
+func importedBlocks(t *testing.T, repo repository.DatabaseRepo) map[string]models.RoomRestriction {
+	t.Helper()
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(context.Background(), 1, day(1), day(31))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make(map[string]models.RoomRestriction)
+	for _, x := range restrictions {
+		if x.Imported() {
+			blocks[x.ExternalUID] = x
+		}
+	}
+	return blocks
+}
+
+func TestSync(t *testing.T) {
+	repo, f, feed := newTestSync(t)
+	ctx := context.Background()
+	s := New(repo)
+
+	feed.set(
+		ical.Event{UID: "a", Start: day(1), End: day(3), Summary: "Reserved"},
+		ical.Event{UID: "b", Start: day(5), End: day(7)},
+		ical.Event{UID: "c", Start: day(11), End: day(13)},
+		ical.Event{UID: "d", Start: day(20), End: day(22), Status: ical.StatusCancelled},
+	)
+
+	result, err := s.Sync(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || result.Moved != 0 || result.Removed != 0 || len(result.Conflicts) != 1 {
+		t.Errorf("expected 2 blocks added and 1 conflict, got %+v", result)
+	}
+
+	blocks := importedBlocks(t, repo)
+	if len(blocks) != 2 || !blocks["a"].StartDate.Equal(day(1)) || !blocks["b"].EndDate.Equal(day(7)) {
+		t.Errorf("expected the blocks of events a and b, got %+v", blocks)
+	}
+	if blocks["a"].Reason != "Other Site: Reserved" || blocks["b"].Reason != "Other Site" {
+		t.Errorf("expected the reasons to name the site, got %q and %q", blocks["a"].Reason, blocks["b"].Reason)
+	}
+
+	// the event on booked dates is reported on the feed
+	f, _ = repo.GetCalendarFeedByID(ctx, f.ID)
+	if !strings.Contains(f.LastError, "01/11/2050 - 01/13/2050") || f.LastSyncedAt.IsZero() {
+		t.Errorf("expected the conflict to be recorded, got %q synced at %s", f.LastError, f.LastSyncedAt)
+	}
+
+	// the imported blocks keep the room from being booked
+	available, _, err := repo.SearchAvailabilityByDatesByRoomID(ctx, day(5), day(6), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("expected room 1 to be blocked by the external calendar")
+	}
+
+	// a moves, b is gone, c no longer clashes, and e is new
+	feed.set(
+		ical.Event{UID: "a", Start: day(2), End: day(4), Summary: "Reserved"},
+		ical.Event{UID: "c", Start: day(12), End: day(14)},
+		ical.Event{UID: "e", Start: day(5), End: day(6)},
+	)
+
+	result, err = s.Sync(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || result.Moved != 1 || result.Removed != 1 || len(result.Conflicts) != 0 {
+		t.Errorf("expected 2 blocks added, 1 moved and 1 removed, got %+v", result)
+	}
+
+	blocks = importedBlocks(t, repo)
+	if len(blocks) != 3 || !blocks["a"].StartDate.Equal(day(2)) || !blocks["c"].StartDate.Equal(day(12)) || !blocks["e"].EndDate.Equal(day(6)) {
+		t.Errorf("expected the blocks of events a, c and e, got %+v", blocks)
+	}
+	f, _ = repo.GetCalendarFeedByID(ctx, f.ID)
+	if f.LastError != "" {
+		t.Errorf("expected the last error to be cleared, got %q", f.LastError)
+	}
+
+	// a calendar that can't be read leaves the blocks alone
+	feed.mu.Lock()
+	feed.body = "<html><body>Please log in</body></html>"
+	feed.mu.Unlock()
+
+	_, err = s.Sync(ctx, f)
+	if err == nil {
+		t.Error("expected an error for a page that isn't a calendar")
+	}
+	if len(importedBlocks(t, repo)) != 3 {
+		t.Error("expected the blocks to be kept when the calendar can't be read")
+	}
+	f, _ = repo.GetCalendarFeedByID(ctx, f.ID)
+	if f.LastError != ical.ErrNotCalendar.Error() {
+		t.Errorf("expected the error to be recorded, got %q", f.LastError)
+	}
+
+	// an empty calendar frees the room
+	feed.set()
+	s.SyncAll(ctx)
+	if blocks := importedBlocks(t, repo); len(blocks) != 0 {
+		t.Errorf("expected no imported block left, got %+v", blocks)
+	}
+
+	// deleting the feed deletes its blocks
+	feed.set(ical.Event{UID: "a", Start: day(2), End: day(4)})
+	_, _ = s.Sync(ctx, f)
+	err = repo.DeleteCalendarFeed(ctx, f.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks := importedBlocks(t, repo); len(blocks) != 0 {
+		t.Errorf("expected the blocks to go with their feed, got %+v", blocks)
+	}
+}
+
+func TestSyncRecurring(t *testing.T) {
+	repo, f, feed := newTestSync(t)
+	ctx := context.Background()
+
+	feed.mu.Lock()
+	feed.body = "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500103\r\n" +
+		"RRULE:FREQ=MONTHLY;COUNT=3\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	feed.mu.Unlock()
+
+	_, err := New(repo).Sync(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := importedBlocks(t, repo)
+	if len(blocks) != 1 || !blocks["a"].StartDate.Equal(day(1)) {
+		t.Errorf("expected the first occurrence to be blocked, got %+v", blocks)
+	}
+	f, _ = repo.GetCalendarFeedByID(ctx, f.ID)
+	if f.LastError != "A repeating event is blocked on its first dates only" {
+		t.Errorf("expected the recurring event to be reported, got %q", f.LastError)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	feed := models.CalendarFeed{ID: 3, RoomID: 2, Name: "Other Site"}
+
+	blocks := Blocks(feed, []ical.Event{
+		{UID: "weekly", Start: day(1), End: day(2)},
+		{UID: "weekly", Start: day(8), End: day(9)},
+		{Start: day(15), End: day(17)},
+		{UID: strings.Repeat("x", 300), Start: day(20), End: day(21), Summary: "Closed"},
+	})
+
+	var uids []string
+	for _, x := range blocks {
+		if x.RoomID != 2 || x.FeedID != 3 {
+			t.Errorf("expected a block of room 2 from feed 3, got %+v", x)
+		}
+		uids = append(uids, x.ExternalUID)
+	}
+
+	expected := []string{"weekly", "weekly#20500108", "20500115-20500117", strings.Repeat("x", maxTextLength)}
+	if strings.Join(uids, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the UIDs %v, got %v", expected, uids)
+	}
+	if blocks[3].Reason != "Other Site: Closed" {
+		t.Errorf("expected the summary in the reason, got %q", blocks[3].Reason)
+	}
+}
@@ -3,18 +3,57 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/taldrori/bookings/internal/models"
 )
 
 // AppConfig holds the application config
 type Appconfig struct {
-	UseChache     bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseChache          bool                          `yaml:"cache"`
+	TemplateCache      map[string]*template.Template `yaml:"-"`
+	EmailTemplateCache map[string]*template.Template `yaml:"-"`
+	InfoLog            *log.Logger                   `yaml:"-"`
+	ErrorLog           *log.Logger                   `yaml:"-"`
+	InProduction       bool                          `yaml:"production"`
+	Session            *scs.SessionManager           `yaml:"-"`
+	HoldDuration       time.Duration                 `yaml:"hold_duration"`
+	DBTimeout          time.Duration                 `yaml:"db_timeout"`
+	Port               int                           `yaml:"port"`
+	// ShutdownTimeout bounds how long requests and queued emails get to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DB              Database      `yaml:"db"`
+	Mail            Mail          `yaml:"mail"`
+	SMTP            SMTP          `yaml:"smtp"`
+}
+
+// Database is where the database is. Driver is "postgres" or "sqlite"; File is only used by
+// sqlite, and the others only by postgres.
+type Database struct {
+	Driver       string `yaml:"driver"`
+	File         string `yaml:"file"`
+	Host         string `yaml:"host"`
+	Port         string `yaml:"port"`
+	Name         string `yaml:"name"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	SSLMode      string `yaml:"sslmode"`
+}
+
+// Mail is how emails are sent. Transport is "smtp", "file" or "log"; Dir is the maildir the
+// file transport drops emails in.
+type Mail struct {
+	Transport string `yaml:"transport"`
+	Dir       string `yaml:"dir"`
+}
+
+// SMTP is the mail server emails are sent through. Encryption is "none", "starttls" or "tls".
+type SMTP struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	Encryption   string `yaml:"encryption"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
 }
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in the config shown to users
+const redacted = "********"
+
+// Default returns the settings used when nothing else sets them
+func Default() Appconfig {
+	return Appconfig{
+		UseChache:       true,
+		InProduction:    true,
+		HoldDuration:    15 * time.Minute,
+		DBTimeout:       3 * time.Second,
+		Port:            8080,
+		ShutdownTimeout: 30 * time.Second,
+		DB: Database{
+			Driver:  "postgres",
+			File:    "bookings.db",
+			Host:    "localhost",
+			Port:    "5432",
+			SSLMode: "disable",
+		},
+		Mail: Mail{
+			Transport: "smtp",
+			Dir:       "mail",
+		},
+		SMTP: SMTP{
+			Host:       "localhost",
+			Port:       1025,
+			Encryption: "none",
+		},
+	}
+}
+
+// Loader builds the settings from, in increasing priority, the defaults, a YAML config file,
+// BOOKINGS_* environment variables and command line flags
+type Loader struct {
+	fs    *flag.FlagSet
+	flags Appconfig
+	file  string
+}
+
+// NewLoader defines the config flags on fs. Load is called once fs has parsed the command line.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{fs: fs, flags: Default()}
+
+	fs.StringVar(&l.file, "config", "", "YAML config file, also read from BOOKINGS_CONFIG")
+	bindFlags(fs, &l.flags)
+
+	return l
+}
+
+// bindFlags defines the flags of the settings of c on fs
+func bindFlags(fs *flag.FlagSet, c *Appconfig) {
+	fs.BoolVar(&c.InProduction, "production", c.InProduction, "Application is in production")
+	fs.BoolVar(&c.UseChache, "cache", c.UseChache, "Use template cache")
+	fs.IntVar(&c.Port, "port", c.Port, "Port the server listens on")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdowntimeout", c.ShutdownTimeout, "Longest requests and queued emails get to finish on shutdown, e.g. 30s")
+	fs.DurationVar(&c.HoldDuration, "holdduration", c.HoldDuration, "How long a room is held while a guest fills in the reservation form, e.g. 15m")
+	fs.DurationVar(&c.DBTimeout, "dbtimeout", c.DBTimeout, "Longest a database query may run, e.g. 3s")
+
+	fs.StringVar(&c.DB.Driver, "dbdriver", c.DB.Driver, "Database driver: postgres or sqlite")
+	fs.StringVar(&c.DB.File, "dbfile", c.DB.File, "SQLite database file, used with -dbdriver=sqlite")
+	fs.StringVar(&c.DB.Host, "dbhost", c.DB.Host, "Database host")
+	fs.StringVar(&c.DB.Name, "dbname", c.DB.Name, "Database name")
+	fs.StringVar(&c.DB.User, "dbuser", c.DB.User, "Database user")
+	fs.StringVar(&c.DB.Password, "dbpass", c.DB.Password, "Database password; prefer -dbpass-file, as flags show in ps")
+	fs.StringVar(&c.DB.PasswordFile, "dbpass-file", c.DB.PasswordFile, "File holding the database password, which takes precedence over -dbpass")
+	fs.StringVar(&c.DB.Port, "dbport", c.DB.Port, "Database port")
+	fs.StringVar(&c.DB.SSLMode, "dbssl", c.DB.SSLMode, "Database SSL setting")
+
+	fs.StringVar(&c.Mail.Transport, "mailtransport", c.Mail.Transport, "How emails are sent: smtp, file or log")
+	fs.StringVar(&c.Mail.Dir, "maildir", c.Mail.Dir, "Maildir emails are dropped in, used with -mailtransport=file")
+
+	fs.StringVar(&c.SMTP.Host, "smtphost", c.SMTP.Host, "Mail server host")
+	fs.IntVar(&c.SMTP.Port, "smtpport", c.SMTP.Port, "Mail server port")
+	fs.StringVar(&c.SMTP.Encryption, "smtpencryption", c.SMTP.Encryption, "Mail server encryption: none, starttls or tls")
+	fs.StringVar(&c.SMTP.User, "smtpuser", c.SMTP.User, "Mail server user")
+	fs.StringVar(&c.SMTP.PasswordFile, "smtppass-file", c.SMTP.PasswordFile, "File holding the mail server password")
+}
+
+// bindEnv defines the environment variables of the settings of c, as flags named after them
+func bindEnv(c *Appconfig) *flag.FlagSet {
+	env := flag.NewFlagSet("environment", flag.ContinueOnError)
+
+	env.BoolVar(&c.InProduction, "BOOKINGS_PRODUCTION", c.InProduction, "")
+	env.BoolVar(&c.UseChache, "BOOKINGS_CACHE", c.UseChache, "")
+	env.IntVar(&c.Port, "BOOKINGS_PORT", c.Port, "")
+	env.DurationVar(&c.ShutdownTimeout, "BOOKINGS_SHUTDOWN_TIMEOUT", c.ShutdownTimeout, "")
+	env.DurationVar(&c.HoldDuration, "BOOKINGS_HOLD_DURATION", c.HoldDuration, "")
+	env.DurationVar(&c.DBTimeout, "BOOKINGS_DB_TIMEOUT", c.DBTimeout, "")
+
+	env.StringVar(&c.DB.Driver, "BOOKINGS_DB_DRIVER", c.DB.Driver, "")
+	env.StringVar(&c.DB.File, "BOOKINGS_DB_FILE", c.DB.File, "")
+	env.StringVar(&c.DB.Host, "BOOKINGS_DB_HOST", c.DB.Host, "")
+	env.StringVar(&c.DB.Port, "BOOKINGS_DB_PORT", c.DB.Port, "")
+	env.StringVar(&c.DB.Name, "BOOKINGS_DB_NAME", c.DB.Name, "")
+	env.StringVar(&c.DB.User, "BOOKINGS_DB_USER", c.DB.User, "")
+	env.StringVar(&c.DB.Password, "BOOKINGS_DB_PASSWORD", c.DB.Password, "")
+	env.StringVar(&c.DB.PasswordFile, "BOOKINGS_DB_PASSWORD_FILE", c.DB.PasswordFile, "")
+	env.StringVar(&c.DB.SSLMode, "BOOKINGS_DB_SSLMODE", c.DB.SSLMode, "")
+
+	env.StringVar(&c.Mail.Transport, "BOOKINGS_MAIL_TRANSPORT", c.Mail.Transport, "")
+	env.StringVar(&c.Mail.Dir, "BOOKINGS_MAIL_DIR", c.Mail.Dir, "")
+
+	env.StringVar(&c.SMTP.Host, "BOOKINGS_SMTP_HOST", c.SMTP.Host, "")
+	env.IntVar(&c.SMTP.Port, "BOOKINGS_SMTP_PORT", c.SMTP.Port, "")
+	env.StringVar(&c.SMTP.Encryption, "BOOKINGS_SMTP_ENCRYPTION", c.SMTP.Encryption, "")
+	env.StringVar(&c.SMTP.User, "BOOKINGS_SMTP_USER", c.SMTP.User, "")
+	env.StringVar(&c.SMTP.Password, "BOOKINGS_SMTP_PASSWORD", c.SMTP.Password, "")
+	env.StringVar(&c.SMTP.PasswordFile, "BOOKINGS_SMTP_PASSWORD_FILE", c.SMTP.PasswordFile, "")
+
+	return env
+}
+
+// Load returns the settings, reading environment variables with getenv
+func (l *Loader) Load(getenv func(string) string) (Appconfig, error) {
+	c := Default()
+
+	file := l.file
+	if file == "" {
+		file = getenv("BOOKINGS_CONFIG")
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return c, err
+		}
+
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&c)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return c, fmt.Errorf("reading config file %s: %w", file, err)
+		}
+	}
+
+	env := bindEnv(&c)
+	var err error
+	set := map[string]bool{}
+	env.VisitAll(func(f *flag.Flag) {
+		value := getenv(f.Name)
+		if value == "" || err != nil {
+			return
+		}
+		if setErr := env.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", f.Name, setErr)
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return c, err
+	}
+	overrideSecretFile(set, "BOOKINGS_DB_PASSWORD", "BOOKINGS_DB_PASSWORD_FILE", &c.DB.PasswordFile)
+	overrideSecretFile(set, "BOOKINGS_SMTP_PASSWORD", "BOOKINGS_SMTP_PASSWORD_FILE", &c.SMTP.PasswordFile)
+
+	// the flags given on the command line, copied over from the ones l parsed
+	given := flag.NewFlagSet("flags", flag.ContinueOnError)
+	bindFlags(given, &c)
+	set = map[string]bool{}
+	l.fs.Visit(func(f *flag.Flag) {
+		if given.Lookup(f.Name) != nil && err == nil {
+			err = given.Set(f.Name, f.Value.String())
+			set[f.Name] = true
+		}
+	})
+	if err != nil {
+		return c, err
+	}
+	overrideSecretFile(set, "dbpass", "dbpass-file", &c.DB.PasswordFile)
+
+	c.DB.Password, err = readSecret(c.DB.PasswordFile, c.DB.Password)
+	if err != nil {
+		return c, err
+	}
+	c.SMTP.Password, err = readSecret(c.SMTP.PasswordFile, c.SMTP.Password)
+	if err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// overrideSecretFile forgets the file of a secret set by a lower layer when a layer sets the
+// secret itself but not its file, so the highest layer setting either one wins
+func overrideSecretFile(set map[string]bool, value, file string, c *string) {
+	if set[value] && !set[file] {
+		*c = ""
+	}
+}
+
+// readSecret returns the content of file without the trailing newline, or value when there is
+// no file
+func readSecret(file, value string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Validate reports a database that can't be connected to for lack of settings
+func (d Database) Validate() error {
+	switch d.Driver {
+	case "postgres":
+		if d.Name == "" || d.User == "" || d.Password == "" {
+			return errors.New("Missing required flags: the database name, user and password are needed, e.g. -dbname, -dbuser and -dbpass-file")
+		}
+	case "sqlite":
+		if d.File == "" {
+			return errors.New("Missing required flags: -dbfile is needed")
+		}
+	default:
+		return fmt.Errorf("Unknown database driver %q", d.Driver)
+	}
+
+	return nil
+}
+
+// Redacted returns the settings with their secrets hidden, so they can be shown
+func (a Appconfig) Redacted() Appconfig {
+	if a.DB.Password != "" {
+		a.DB.Password = redacted
+	}
+	if a.SMTP.Password != "" {
+		a.SMTP.Password = redacted
+	}
+
+	return a
+}
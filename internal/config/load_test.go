@@ -0,0 +1,184 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func load(t *testing.T, args []string, env map[string]string) (Appconfig, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := NewLoader(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l.Load(func(key string) string { return env[key] })
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := load(t, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 8080 || c.HoldDuration != 15*time.Minute || c.DB.Driver != "postgres" || c.SMTP.Port != 1025 {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	file := writeFile(t, "bookings.yml", `
+port: 9000
+hold_duration: 30m
+db:
+  host: db.internal
+  name: from_file
+  user: from_file
+smtp:
+  host: mail.internal
+`)
+
+	env := map[string]string{
+		"BOOKINGS_CONFIG":  file,
+		"BOOKINGS_DB_NAME": "from_env",
+		"BOOKINGS_PORT":    "9100",
+	}
+
+	c, err := load(t, []string{"-dbname=from_flag", "-holdduration=5m"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"file only", c.DB.Host, "db.internal"},
+		{"file only", c.SMTP.Host, "mail.internal"},
+		{"file and default", c.DB.User, "from_file"},
+		{"env over file", c.Port, 9100},
+		{"flag over env", c.DB.Name, "from_flag"},
+		{"flag over file", c.HoldDuration, 5 * time.Minute},
+		{"default", c.DB.SSLMode, "disable"},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dbPass := writeFile(t, "db-password", "s3cret\n")
+	smtpPass := writeFile(t, "smtp-password", "m4il")
+
+	c, err := load(t, []string{"-dbpass=ignored", "-dbpass-file=" + dbPass},
+		map[string]string{"BOOKINGS_SMTP_PASSWORD_FILE": smtpPass})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DB.Password != "s3cret" {
+		t.Errorf("expected the database password from its file, got %q", c.DB.Password)
+	}
+	if c.SMTP.Password != "m4il" {
+		t.Errorf("expected the mail password from its file, got %q", c.SMTP.Password)
+	}
+
+	r := c.Redacted()
+	if r.DB.Password != redacted || r.SMTP.Password != redacted {
+		t.Error("expected the passwords to be redacted")
+	}
+	if c.DB.Password != "s3cret" {
+		t.Error("expected redacting not to change the settings")
+	}
+}
+
+func TestLoadSecretLayers(t *testing.T) {
+	dbPass := writeFile(t, "db-password", "from_file")
+	file := writeFile(t, "bookings.yml", "db:\n  password_file: "+dbPass+"\n")
+
+	c, err := load(t, nil, map[string]string{"BOOKINGS_CONFIG": file, "BOOKINGS_DB_PASSWORD": "from_env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.Password != "from_env" {
+		t.Errorf("expected the environment password over the config file's password file, got %q", c.DB.Password)
+	}
+
+	c, err = load(t, []string{"-dbpass=from_flag"},
+		map[string]string{"BOOKINGS_DB_PASSWORD_FILE": dbPass})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.Password != "from_flag" {
+		t.Errorf("expected the flag password over the environment password file, got %q", c.DB.Password)
+	}
+
+	c, err = load(t, nil, map[string]string{"BOOKINGS_CONFIG": file, "BOOKINGS_DB_PASSWORD_FILE": dbPass})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.Password != "from_file" {
+		t.Errorf("expected the password from its file, got %q", c.DB.Password)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := load(t, []string{"-config=" + writeFile(t, "bookings.yml", "db:\n  hots: typo\n")}, nil)
+	if err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Errorf("expected an unknown setting to be reported, got %v", err)
+	}
+
+	_, err = load(t, nil, map[string]string{"BOOKINGS_PORT": "eighty"})
+	if err == nil || !strings.Contains(err.Error(), "BOOKINGS_PORT") {
+		t.Errorf("expected a bad environment variable to be reported, got %v", err)
+	}
+
+	_, err = load(t, []string{"-dbpass-file=/does/not/exist"}, nil)
+	if err == nil {
+		t.Error("expected a missing password file to be reported")
+	}
+}
+
+func TestDatabaseValidate(t *testing.T) {
+	var tests = []struct {
+		name  string
+		db    Database
+		valid bool
+	}{
+		{"postgres", Database{Driver: "postgres", Name: "bookings", User: "u", Password: "p"}, true},
+		{"postgres without password", Database{Driver: "postgres", Name: "bookings", User: "u"}, false},
+		{"sqlite", Database{Driver: "sqlite", File: "bookings.db"}, true},
+		{"sqlite without file", Database{Driver: "sqlite"}, false},
+		{"unknown driver", Database{Driver: "mysql"}, false},
+	}
+
+	for _, e := range tests {
+		err := e.db.Validate()
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", e.name, e.valid, err)
+		}
+	}
+}
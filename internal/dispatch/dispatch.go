@@ -0,0 +1,223 @@
+// Package dispatch works through a queue of jobs kept in the database, like the emails of the
+// outbox or the deliveries of the webhooks. Due jobs are claimed a batch at a time and made by a
+// pool of workers; failed ones are retried with exponential backoff, and given up on after too
+// many attempts.
+package dispatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a claimed job of a queue. Do makes it, then the attempt is recorded with Done, Retry or
+// Fail.
+type Job interface {
+	// Attempts is how many times the job was made before
+	Attempts() int
+	Do(ctx context.Context) error
+	Done(ctx context.Context) error
+	// Retry records the failed attempt, err, and when to make the job again
+	Retry(ctx context.Context, err error, next time.Time) error
+	// Fail records the last attempt, err, and marks the job as failed
+	Fail(ctx context.Context, err error) error
+	// String names the job in the log, like "the email to john@smith.com"
+	String() string
+}
+
+// Queue hands out the due jobs
+type Queue interface {
+	// Claim claims up to limit due jobs, which are kept from the other dispatchers for lease
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Job, error)
+}
+
+// Stats counts what a dispatcher did with the jobs it made
+type Stats struct {
+	Delivered int
+	Retried   int
+	Failed    int
+}
+
+// Dispatcher polls a queue for due jobs and hands them to a pool of workers
+type Dispatcher struct {
+	Queue Queue
+
+	// Workers is how many jobs are made at the same time
+	Workers int
+	// MaxAttempts is how many times a job is tried before it is marked as failed
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, doubled after each of the next ones up
+	// to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// PollInterval is how often the queue is checked for due jobs
+	PollInterval time.Duration
+	// Lease is how long a claimed job is kept from the other dispatchers, in case this one dies
+	// before recording the attempt
+	Lease time.Duration
+
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+
+	// stop carries the context the dispatcher makes the due jobs within
+	stop chan context.Context
+	done chan struct{}
+	// cancel cancels the jobs being made, once Stop runs out of time
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns a dispatcher of queue with the default settings
+func New(queue Queue) *Dispatcher {
+	return &Dispatcher{
+		Queue:        queue,
+		Workers:      4,
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+		InfoLog:      log.New(io.Discard, "", 0),
+		ErrorLog:     log.New(io.Discard, "", 0),
+	}
+}
+
+// Start makes the due jobs in the background until Stop is called
+func (d *Dispatcher) Start() {
+	d.stop = make(chan context.Context, 1)
+	d.done = make(chan struct{})
+
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(context.Background())
+	go d.run(ctx)
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.Dispatch(ctx)
+
+		select {
+		case stopCtx := <-d.stop:
+			// make what is already due, as long as there is time
+			d.Dispatch(stopCtx)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop makes the jobs that are due, until ctx is done, then stops the dispatcher. It returns
+// what happened to the jobs made since Start, and whether it finished in time. When it didn't,
+// the jobs still being made are cancelled, but may not have returned yet. The jobs left are made
+// after the next Start.
+func (d *Dispatcher) Stop(ctx context.Context) (Stats, bool) {
+	defer d.cancel()
+	d.stop <- ctx
+
+	finished := true
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		finished = false
+	}
+
+	return d.Stats(), finished
+}
+
+// Stats returns what happened to the jobs made so far
+func (d *Dispatcher) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Dispatch claims the due jobs, a batch at a time, and makes them until none is left or ctx is
+// done
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := d.Queue.Claim(ctx, d.Workers*10, d.Lease)
+		if err != nil {
+			d.ErrorLog.Println("Claiming jobs:", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		work := make(chan Job)
+		var wg sync.WaitGroup
+		for i := 0; i < d.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range work {
+					d.make(ctx, job)
+				}
+			}()
+		}
+
+		for _, job := range jobs {
+			work <- job
+		}
+		close(work)
+		wg.Wait()
+	}
+}
+
+// make makes a job and records the attempt. A job that fails its last attempt is marked as
+// failed, for an admin to see and make again.
+func (d *Dispatcher) make(ctx context.Context, job Job) {
+	doErr := job.Do(ctx)
+
+	// the attempt is recorded even when the dispatcher is out of time, so it isn't made again
+	recordCtx := context.Background()
+
+	var err error
+	attempt := job.Attempts() + 1
+	switch {
+	case doErr == nil:
+		err = job.Done(recordCtx)
+		d.count(func(s *Stats) { s.Delivered++ })
+	case attempt >= d.MaxAttempts:
+		d.ErrorLog.Printf("Giving up on %s after %d attempts: %v\n", job, attempt, doErr)
+		err = job.Fail(recordCtx, doErr)
+		d.count(func(s *Stats) { s.Failed++ })
+	default:
+		delay := d.Backoff(attempt)
+		d.InfoLog.Printf("Trying %s again in %s: %v\n", job, delay, doErr)
+		err = job.Retry(recordCtx, doErr, time.Now().Add(delay))
+		d.count(func(s *Stats) { s.Retried++ })
+	}
+
+	if err != nil {
+		d.ErrorLog.Printf("Recording the attempt at %s: %v\n", job, err)
+	}
+}
+
+func (d *Dispatcher) count(fn func(s *Stats)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(&d.stats)
+}
+
+// Backoff returns how long to wait after attempt failed: BaseDelay after the first one, twice
+// as long after each of the next ones, and never more than MaxDelay
+func (d *Dispatcher) Backoff(attempt int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempt && delay > 0 && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
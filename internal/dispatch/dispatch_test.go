@@ -0,0 +1,188 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQueue hands out its jobs once each, for as long as they are due
+type testQueue struct {
+	mu   sync.Mutex
+	jobs []*testJob
+}
+
+func (q *testQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []Job
+	for _, x := range q.jobs {
+		if x.status == "pending" && !x.next.After(time.Now()) && len(due) < limit {
+			x.next = time.Now().Add(lease)
+			due = append(due, x)
+		}
+	}
+	return due, nil
+}
+
+// testJob fails until its attempt number succeedsAt
+type testJob struct {
+	succeedsAt int
+
+	attempts int
+	status   string
+	next     time.Time
+}
+
+func (x *testJob) Attempts() int  { return x.attempts }
+func (x *testJob) String() string { return "the test job" }
+
+func (x *testJob) Do(ctx context.Context) error {
+	if x.attempts+1 < x.succeedsAt {
+		return errors.New("not yet")
+	}
+	return nil
+}
+
+func (x *testJob) Done(ctx context.Context) error {
+	x.attempts++
+	x.status = "done"
+	return nil
+}
+
+func (x *testJob) Retry(ctx context.Context, err error, next time.Time) error {
+	x.attempts++
+	x.next = next
+	return nil
+}
+
+func (x *testJob) Fail(ctx context.Context, err error) error {
+	x.attempts++
+	x.status = "failed"
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	q := &testQueue{jobs: []*testJob{
+		{succeedsAt: 1, status: "pending"},
+		{succeedsAt: 2, status: "pending"},
+		{succeedsAt: 10, status: "pending"},
+	}}
+
+	d := New(q)
+	d.MaxAttempts = 3
+	// without a delay, the failed jobs are made again in the same dispatch
+	d.BaseDelay = 0
+
+	d.Dispatch(context.Background())
+
+	if stats := d.Stats(); stats != (Stats{Delivered: 2, Retried: 3, Failed: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	for i, e := range []struct {
+		attempts int
+		status   string
+	}{{1, "done"}, {2, "done"}, {3, "failed"}} {
+		if x := q.jobs[i]; x.attempts != e.attempts || x.status != e.status {
+			t.Errorf("job %d: expected %s after %d attempts, got %s after %d", i, e.status, e.attempts, x.status, x.attempts)
+		}
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	q := &testQueue{jobs: []*testJob{{succeedsAt: 1, status: "pending"}, {succeedsAt: 1, status: "pending"}}}
+
+	d := New(q)
+	d.PollInterval = time.Hour
+
+	d.Start()
+	stats, finished := d.Stop(context.Background())
+
+	if !finished {
+		t.Error("expected the dispatcher to finish")
+	}
+	if stats.Delivered != 2 {
+		t.Errorf("expected the 2 due jobs to be made before stopping, got %+v", stats)
+	}
+}
+
+// slowJob is made until its context is done
+type slowJob struct {
+	testJob
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (x *slowJob) Do(ctx context.Context) error {
+	close(x.started)
+	<-ctx.Done()
+	close(x.cancelled)
+	return ctx.Err()
+}
+
+type slowQueue struct {
+	job     *slowJob
+	claimed bool
+}
+
+func (q *slowQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]Job, error) {
+	if q.claimed {
+		return nil, nil
+	}
+	q.claimed = true
+	return []Job{q.job}, nil
+}
+
+func TestStopDeadline(t *testing.T) {
+	x := &slowJob{started: make(chan struct{}), cancelled: make(chan struct{})}
+
+	d := New(&slowQueue{job: x})
+	d.PollInterval = time.Hour
+
+	d.Start()
+	<-x.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, finished := d.Stop(ctx)
+
+	if finished {
+		t.Error("expected the dispatcher not to finish with a job still being made")
+	}
+
+	select {
+	case <-x.cancelled:
+	case <-time.After(time.Second):
+		t.Error("expected the job being made to be cancelled by the deadline of Stop")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(nil)
+
+	var tests = []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := d.Backoff(e.attempt); got != e.expected {
+			t.Errorf("Backoff(%d): expected %s, got %s", e.attempt, e.expected, got)
+		}
+	}
+
+	d.BaseDelay = 0
+	if got := d.Backoff(100); got != 0 {
+		t.Errorf("expected no backoff without a base delay, got %s", got)
+	}
+}
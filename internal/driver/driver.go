@@ -11,6 +11,8 @@ import (
 
 type DB struct {
 	SQL *sql.DB
+	// Driver is "postgres" or "sqlite"
+	Driver string
 }
 
 var dbConn = &DB{}
@@ -29,6 +31,7 @@ func ConnectSQL(dsn string) (*DB, error) {
 	d.SetConnMaxLifetime(maxDbLifetime)
 
 	dbConn.SQL = d
+	dbConn.Driver = "postgres"
 
 	err = testDB(d)
 	if err != nil {
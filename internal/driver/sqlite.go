@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema has the steps that bring a SQLite database up to date, numbered from 0001. The
+// first creates the tables of an empty database and seeds them; each of the others follows a
+// Postgres migration.
+//
+//go:embed sqlite/*.sql
+var sqliteSchema embed.FS
+
+// ConnectSQLite opens the SQLite database in file, creating it and its schema if needed.
+// Transactions take the write lock as they begin, so bookings of a room are made one at a
+// time, as Postgres does with row locks.
+func ConnectSQLite(file string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate", file)
+
+	d, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	d.SetMaxOpenConns(maxOpenDbConn)
+	d.SetMaxIdleConns(maxIdleDbConn)
+	d.SetConnMaxLifetime(maxDbLifetime)
+
+	err = testDB(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bootstrapSQLite(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{SQL: d, Driver: "sqlite"}, nil
+}
+
+// bootstrapSQLite runs the schema steps the database hasn't had yet. The user_version pragma
+// holds the number of the last one.
+func bootstrapSQLite(d *sql.DB) error {
+	var version int
+	err := d.QueryRow("pragma user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	steps, err := fs.Glob(sqliteSchema, "sqlite/*.sql")
+	if err != nil {
+		return err
+	}
+
+	// the files sort by their number
+	for i := version; i < len(steps); i++ {
+		err = runSQLiteStep(d, steps[i], i+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runSQLiteStep(d *sql.DB, file string, version int) error {
+	statements, err := sqliteSchema.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(string(statements))
+	if err != nil {
+		return fmt.Errorf("running %s: %w", file, err)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("pragma user_version = %d", version))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
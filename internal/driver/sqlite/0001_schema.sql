@@ -0,0 +1,189 @@
+-- The schema of an empty SQLite database, with the same tables as the Postgres migrations and
+-- the same seed data. Dates are stored as yyyy-mm-dd text, so that they compare as dates.
+
+create table users (
+    id integer primary key,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    password varchar(60) not null,
+    access_level integer not null default 1,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index users_email_idx on users (email);
+
+create table rooms (
+    id integer primary key,
+    room_name varchar(255) not null default '',
+    slug varchar(255) not null default '',
+    image varchar(255) not null default '',
+    max_occupancy integer not null default 2,
+    description text not null default '',
+    bed_types varchar(255) not null default '',
+    base_rate integer default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index rooms_slug_idx on rooms (slug);
+
+create table restrictions (
+    id integer primary key,
+    restriction_name varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table reservations (
+    id integer primary key,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    phone varchar(255) not null default '',
+    start_date date not null,
+    end_date date not null,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    processed integer default 0,
+    total_price integer default 0,
+    cancelled integer default 0,
+    confirmation_code varchar(255) not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index reservations_email_idx on reservations (email);
+create index reservations_last_name_idx on reservations (last_name);
+create unique index reservations_confirmation_code_idx on reservations (confirmation_code);
+
+create table reservation_rooms (
+    id integer primary key,
+    reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    total_price integer not null default 0,
+    price_breakdown text,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index reservation_rooms_reservation_id_idx on reservation_rooms (reservation_id);
+create index reservation_rooms_room_id_idx on reservation_rooms (room_id);
+
+create table room_restrictions (
+    id integer primary key,
+    start_date date not null,
+    end_date date not null,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    restriction_id integer not null references restrictions (id) on delete cascade on update cascade,
+    reservation_id integer references reservations (id) on delete cascade on update cascade,
+    expires_at timestamp,
+    reason varchar(255) not null default '',
+    category varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index room_restrictions_start_date_end_date_idx on room_restrictions (start_date, end_date);
+create index room_restrictions_room_id_idx on room_restrictions (room_id);
+create index room_restrictions_reservation_id_idx on room_restrictions (reservation_id);
+create index room_restrictions_expires_at_idx on room_restrictions (expires_at);
+
+-- stands in for the room_restrictions_no_overlap exclusion constraint of Postgres
+create trigger room_restrictions_no_overlap_insert before insert on room_restrictions
+when exists (select 1 from room_restrictions
+    where room_id = new.room_id and new.start_date < end_date and new.end_date > start_date)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+create trigger room_restrictions_no_overlap_update before update of start_date, end_date, room_id on room_restrictions
+when exists (select 1 from room_restrictions
+    where id <> new.id and room_id = new.room_id and new.start_date < end_date and new.end_date > start_date)
+begin
+    select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+create table amenities (
+    id integer primary key,
+    amenity_name varchar(255) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table room_amenities (
+    id integer primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    amenity_id integer not null references amenities (id) on delete cascade on update cascade,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index room_amenities_room_id_amenity_id_idx on room_amenities (room_id, amenity_id);
+create index room_amenities_amenity_id_idx on room_amenities (amenity_id);
+
+create table seasonal_rates (
+    id integer primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    season_name varchar(255) not null default '',
+    start_date date not null,
+    end_date date not null,
+    nightly_rate integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index seasonal_rates_room_id_start_date_end_date_idx on seasonal_rates (room_id, start_date, end_date);
+
+create table rate_modifiers (
+    id integer primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    weekday integer not null,
+    percent integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index rate_modifiers_room_id_weekday_idx on rate_modifiers (room_id, weekday);
+
+create table stay_rules (
+    id integer primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    start_date date not null,
+    end_date date not null,
+    min_nights integer not null default 0,
+    max_nights integer not null default 0,
+    closed_to_arrival boolean not null default false,
+    closed_to_departure boolean not null default false,
+    arrival_weekdays integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index stay_rules_room_id_start_date_end_date_idx on stay_rules (room_id, start_date, end_date);
+
+insert into restrictions (id, restriction_name, created_at, updated_at) values
+    (1, 'Reservation', '2021-06-15 00:00:00', '2021-06-15 00:00:00'),
+    (2, 'Owner Block', '2021-06-16 00:00:00', '2021-06-16 00:00:00'),
+    (3, 'Hold', '2026-10-18 00:00:00', '2026-10-18 00:00:00');
+
+insert into rooms (id, room_name, slug, image, max_occupancy, bed_types, description, base_rate, created_at, updated_at) values
+    (1, 'Jonins Quarters', 'jonins-quarters', '/static/images/jonins-quarters.png', 2, '1 double bed',
+        'A quiet room for a jonin back from a long mission. Rest up before the next chase after the Akatsuki.',
+        12000, '2021-06-15 00:00:00', '2021-06-15 00:00:00'),
+    (2, 'Hokages Suite', 'hokages-suite', '/static/images/hokages-suite.png', 4, '1 king bed, 1 sofa bed',
+        'The finest suite in the village, with a view over the Hokage Rock.',
+        25000, '2021-06-15 00:00:00', '2021-06-15 00:00:00');
+
+insert into amenities (id, amenity_name, created_at, updated_at) values
+    (1, 'Wi-Fi', '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+    (2, 'Air Conditioning', '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+    (3, 'Private Bathroom', '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+    (4, 'Balcony', '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+    (5, 'Kitchenette', '2026-10-18 00:00:00', '2026-10-18 00:00:00'),
+    (6, 'Hot Spring Bath', '2026-10-18 00:00:00', '2026-10-18 00:00:00');
+
+insert into room_amenities (room_id, amenity_id, created_at, updated_at)
+    select r.id, a.id, '2026-10-18 00:00:00', '2026-10-18 00:00:00'
+    from rooms r, amenities a
+    where (r.slug = 'jonins-quarters' and a.amenity_name in ('Wi-Fi', 'Private Bathroom'))
+    or (r.slug = 'hokages-suite' and a.amenity_name in ('Wi-Fi', 'Air Conditioning', 'Private Bathroom', 'Balcony', 'Hot Spring Bath'));
+
+insert into rate_modifiers (room_id, weekday, percent, created_at, updated_at)
+    select r.id, w.weekday, 20, '2026-10-18 00:00:00', '2026-10-18 00:00:00'
+    from rooms r, (select 5 as weekday union select 6) w;
+
+insert into users (first_name, last_name, email, password, access_level, created_at, updated_at) values
+    ('Tal', 'Drori', 'admin@admin.com', '$2a$12$7ebj55IjaLX.TvRyAnWL6.w.HiZhi58Vwu4OuwOoYJuKoECnG8sQC', 3,
+        '2021-06-22 00:00:00', '2021-06-22 00:00:00');
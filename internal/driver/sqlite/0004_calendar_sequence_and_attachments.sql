@@ -0,0 +1,2 @@
+alter table reservations add column calendar_sequence integer not null default 0;
+alter table outbox add column attachments text not null default '';
@@ -0,0 +1,24 @@
+alter table rooms add column calendar_token varchar(255) not null default '';
+update rooms set calendar_token = lower(hex(randomblob(16)));
+create unique index rooms_calendar_token_idx on rooms (calendar_token);
+
+create table room_calendar_feeds (
+    id integer primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    name varchar(255) not null default '',
+    url text not null,
+    last_synced_at timestamp,
+    last_error text not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create index room_calendar_feeds_room_id_idx on room_calendar_feeds (room_id);
+
+alter table room_restrictions add column feed_id integer references room_calendar_feeds (id) on delete cascade on update cascade;
+alter table room_restrictions add column external_uid varchar(255) not null default '';
+
+create index room_restrictions_feed_id_idx on room_restrictions (feed_id);
+
+insert into restrictions (id, restriction_name, created_at, updated_at) values
+    (4, 'External Block', '2026-10-18 00:00:00', '2026-10-18 00:00:00');
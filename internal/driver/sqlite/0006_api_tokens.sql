@@ -0,0 +1,11 @@
+create table api_tokens (
+    id integer primary key,
+    user_id integer not null references users (id) on delete cascade on update cascade,
+    name varchar(255) not null default '',
+    token_hash varchar(64) not null,
+    last_used_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create unique index api_tokens_token_hash_idx on api_tokens (token_hash);
@@ -0,0 +1,26 @@
+create table webhooks (
+    id integer primary key,
+    url text not null,
+    secret varchar(255) not null,
+    events text not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table webhook_deliveries (
+    id integer primary key,
+    webhook_id integer not null references webhooks (id) on delete cascade on update cascade,
+    event varchar(255) not null,
+    payload text not null,
+    status varchar(20) not null default 'pending',
+    attempts integer not null default 0,
+    response_status integer not null default 0,
+    next_attempt_at timestamp not null,
+    last_error text not null default '',
+    delivered_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create index webhook_deliveries_status_next_attempt_at_idx on webhook_deliveries (status, next_attempt_at);
+create index webhook_deliveries_created_at_idx on webhook_deliveries (created_at);
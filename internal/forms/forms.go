@@ -3,11 +3,16 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
 
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 type Form struct {
 	url.Values
 	Errors errors
@@ -52,3 +57,37 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+func (f *Form) IsSlug(field string) {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters, numbers and dashes")
+	}
+}
+
+// IsURL checks that a field is the address of a web page, on http or https
+func (f *Form) IsURL(field string) {
+	u, err := url.Parse(f.Get(field))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		f.Errors.Add(field, "Invalid address, it should start with https://")
+	}
+}
+
+// Date returns the date in a field, written in layout, or adds an error to the field
+func (f *Form) Date(field, layout string) time.Time {
+	t, err := time.Parse(layout, f.Get(field))
+	if err != nil {
+		example := time.Date(2050, 1, 31, 0, 0, 0, 0, time.UTC).Format(layout)
+		f.Errors.Add(field, fmt.Sprintf("Invalid date, it should look like %s", example))
+	}
+	return t
+}
+
+// Int returns the whole number in a field, which must be at least min, or adds an error to
+// the field
+func (f *Form) Int(field string, min int) int {
+	n, err := strconv.Atoi(f.Get(field))
+	if err != nil || n < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number of at least %d", min))
+	}
+	return n
+}
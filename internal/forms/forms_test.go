@@ -89,3 +89,96 @@ func TestIsEmail(t *testing.T) {
 		t.Error("Form show not valid when checking an email when is in the right format")
 	}
 }
+
+func TestIsURL(t *testing.T) {
+	var tests = []struct {
+		url   string
+		valid bool
+	}{
+		{"https://other.site/calendar/1.ics?s=abc", true},
+		{"http://localhost:8080/feed.ics", true},
+		{"other.site/calendar/1.ics", false},
+		{"ftp://other.site/1.ics", false},
+		{"https://", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("url", e.url)
+		form := New(postedData)
+		form.IsURL("url")
+		if form.Valid() != e.valid {
+			t.Errorf("Form show valid %v for url %q, expected %v", form.Valid(), e.url, e.valid)
+		}
+	}
+}
+
+func TestIsSlug(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("slug", "Not a Slug")
+	form := New(postedData)
+	form.IsSlug("slug")
+	if form.Valid() {
+		t.Error("Form show valid when checking a slug with spaces and capital letters")
+	}
+
+	postedData = url.Values{}
+	postedData.Add("slug", "jonins-quarters")
+	form = New(postedData)
+	form.IsSlug("slug")
+	if !form.Valid() {
+		t.Error("Form show not valid when checking a slug which is in the right format")
+	}
+}
+
+func TestDate(t *testing.T) {
+	var tests = []struct {
+		date  string
+		valid bool
+	}{
+		{"2050-01-31", true},
+		{"01/31/2050", false},
+		{"2050-02-30", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start_date", e.date)
+		form := New(postedData)
+		d := form.Date("start_date", "2006-01-02")
+		if form.Valid() != e.valid {
+			t.Errorf("Form show valid %v for date %q, expected %v", form.Valid(), e.date, e.valid)
+		}
+		if e.valid && d.Format("2006-01-02") != e.date {
+			t.Errorf("Form returned %s for date %q", d, e.date)
+		}
+	}
+
+	form := New(url.Values{"start": {"31/01/2050"}})
+	form.Date("start", "01/02/2006")
+	if form.Errors.Get("start") != "Invalid date, it should look like 01/31/2050" {
+		t.Errorf("expected the error to show the layout, got %q", form.Errors.Get("start"))
+	}
+}
+
+func TestInt(t *testing.T) {
+	var tests = []struct {
+		value string
+		valid bool
+	}{
+		{"3", true},
+		{"1", true},
+		{"0", false},
+		{"two", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"guests": {e.value}})
+		form.Int("guests", 1)
+		if form.Valid() != e.valid {
+			t.Errorf("Form show valid %v for number %q, expected %v", form.Valid(), e.value, e.valid)
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/taldrori/bookings/internal/forms"
+	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/stayrules"
+)
+
+// The JSON API under /api/v1, for the mobile app and partner sites. Dates are days written
+// yyyy-mm-dd, and prices are in cents. Rooms and availability are public; reservations and
+// restrictions need the bearer token of a staff member.
+
+const apiDateLayout = "2006-01-02"
+
+// maxAPIBody bounds the size of the JSON a client can send
+const maxAPIBody = 1 << 20
+
+type apiRoom struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Slug         string   `json:"slug"`
+	Description  string   `json:"description"`
+	BedTypes     string   `json:"bed_types"`
+	Image        string   `json:"image" doc:"The file name of the picture of the room, under /static/images"`
+	MaxOccupancy int      `json:"max_occupancy"`
+	BaseRate     int      `json:"base_rate" doc:"The price of a night out of season, in cents"`
+	Amenities    []string `json:"amenities"`
+}
+
+type apiRoomAvailability struct {
+	RoomID     int    `json:"room_id"`
+	RoomName   string `json:"room_name"`
+	Available  bool   `json:"available"`
+	Reason     string `json:"reason,omitempty" doc:"Why the room can't be booked, when it is taken or a stay rule forbids the stay"`
+	TotalPrice int    `json:"total_price,omitempty" doc:"The price of the stay in cents, when the room is available"`
+}
+
+type apiAvailability struct {
+	StartDate string                `json:"start_date" format:"date"`
+	EndDate   string                `json:"end_date" format:"date"`
+	Rooms     []apiRoomAvailability `json:"rooms"`
+}
+
+type apiMonthNight struct {
+	Date      string `json:"date" format:"date"`
+	Available bool   `json:"available" doc:"Whether the night is free, though a stay rule may still forbid a stay"`
+}
+
+type apiRoomMonth struct {
+	RoomID   int             `json:"room_id"`
+	RoomName string          `json:"room_name"`
+	Nights   []apiMonthNight `json:"nights"`
+}
+
+type apiMonth struct {
+	Month string         `json:"month" doc:"Like 2050-01"`
+	Rooms []apiRoomMonth `json:"rooms"`
+}
+
+type apiNight struct {
+	Date  string `json:"date" format:"date"`
+	Price int    `json:"price" doc:"In cents"`
+}
+
+type apiReservationRoom struct {
+	RoomID     int        `json:"room_id"`
+	RoomName   string     `json:"room_name"`
+	TotalPrice int        `json:"total_price"`
+	Nights     []apiNight `json:"nights"`
+}
+
+type apiReservation struct {
+	ID               int                  `json:"id"`
+	ConfirmationCode string               `json:"confirmation_code"`
+	FirstName        string               `json:"first_name"`
+	LastName         string               `json:"last_name"`
+	Email            string               `json:"email"`
+	Phone            string               `json:"phone"`
+	StartDate        string               `json:"start_date" format:"date"`
+	EndDate          string               `json:"end_date" format:"date" doc:"The day the guest leaves"`
+	TotalPrice       int                  `json:"total_price" doc:"In cents"`
+	Processed        bool                 `json:"processed" doc:"Whether the staff have dealt with the reservation"`
+	Cancelled        bool                 `json:"cancelled"`
+	Rooms            []apiReservationRoom `json:"rooms"`
+}
+
+// apiNewReservation is the body of a request to make a reservation
+type apiNewReservation struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email" format:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date" format:"date"`
+	EndDate   string `json:"end_date" format:"date" doc:"The day the guest leaves"`
+	RoomIDs   []int  `json:"room_ids"`
+}
+
+type apiRestriction struct {
+	ID            int    `json:"id"`
+	RoomID        int    `json:"room_id"`
+	Type          string `json:"type" enum:"reservation,owner_block,hold,external_block"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	StartDate     string `json:"start_date" format:"date"`
+	EndDate       string `json:"end_date" format:"date" doc:"The first day the room is free again"`
+	Reason        string `json:"reason,omitempty" doc:"Why the owner blocked the room, or the site an external block comes from"`
+	Category      string `json:"category,omitempty" doc:"The category of an owner block"`
+}
+
+// restrictionTypes names the restrictions in the API
+var restrictionTypes = map[int]string{
+	models.RestrictionReservation: "reservation",
+	models.RestrictionOwnerBlock:  "owner_block",
+	models.RestrictionHold:        "hold",
+	models.RestrictionExternal:    "external_block",
+}
+
+// RequireAPIToken lets through the requests made with the bearer token of a staff member
+func (m *Repository) RequireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := helpers.BearerToken(r)
+		if secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bookings"`)
+			helpers.JSONError(w, http.StatusUnauthorized, helpers.CodeUnauthorized, "A bearer token is required")
+			return
+		}
+
+		_, err := m.DB.AuthenticateAPIToken(r.Context(), secret)
+		if err == sql.ErrNoRows {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bookings", error="invalid_token"`)
+			helpers.JSONError(w, http.StatusUnauthorized, helpers.CodeUnauthorized, "The bearer token is not valid")
+			return
+		} else if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIRooms lists the rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	out := make([]apiRoom, 0, len(rooms))
+	for _, x := range rooms {
+		amenities, err := m.DB.GetAmenitiesForRoom(r.Context(), x.ID)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		room := apiRoom{
+			ID:           x.ID,
+			Name:         x.RoomName,
+			Slug:         x.Slug,
+			Description:  x.Description,
+			BedTypes:     x.BedTypes,
+			Image:        x.Image,
+			MaxOccupancy: x.MaxOccupancy,
+			BaseRate:     x.BaseRate,
+			Amenities:    []string{},
+		}
+		for _, a := range amenities {
+			room.Amenities = append(room.Amenities, a.AmenityName)
+		}
+		out = append(out, room)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{"rooms": out})
+}
+
+// APIAvailability tells which rooms are free from start_date to end_date, with the price of
+// the stay, or whether room_id is. A search of all the rooms can ask for room for guests.
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+
+	start, end := apiDates(form, "start_date", "end_date")
+	roomID, guests := 0, 0
+	if form.Has("room_id") {
+		roomID = form.Int("room_id", 1)
+	}
+	if form.Has("guests") {
+		guests = form.Int("guests", 1)
+	}
+
+	if !form.Valid() {
+		helpers.JSONFormError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, form)
+		return
+	}
+
+	out := apiAvailability{
+		StartDate: start.Format(apiDateLayout),
+		EndDate:   end.Format(apiDateLayout),
+		Rooms:     []apiRoomAvailability{},
+	}
+
+	if roomID != 0 {
+		room, err := m.DB.GetRoomByID(r.Context(), roomID)
+		if err == sql.ErrNoRows {
+			helpers.JSONError(w, http.StatusNotFound, helpers.CodeNotFound, "No such room")
+			return
+		} else if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		available, reason, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), start, end, room.ID)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		x := apiRoomAvailability{RoomID: room.ID, RoomName: room.RoomName, Available: available, Reason: reason}
+		if available {
+			quote, err := m.quoteStay(r.Context(), room, start, end)
+			if err != nil {
+				helpers.JSONServerError(w, err)
+				return
+			}
+			x.TotalPrice = quote.Total
+		}
+		out.Rooms = append(out.Rooms, x)
+
+		helpers.WriteJSON(w, http.StatusOK, out)
+		return
+	}
+
+	rooms, exclusions, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), start, end, guests, nil)
+	if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	for _, room := range rooms {
+		quote, err := m.quoteStay(r.Context(), room, start, end)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+		out.Rooms = append(out.Rooms, apiRoomAvailability{RoomID: room.ID, RoomName: room.RoomName, Available: true, TotalPrice: quote.Total})
+	}
+	for _, x := range exclusions {
+		out.Rooms = append(out.Rooms, apiRoomAvailability{RoomID: x.Room.ID, RoomName: x.Room.RoomName, Reason: x.Reason})
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIAvailabilityMonth tells, night by night, whether the rooms, or room_id, are free in month,
+// so that a date picker can grey out the nights that are taken
+func (m *Repository) APIAvailabilityMonth(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+
+	start := form.Date("month", "2006-01")
+	end := start.AddDate(0, 1, 0)
+	roomID := 0
+	if form.Has("room_id") {
+		roomID = form.Int("room_id", 1)
+	}
+
+	if !form.Valid() {
+		helpers.JSONFormError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, form)
+		return
+	}
+
+	var rooms []models.Room
+	if roomID != 0 {
+		room, err := m.DB.GetRoomByID(r.Context(), roomID)
+		if err == sql.ErrNoRows {
+			helpers.JSONError(w, http.StatusNotFound, helpers.CodeNotFound, "No such room")
+			return
+		} else if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+		rooms = append(rooms, room)
+	} else {
+		var err error
+		rooms, err = m.DB.AllRooms(r.Context())
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+	}
+
+	taken, err := m.DB.TakenNights(r.Context(), start, end, roomID)
+	if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	out := apiMonth{
+		Month: start.Format("2006-01"),
+		Rooms: []apiRoomMonth{},
+	}
+	for _, room := range rooms {
+		takenNights := make(map[string]bool)
+		for _, d := range taken[room.ID] {
+			takenNights[d.Format(apiDateLayout)] = true
+		}
+
+		x := apiRoomMonth{RoomID: room.ID, RoomName: room.RoomName, Nights: []apiMonthNight{}}
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			date := d.Format(apiDateLayout)
+			x.Nights = append(x.Nights, apiMonthNight{Date: date, Available: !takenNights[date]})
+		}
+		out.Rooms = append(out.Rooms, x)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIPostReservation books rooms for a guest, who is sent the confirmation email
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var body apiNewReservation
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	err := dec.Decode(&body)
+	if err != nil {
+		helpers.JSONError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, "The body must be a JSON reservation")
+		return
+	}
+
+	form := forms.New(url.Values{
+		"first_name": {body.FirstName},
+		"last_name":  {body.LastName},
+		"email":      {body.Email},
+		"phone":      {body.Phone},
+		"start_date": {body.StartDate},
+		"end_date":   {body.EndDate},
+	})
+	form.Required("first_name", "last_name", "email", "phone")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	start, end := apiDates(form, "start_date", "end_date")
+	if len(body.RoomIDs) == 0 {
+		form.Errors.Add("room_ids", "Choose at least one room")
+	}
+
+	if !form.Valid() {
+		helpers.JSONFormError(w, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, form)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     body.Email,
+		Phone:     body.Phone,
+		StartDate: start,
+		EndDate:   end,
+	}
+	for _, id := range body.RoomIDs {
+		res.Rooms = append(res.Rooms, models.ReservationRoom{RoomID: id})
+	}
+
+	res, err = m.priceReservation(r.Context(), res)
+	if err == sql.ErrNoRows {
+		form.Errors.Add("room_ids", "No such room")
+		helpers.JSONFormError(w, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, form)
+		return
+	} else if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	res.ID, res.ConfirmationCode, err = m.DB.CreateReservation(r.Context(), res, nil, confirmationEmails, createdReservationEvents)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		helpers.JSONError(w, http.StatusConflict, helpers.CodeRoomUnavailable, "A room is already taken for some of the dates")
+		return
+	}
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		helpers.JSONError(w, http.StatusUnprocessableEntity, helpers.CodeStayRuleViolated, violation.Reason)
+		return
+	}
+	if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/reservations/"+res.ConfirmationCode)
+	helpers.WriteJSON(w, http.StatusCreated, apiReservationOf(res))
+}
+
+// APIReservation returns a reservation, found by its confirmation code
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiReservationOf(res))
+}
+
+// APICancelReservation cancels a reservation, found by its confirmation code, and lets the
+// guest and the owner know. Cancelling a cancelled reservation changes nothing.
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if res.Cancelled == 0 {
+		mail, err := cancelledReservationEmails(res)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		res.Cancelled = 1
+		events, err := reservationEvents(models.EventReservationCancelled, res)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		// a reservation cancelled meanwhile, by another request, is left as it is
+		err = m.DB.CancelReservation(r.Context(), res.ID, mail, events)
+		if err != nil && !errors.Is(err, repository.ErrAlreadyCancelled) {
+			helpers.JSONServerError(w, err)
+			return
+		}
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiReservationOf(res))
+}
+
+// apiReservation returns the reservation of the code in the URL. When there is none it answers
+// with a 404 and returns false.
+func (m *Repository) apiReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByCode(r.Context(), chi.URLParam(r, "code"))
+	if err == sql.ErrNoRows {
+		helpers.JSONError(w, http.StatusNotFound, helpers.CodeNotFound, "No reservation has this confirmation code")
+		return res, false
+	} else if err != nil {
+		helpers.JSONServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// APIRestrictions lists what keeps the rooms, or room_id, from being booked from start_date
+// to end_date: reservations, blocks and holds
+func (m *Repository) APIRestrictions(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+
+	start, end := apiDates(form, "start_date", "end_date")
+	roomID := 0
+	if form.Has("room_id") {
+		roomID = form.Int("room_id", 1)
+	}
+
+	if !form.Valid() {
+		helpers.JSONFormError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, form)
+		return
+	}
+
+	var roomIDs []int
+	if roomID != 0 {
+		roomIDs = append(roomIDs, roomID)
+	} else {
+		rooms, err := m.DB.AllRooms(r.Context())
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+		for _, x := range rooms {
+			roomIDs = append(roomIDs, x.ID)
+		}
+	}
+
+	out := []apiRestriction{}
+	for _, roomID := range roomIDs {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), roomID, start, end)
+		if err != nil {
+			helpers.JSONServerError(w, err)
+			return
+		}
+
+		for _, x := range restrictions {
+			// the query takes the end day too
+			if !x.StartDate.Before(end) {
+				continue
+			}
+			out = append(out, apiRestrictionOf(x))
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartDate < out[j].StartDate })
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]interface{}{"restrictions": out})
+}
+
+// apiDates reads the days of a stay from the fields startField and endField of form, adding
+// an error to them when they are not valid
+func apiDates(form *forms.Form, startField, endField string) (time.Time, time.Time) {
+	start := form.Date(startField, apiDateLayout)
+	end := form.Date(endField, apiDateLayout)
+	if form.Errors.Get(startField) == "" && form.Errors.Get(endField) == "" && !end.After(start) {
+		form.Errors.Add(endField, fmt.Sprintf("This day must be after %s", startField))
+	}
+	return start, end
+}
+
+// apiRestrictionOf is how the API shows x
+func apiRestrictionOf(x models.RoomRestriction) apiRestriction {
+	return apiRestriction{
+		ID:            x.ID,
+		RoomID:        x.RoomID,
+		Type:          restrictionTypes[x.RestrictionID],
+		ReservationID: x.ReservationID,
+		StartDate:     x.StartDate.Format(apiDateLayout),
+		EndDate:       x.EndDate.Format(apiDateLayout),
+		Reason:        x.Reason,
+		Category:      x.Category,
+	}
+}
+
+// apiReservationOf is how the API shows res
+func apiReservationOf(res models.Reservation) apiReservation {
+	out := apiReservation{
+		ID:               res.ID,
+		ConfirmationCode: res.ConfirmationCode,
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		StartDate:        res.StartDate.Format(apiDateLayout),
+		EndDate:          res.EndDate.Format(apiDateLayout),
+		TotalPrice:       res.TotalPrice,
+		Processed:        res.Processed == 1,
+		Cancelled:        res.Cancelled == 1,
+		Rooms:            []apiReservationRoom{},
+	}
+
+	for _, x := range res.Rooms {
+		room := apiReservationRoom{
+			RoomID:     x.RoomID,
+			RoomName:   x.Room.RoomName,
+			TotalPrice: x.TotalPrice,
+			Nights:     []apiNight{},
+		}
+		for _, n := range x.PriceBreakdown {
+			room.Nights = append(room.Nights, apiNight{Date: n.Date.Format(apiDateLayout), Price: n.Price})
+		}
+		out.Rooms = append(out.Rooms, room)
+	}
+
+	return out
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/taldrori/bookings/internal/helpers"
+)
+
+// apiRequest serves a request to the API with handler, behind the bearer token check when
+// staff is true
+func apiRequest(handler http.HandlerFunc, staff bool, method, url, token, body string, params map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	ctx := getCTX(req)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	var h http.Handler = handler
+	if staff {
+		h = Repo.RequireAPIToken(handler)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// checkAPISpec fails the test when the response isn't one the OpenAPI document describes for
+// method on the path template path
+func checkAPISpec(t *testing.T, method, path string, rr *httptest.ResponseRecorder) {
+	t.Helper()
+
+	err := apiSpec.ValidateResponse(method, path, rr.Code, rr.Header(), rr.Body.Bytes())
+	if err != nil {
+		t.Errorf("the response doesn't match the OpenAPI document: %v", err)
+	}
+}
+
+// checkAPIError fails the test when the response isn't a JSON error of code with errors for
+// fields, a comma separated list of the fields, in order
+func checkAPIError(t *testing.T, rr *httptest.ResponseRecorder, code, fields string) {
+	t.Helper()
+
+	var body helpers.ErrorResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil || body.Error.Code != code || body.Error.Message == "" {
+		t.Errorf("expected a JSON error of code %s, got %s", code, rr.Body.String())
+		return
+	}
+
+	var names []string
+	for name := range body.Error.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != fields {
+		t.Errorf("expected errors for the fields %q, got %v", fields, body.Error.Fields)
+	}
+}
+
+func TestRepository_APIRooms(t *testing.T) {
+	rr := apiRequest(Repo.APIRooms, false, "GET", "/api/v1/rooms", "", "", nil)
+	checkAPISpec(t, "GET", "/api/v1/rooms", rr)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("APIRooms handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Rooms []apiRoom `json:"rooms"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Rooms) != 2 || body.Rooms[1].Slug != "jonins-quarters" || body.Rooms[1].BaseRate != 10000 {
+		t.Errorf("expected the two rooms, got %+v", body.Rooms)
+	}
+}
+
+func TestRepository_APIAvailability(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedRooms      int
+		expectedFields     string
+	}{
+		{"all rooms", "start_date=2049-06-01&end_date=2049-06-03", http.StatusOK, 1, ""},
+		{"one room", "start_date=2049-06-01&end_date=2049-06-03&room_id=1", http.StatusOK, 1, ""},
+		{"excluded by a stay rule", "start_date=2045-01-01&end_date=2045-01-02", http.StatusOK, 2, ""},
+		{"no rooms", "start_date=2050-06-01&end_date=2050-06-03", http.StatusOK, 0, ""},
+		{"too many guests", "start_date=2049-06-01&end_date=2049-06-03&guests=9", http.StatusOK, 0, ""},
+		{"invalid guests", "start_date=2049-06-01&end_date=2049-06-03&guests=none", http.StatusBadRequest, 0, "guests"},
+		{"invalid room", "start_date=2049-06-01&end_date=2049-06-03&room_id=one", http.StatusBadRequest, 0, "room_id"},
+		{"invalid date", "start_date=06/01/2049&end_date=2049-06-03", http.StatusBadRequest, 0, "start_date"},
+		{"ends before it starts", "start_date=2049-06-03&end_date=2049-06-01", http.StatusBadRequest, 0, "end_date"},
+		{"no dates", "", http.StatusBadRequest, 0, "end_date,start_date"},
+		{"database error", "start_date=2060-01-01&end_date=2060-01-03", http.StatusInternalServerError, 0, ""},
+	}
+
+	for _, e := range tests {
+		rr := apiRequest(Repo.APIAvailability, false, "GET", "/api/v1/availability?"+e.query, "", "", nil)
+		checkAPISpec(t, "GET", "/api/v1/availability", rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("APIAvailability handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+		if rr.Code == http.StatusBadRequest {
+			checkAPIError(t, rr, helpers.CodeInvalidRequest, e.expectedFields)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var body apiAvailability
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if len(body.Rooms) != e.expectedRooms {
+			t.Errorf("APIAvailability handler returned %d rooms for %s, wanted %d", len(body.Rooms), e.name, e.expectedRooms)
+		}
+	}
+}
+
+func TestRepository_APIAvailabilityMonth(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedRooms      int
+	}{
+		{"all rooms", "month=2050-02", http.StatusOK, 2},
+		{"one room", "month=2050-02&room_id=1", http.StatusOK, 1},
+		{"no month", "", http.StatusBadRequest, 0},
+		{"invalid month", "month=02/2050", http.StatusBadRequest, 0},
+		{"invalid room", "month=2050-02&room_id=0", http.StatusBadRequest, 0},
+		{"database error", "month=2060-01", http.StatusInternalServerError, 0},
+	}
+
+	for _, e := range tests {
+		rr := apiRequest(Repo.APIAvailabilityMonth, false, "GET", "/api/v1/availability/month?"+e.query, "", "", nil)
+		checkAPISpec(t, "GET", "/api/v1/availability/month", rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("APIAvailabilityMonth handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+		if rr.Code == http.StatusBadRequest {
+			var body helpers.ErrorResponse
+			if json.Unmarshal(rr.Body.Bytes(), &body) != nil || body.Error.Code != helpers.CodeInvalidRequest || len(body.Error.Fields) != 1 {
+				t.Errorf("APIAvailabilityMonth handler returned %s for %s", rr.Body.String(), e.name)
+			}
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var body apiMonth
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if len(body.Rooms) != e.expectedRooms {
+			t.Errorf("APIAvailabilityMonth handler returned %d rooms for %s, wanted %d", len(body.Rooms), e.name, e.expectedRooms)
+			continue
+		}
+
+		// room 1 is taken the 1st, 2nd, 4th and from the 6th to the 8th
+		for _, x := range body.Rooms {
+			var taken []string
+			for _, n := range x.Nights {
+				if !n.Available {
+					taken = append(taken, n.Date[8:])
+				}
+			}
+
+			expected := ""
+			if x.RoomID == 1 {
+				expected = "01,02,04,06,07,08"
+			}
+			if len(x.Nights) != 28 || strings.Join(taken, ",") != expected {
+				t.Errorf("expected room %d to be taken %q of the 28 nights for %s, got %q of %d", x.RoomID, expected, e.name, strings.Join(taken, ","), len(x.Nights))
+			}
+		}
+	}
+}
+
+func TestRepository_APIPostReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		token              string
+		body               string
+		expectedStatusCode int
+		expectedCode       string
+		expectedFields     string
+	}{
+		{"valid", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03","room_ids":[1]}`, http.StatusCreated, "", ""},
+		{"no token", "", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03","room_ids":[1]}`, http.StatusUnauthorized, helpers.CodeUnauthorized, ""},
+		{"wrong token", "guess", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03","room_ids":[1]}`, http.StatusUnauthorized, helpers.CodeUnauthorized, ""},
+		{"not json", "staff-token", `first_name=Tal`, http.StatusBadRequest, helpers.CodeInvalidRequest, ""},
+		{"invalid email", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03","room_ids":[1]}`, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, "email"},
+		{"no rooms", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03"}`, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, "room_ids"},
+		{"invalid dates", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-03","end_date":"2050-01-01","room_ids":[1]}`, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, "end_date"},
+		{"room taken", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2070-01-01","end_date":"2070-01-03","room_ids":[1]}`, http.StatusConflict, helpers.CodeRoomUnavailable, ""},
+		{"stay rule", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2045-01-01","end_date":"2045-01-02","room_ids":[1]}`, http.StatusUnprocessableEntity, helpers.CodeStayRuleViolated, ""},
+		{"database error", "staff-token", `{"first_name":"Tal","last_name":"Drori","email":"tal@drori.com","phone":"123456789","start_date":"2050-01-01","end_date":"2050-01-03","room_ids":[2]}`, http.StatusInternalServerError, helpers.CodeInternal, ""},
+		{"empty", "staff-token", `{}`, http.StatusUnprocessableEntity, helpers.CodeValidationFailed, "email,end_date,first_name,last_name,phone,room_ids,start_date"},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		rr := apiRequest(Repo.APIPostReservation, true, "POST", "/api/v1/reservations", e.token, e.body, nil)
+		checkAPISpec(t, "POST", "/api/v1/reservations", rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("APIPostReservation handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+		if e.expectedCode != "" {
+			checkAPIError(t, rr, e.expectedCode, e.expectedFields)
+			continue
+		}
+
+		var res apiReservation
+		err := json.Unmarshal(rr.Body.Bytes(), &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ConfirmationCode != "LV-TEST-01" || rr.Header().Get("Location") != "/api/v1/reservations/LV-TEST-01" {
+			t.Errorf("expected the new reservation, got %+v at %s", res, rr.Header().Get("Location"))
+		}
+		if len(res.Rooms) != 1 || len(res.Rooms[0].Nights) != 2 {
+			t.Errorf("expected the reservation to be priced night by night, got %+v", res)
+		}
+		if len(sentMail.Sent()) != 2 {
+			t.Errorf("expected the guest and the owner to be emailed, got %d emails", len(sentMail.Sent()))
+		}
+	}
+}
+
+func TestRepository_APIReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+	}{
+		{"upcoming", "LV-TEST-01", http.StatusOK},
+		{"cancelled", "LV-TEST-02", http.StatusOK},
+		{"unknown", "LV-NONE-00", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := apiRequest(Repo.APIReservation, true, "GET", "/api/v1/reservations/"+e.code, "staff-token", "", map[string]string{"code": e.code})
+		checkAPISpec(t, "GET", "/api/v1/reservations/{code}", rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("APIReservation handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+		if rr.Code == http.StatusOK {
+			var res apiReservation
+			if json.Unmarshal(rr.Body.Bytes(), &res) != nil || res.ConfirmationCode != e.code || res.StartDate != "2050-01-01" {
+				t.Errorf("APIReservation handler returned %s for %s", rr.Body.String(), e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_APICancelReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+		expectedMail       int
+	}{
+		{"upcoming", "LV-TEST-01", http.StatusOK, 2},
+		{"already cancelled", "LV-TEST-02", http.StatusOK, 0},
+		{"unknown", "LV-NONE-00", http.StatusNotFound, 0},
+	}
+
+	for _, e := range tests {
+		sentMail.Reset()
+
+		rr := apiRequest(Repo.APICancelReservation, true, "POST", "/api/v1/reservations/"+e.code+"/cancel", "staff-token", "", map[string]string{"code": e.code})
+		checkAPISpec(t, "POST", "/api/v1/reservations/{code}/cancel", rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("APICancelReservation handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+		if len(sentMail.Sent()) != e.expectedMail {
+			t.Errorf("APICancelReservation handler sent %d emails for %s, wanted %d", len(sentMail.Sent()), e.name, e.expectedMail)
+		}
+		if rr.Code == http.StatusOK {
+			var res apiReservation
+			if json.Unmarshal(rr.Body.Bytes(), &res) != nil || !res.Cancelled {
+				t.Errorf("APICancelReservation handler returned %s for %s", rr.Body.String(), e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_APIRestrictions(t *testing.T) {
+	rr := apiRequest(Repo.APIRestrictions, true, "GET", "/api/v1/restrictions?start_date=2050-01-01&end_date=2050-02-01", "staff-token", "", nil)
+	checkAPISpec(t, "GET", "/api/v1/restrictions", rr)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("APIRestrictions handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Restrictions []apiRestriction `json:"restrictions"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for _, x := range body.Restrictions {
+		types = append(types, x.Type)
+	}
+	if strings.Join(types, ",") != "reservation,hold,external_block" {
+		t.Errorf("expected the restrictions of room 1 in order, got %v", types)
+	}
+
+	rr = apiRequest(Repo.APIRestrictions, true, "GET", "/api/v1/restrictions", "staff-token", "", nil)
+	checkAPISpec(t, "GET", "/api/v1/restrictions", rr)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("APIRestrictions handler returned wrong response code without dates: got %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+	checkAPIError(t, rr, helpers.CodeInvalidRequest, "end_date,start_date")
+}
+
+func TestRepository_OpenAPI(t *testing.T) {
+	rr := apiRequest(Repo.OpenAPI, false, "GET", "/api/openapi.json", "", "", nil)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("OpenAPI handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || len(doc.Paths) != 8 {
+		t.Errorf("expected an OpenAPI 3 document of 8 paths, got %s with %d", doc.OpenAPI, len(doc.Paths))
+	}
+
+	rr = apiRequest(Repo.APIDocs, false, "GET", "/api/docs", "", "", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/api/openapi.json") {
+		t.Errorf("APIDocs handler returned %d without the document", rr.Code)
+	}
+}
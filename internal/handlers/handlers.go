@@ -1,23 +1,32 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/taldrori/bookings/internal/calsync"
 	"github.com/taldrori/bookings/internal/config"
 	"github.com/taldrori/bookings/internal/driver"
 	"github.com/taldrori/bookings/internal/forms"
 	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/ical"
+	"github.com/taldrori/bookings/internal/mailer"
 	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/pricing"
 	"github.com/taldrori/bookings/internal/render"
 	"github.com/taldrori/bookings/internal/repository"
 	"github.com/taldrori/bookings/internal/repository/dbrepo"
+	"github.com/taldrori/bookings/internal/stayrules"
 )
 
 var Repo *Repository
@@ -28,16 +37,23 @@ type Repository struct {
 }
 
 func NewRepo(a *config.Appconfig, db *driver.DB) *Repository {
+	if db.Driver == "sqlite" {
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewSQLiteRepo(db.SQL, a),
+		}
+	}
+
 	return &Repository{
 		App: a,
 		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
 	}
 }
 
-func NewTestRepo(a *config.Appconfig) *Repository {
+func NewTestRepo(a *config.Appconfig, m mailer.Mailer) *Repository {
 	return &Repository{
 		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		DB:  dbrepo.NewTestingRepo(a, m),
 	}
 }
 
@@ -53,12 +69,93 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "about.page.tmpl", &models.TemplateData{})
 }
 
-func (m *Repository) Jonins(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "jonins.page.tmpl", &models.TemplateData{})
+// Rooms lists all the rooms
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the public page of a single room, found by its slug
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Hokages(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "hokages.page.tmpl", &models.TemplateData{})
+// RoomCalendarFeed serves the iCalendar feed of the dates a room is taken, for the booking
+// sites the room is also listed on. The feed is found by its secret token, and a wrong token
+// is answered as if the room didn't exist.
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if room.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.CalendarToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, today.AddDate(0, -1, 0), today.AddDate(2, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	c := ical.Calendar{Method: ical.MethodPublish, Name: fmt.Sprintf("%s, Leaf Village", room.RoomName)}
+	for _, x := range restrictions {
+		// holds expire unless the guest completes the reservation, and the blocks imported from
+		// the other sites would echo back to them, staying after they are cancelled there until
+		// both sides sync again
+		if x.Hold() || x.Imported() {
+			continue
+		}
+		c.Events = append(c.Events, ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@LeafVillage.com", x.ID),
+			Stamp:   x.UpdatedAt,
+			Start:   x.StartDate,
+			End:     x.EndDate,
+			Summary: "Not available",
+		})
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(c.Bytes())
 }
 
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
@@ -69,16 +166,17 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	if len(res.Rooms) == 0 {
+		res.Rooms = []models.ReservationRoom{{RoomID: res.RoomID}}
+	}
+
+	res, err := m.priceReservation(r.Context(), res)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't find room")
+		m.App.Session.Put(r.Context(), "error", "can't get a price for your stay")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res.Room.RoomName = room.RoomName
-	res.RoomID = room.ID
-
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("01/02/2006")
@@ -87,6 +185,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	stringMap["hold_expires"] = m.App.Session.GetString(r.Context(), "hold_expires")
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
@@ -147,6 +246,9 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.StartDate = startDate
 	reservation.EndDate = endDate
 	reservation.RoomID = roomID
+	if len(reservation.Rooms) <= 1 {
+		reservation.Rooms = []models.ReservationRoom{{RoomID: roomID}}
+	}
 
 	form := forms.New(r.PostForm)
 
@@ -169,94 +271,117 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// price the stay again, rates may have changed since the form was shown
+	reservation, err = m.priceReservation(r.Context(), reservation)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "reservation didn't go in db")
+		m.App.Session.Put(r.Context(), "error", "can't get a price for your stay")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
+	holdIDs, _ := m.App.Session.Get(r.Context(), "hold_ids").([]int)
 
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cant insert romm restrictions")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	newReservationID, code, err := m.DB.CreateReservation(r.Context(), reservation, holdIDs, confirmationEmails, createdReservationEvents)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, a room just got booked for some of your dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
-
-	// send notification to customer
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s, <br>
-		This is confirm your reservation from %s to %s at %s room.`,
-		reservation.FirstName,
-		reservation.StartDate.Format("01/02/2006"), reservation.EndDate.Format("01/02/2006"),
-		reservation.Room.RoomName)
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "info@LeafVillage.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		m.App.Session.Put(r.Context(), "error", violation.Reason)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
 	}
-
-	m.App.MailChan <- msg
-	// send notification to Owner
-	htmlMessage = fmt.Sprintf(`
-	<strong>Reservation Notification</strong><br>
-	A reservation has been made. From %s to %s at %s room.`,
-		reservation.StartDate.Format("01/02/2006"), reservation.EndDate.Format("01/02/2006"),
-		reservation.Room.RoomName)
-
-	msg = models.MailData{
-		To:      "Naruto@LeafVillage.com",
-		From:    "info@LeafVillage.com",
-		Subject: "Reservation Notification",
-		Content: htmlMessage,
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "reservation didn't go in db")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
+	reservation.ID = newReservationID
+	reservation.ConfirmationCode = code
 
-	m.App.MailChan <- msg
+	m.App.Session.Remove(r.Context(), "hold_ids")
+	m.App.Session.Remove(r.Context(), "hold_expires")
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 
 }
 
+// confirmationEmails are the emails that go out with a new reservation: its confirmation to the
+// guest and a notification to the owner. They are queued in the transaction that makes it.
+func confirmationEmails(res models.Reservation) ([]models.MailData, error) {
+	return guestAndOwnerEmails(res,
+		models.ConfirmationEmail{Reservation: res},
+		models.NewReservationEmail{Reservation: res},
+		ical.MethodRequest)
+}
+
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["amenities"] = amenities
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
 	layout := "01/02/2006"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "Invalid arrival date")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 	endDate, err := time.Parse(layout, end)
-	if err != nil {
-		helpers.ServerError(w, err)
+	if err != nil || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Invalid departure date")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	guests := 0
+	if r.Form.Get("guests") != "" {
+		guests, err = strconv.Atoi(r.Form.Get("guests"))
+		if err != nil || guests < 1 {
+			m.App.Session.Put(r.Context(), "error", "Invalid number of guests")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
+	var amenityIDs []int
+	for _, x := range r.Form["amenities"] {
+		id, err := strconv.Atoi(x)
+		if err == nil {
+			amenityIDs = append(amenityIDs, id)
+		}
+	}
+
+	rooms, exclusions, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate, guests, amenityIDs)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	if len(rooms) == 0 {
+	if len(rooms) == 0 && len(exclusions) == 0 {
 		m.App.Session.Put(r.Context(), "error", "No availability")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
@@ -264,6 +389,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["exclusions"] = exclusions
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -277,63 +403,53 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// jsonResponse is what AvailabilityJson answers when it could check the room. Errors are
+// answered with a helpers.ErrorResponse.
 type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
+	OK        bool   `json:"ok" doc:"Whether the room is free"`
+	Message   string `json:"message" doc:"Why the room can't be booked, or what went wrong"`
 	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
+	StartDate string `json:"start_date" doc:"As it was sent, like 01/31/2050"`
 	EndDate   string `json:"end_date"`
 }
 
 func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		// can't parse form, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Internal server error",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		helpers.JSONError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, "The request must be a form")
 		return
 	}
 
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
+	form := forms.New(r.Form)
 
 	layout := "01/02/2006"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	startDate := form.Date("start", layout)
+	endDate := form.Date("end", layout)
+	roomID := form.Int("room_id", 1)
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end", "The departure must be after the arrival")
+	}
 
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	if !form.Valid() {
+		helpers.JSONFormError(w, http.StatusBadRequest, helpers.CodeInvalidRequest, form)
+		return
+	}
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, reason, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
-		// got a database error, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error querying database",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		helpers.JSONServerError(w, err)
 		return
 	}
+
 	resp := jsonResponse{
 		OK:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
+		Message:   reason,
+		StartDate: form.Get("start"),
+		EndDate:   form.Get("end"),
 		RoomID:    strconv.Itoa(roomID),
 	}
 
-	out, _ := json.MarshalIndent(resp, "", "    ")
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+	helpers.WriteJSON(w, http.StatusOK, resp)
 }
 
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
@@ -380,6 +496,11 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res.RoomID = roomID
+	res.Rooms = []models.ReservationRoom{{RoomID: roomID}}
+
+	if !m.holdRooms(w, r, res) {
+		return
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -403,7 +524,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -416,12 +537,100 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.EndDate = ed
 
 	res.Room.RoomName = room.RoomName
+	res.Rooms = []models.ReservationRoom{{RoomID: roomID, Room: room}}
+
+	if !m.holdRooms(w, r, res) {
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// PostChooseRooms books several of the available rooms together, for a group
+func (m *Repository) PostChooseRooms(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.Rooms = nil
+	for _, x := range r.Form["room_ids"] {
+		id, err := strconv.Atoi(x)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		res.Rooms = append(res.Rooms, models.ReservationRoom{RoomID: id})
+	}
+
+	if len(res.Rooms) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please choose at least one room")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	res.RoomID = res.Rooms[0].RoomID
+
+	if !m.holdRooms(w, r, res) {
+		return
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
+// holdRooms holds the rooms of res for the session while the guest fills in the reservation
+// form, releasing the session's earlier holds. When a room was taken in the meantime it
+// releases the other rooms, redirects back to the search and returns false.
+func (m *Repository) holdRooms(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	holdIDs, _ := m.App.Session.Get(r.Context(), "hold_ids").([]int)
+	m.App.Session.Remove(r.Context(), "hold_ids")
+
+	for _, id := range holdIDs {
+		err := m.DB.DeleteHold(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return false
+		}
+	}
+
+	expires := time.Now().Add(m.App.HoldDuration)
+
+	holdIDs = nil
+	for _, x := range res.Rooms {
+		holdID, err := m.DB.InsertHold(r.Context(), x.RoomID, res.StartDate, res.EndDate, expires)
+		if err != nil {
+			for _, id := range holdIDs {
+				_ = m.DB.DeleteHold(r.Context(), id)
+			}
+			if errors.Is(err, repository.ErrRoomUnavailable) {
+				m.App.Session.Put(r.Context(), "error", "Sorry, a room you chose is no longer available for your dates. Please search again.")
+				http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+				return false
+			}
+			helpers.ServerError(w, err)
+			return false
+		}
+		holdIDs = append(holdIDs, holdID)
+	}
+
+	m.App.Session.Put(r.Context(), "hold_ids", holdIDs)
+	m.App.Session.Put(r.Context(), "hold_expires", expires.Format("3:04 PM"))
+
+	return true
+}
+
 func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
@@ -450,7 +659,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 
 	if err != nil {
 		log.Println(err)
@@ -473,12 +682,38 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// AdminDashboard shows the emails still waiting in the outbox, and the ones that gave up
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	outbox, err := m.DB.UndeliveredOutboxMessages(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["outbox"] = outbox
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendEmail puts an undelivered email back in the outbox, with a fresh set of attempts
+func (m *Repository) AdminResendEmail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.ResendOutboxMessage(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued again")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
 }
 
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -493,7 +728,7 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 }
 
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.DB.AllReservations(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -545,7 +780,11 @@ func (m *Repository) AdminReservationsCalander(w http.ResponseWriter, r *http.Re
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	// the dates of room restrictions are days, without a time zone
+	monthStart := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -556,32 +795,64 @@ func (m *Repository) AdminReservationsCalander(w http.ResponseWriter, r *http.Re
 	for _, x := range rooms {
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		blockSpans := make(map[string]models.BlockSpan)
+		pendingMap := make(map[string]int)
+		blocks := make(map[int]models.RoomRestriction)
 
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format("01/2/2006")] = 0
 			blockMap[d.Format("01/2/2006")] = 0
+			pendingMap[d.Format("01/2/2006")] = 0
 		}
 
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
 		}
 
 		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
+			if y.Hold() {
+				// holds of guests who are still filling in the reservation form
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					pendingMap[d.Format("01/2/2006")] = y.ID
+				}
+			} else if y.ReservationID > 0 {
+				// the day of departure is free for the next guest
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
 					reservationMap[d.Format("01/2/2006")] = y.ReservationID
 				}
 			} else {
-				blockMap[y.StartDate.Format("01/2/2006")] = y.ID
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					blockMap[d.Format("01/2/2006")] = y.ID
+				}
+				blocks[y.ID] = y
+			}
+		}
+
+		// an owner block is shown as one span, from the first of its days the grid shows as
+		// blocked, so that the row has a cell for every day of the month
+		var open string
+		for d := monthStart; d.Before(monthEnd); d = d.AddDate(0, 0, 1) {
+			day := d.Format("01/2/2006")
+			id := blockMap[day]
+			if id == 0 || reservationMap[day] > 0 || pendingMap[day] > 0 {
+				open = ""
+				continue
+			}
+			if span, ok := blockSpans[open]; ok && span.Block.ID == id {
+				span.Days++
+				blockSpans[open] = span
+				continue
 			}
+			open = day
+			blockSpans[day] = models.BlockSpan{Block: blocks[id], Days: 1}
 		}
 
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		data[fmt.Sprintf("block_spans_%d", x.ID)] = blockSpans
+		data[fmt.Sprintf("pending_map_%d", x.ID)] = pendingMap
 	}
 
 	render.Template(w, r, "admin-reservations-calander.page.tmpl", &models.TemplateData{
@@ -595,7 +866,24 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.Processed = 1
+	events, err := reservationEvents(models.EventReservationProcessed, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateProcessedForReservation(r.Context(), id, 1, events)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -614,7 +902,24 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.DeleteReservation(id)
+	// read the reservation first, for the event to tell what was deleted
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	events, err := reservationEvents(models.EventReservationDeleted, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), id, events)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -629,6 +934,54 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 
 }
 
+// AdminCancelReservation cancels a reservation with all of its rooms, and lets the guest know
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	flash := "Reservation was already cancelled"
+	if res.Cancelled == 0 {
+		mail, err := cancelledReservationEmails(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		res.Cancelled = 1
+		events, err := reservationEvents(models.EventReservationCancelled, res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.CancelReservation(r.Context(), id, mail, events)
+		switch {
+		case err == nil:
+			flash = "Reservation cancelled"
+		case !errors.Is(err, repository.ErrAlreadyCancelled):
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
 func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
@@ -648,7 +1001,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 	stringMap["month"] = month
 
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -683,7 +1036,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
 
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -694,7 +1047,13 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	events, err := reservationEvents(models.EventReservationUpdated, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateReservation(r.Context(), res, events)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -723,38 +1082,47 @@ func (m *Repository) AdminPostReservationsCalander(w http.ResponseWriter, r *htt
 	year, _ := strconv.Atoi(r.Form.Get("y"))
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
-
-	for _, x := range rooms {
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			if val, ok := curMap[name]; ok {
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						err := m.DB.DeleteBlockById(value)
-						if err != nil {
-							helpers.ServerError(w, err)
-							return
-						}
-					}
-				}
-			}
-		}
-	}
-
-	for name, _ := range r.PostForm {
+	days := make(map[int][]time.Time)
+	for name := range r.PostForm {
 		if strings.HasPrefix(name, "add_block") {
 			exploded := strings.Split(name, "_")
 			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("01/02/2006", exploded[3])
+			t, err := time.Parse("01/2/2006", exploded[3])
+			if err != nil {
+				continue
+			}
+			days[roomID] = append(days[roomID], t)
+		}
+	}
+
+	// days ticked next to each other become one block
+	for _, x := range rooms {
+		ticked := days[x.ID]
+		sort.Slice(ticked, func(i, j int) bool { return ticked[i].Before(ticked[j]) })
+
+		for i := 0; i < len(ticked); {
+			block := models.RoomRestriction{
+				RoomID:    x.ID,
+				StartDate: ticked[i],
+				EndDate:   ticked[i].AddDate(0, 0, 1),
+				Category:  "owner_use",
+			}
+			for i++; i < len(ticked) && ticked[i].Equal(block.EndDate); i++ {
+				block.EndDate = block.EndDate.AddDate(0, 0, 1)
+			}
 
-			err := m.DB.InsertBlockForRoom(roomID, t)
+			_, err = m.DB.InsertBlockForRoom(r.Context(), block, addedBlockEvents)
+			if errors.Is(err, repository.ErrRoomUnavailable) {
+				m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s was booked in the meantime, for some of the days you ticked", x.RoomName))
+				http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+				return
+			}
 			if err != nil {
 				helpers.ServerError(w, err)
 				return
@@ -766,3 +1134,1371 @@ func (m *Repository) AdminPostReservationsCalander(w http.ResponseWriter, r *htt
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 
 }
+
+// AdminNewBlock shows the form for blocking a room for some dates. The room and the first
+// day can be given in the query string, as the calendar does.
+func (m *Repository) AdminNewBlock(w http.ResponseWriter, r *http.Request) {
+	block := models.RoomRestriction{Category: "maintenance"}
+	block.RoomID, _ = strconv.Atoi(r.URL.Query().Get("room_id"))
+
+	start, err := time.Parse("01/02/2006", r.URL.Query().Get("s"))
+	if err == nil {
+		block.StartDate = start
+		block.EndDate = start.AddDate(0, 0, 1)
+	}
+
+	m.renderBlockForm(w, r, block, forms.New(nil))
+}
+
+// AdminPostNewBlock blocks a room for some dates
+func (m *Repository) AdminPostNewBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	block, form := blockFromForm(r, models.RoomRestriction{})
+	if !form.Valid() {
+		m.renderBlockForm(w, r, block, form)
+		return
+	}
+
+	block.ID, err = m.DB.InsertBlockForRoom(r.Context(), block, addedBlockEvents)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("start_date", "The room is taken for some of these dates")
+		m.renderBlockForm(w, r, block, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room blocked")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminShowBlock shows the form for editing an owner block
+func (m *Repository) AdminShowBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	block, err := m.DB.GetBlockByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderBlockForm(w, r, block, forms.New(nil))
+}
+
+// AdminPostShowBlock saves changes to an owner block
+func (m *Repository) AdminPostShowBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	block, err := m.DB.GetBlockByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	block, form := blockFromForm(r, block)
+	if !form.Valid() {
+		m.renderBlockForm(w, r, block, form)
+		return
+	}
+
+	events, err := blockEvents(models.EventBlockUpdated, block)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateBlock(r.Context(), block, events)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("start_date", "The room is taken for some of these dates")
+		m.renderBlockForm(w, r, block, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminDeleteBlock deletes an owner block
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	block, err := m.DB.GetBlockByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	events, err := blockEvents(models.EventBlockRemoved, block)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteBlockById(r.Context(), block.ID, events)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block deleted")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// blockFromForm copies the posted owner block form onto block and validates it. The room of
+// a block can only be chosen when it is added.
+func blockFromForm(r *http.Request, block models.RoomRestriction) (models.RoomRestriction, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date", "category")
+
+	if block.ID == 0 {
+		form.Required("room_id")
+		block.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	}
+
+	layout := "01/02/2006"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The block must end after it starts")
+	}
+	block.StartDate = startDate
+	block.EndDate = endDate
+
+	block.Category = r.Form.Get("category")
+	known := false
+	for _, x := range models.BlockCategories {
+		if x.Value == block.Category {
+			known = true
+		}
+	}
+	if !known {
+		form.Errors.Add("category", "Choose a category")
+	}
+
+	block.Reason = strings.TrimSpace(r.Form.Get("reason"))
+
+	return block, form
+}
+
+// renderBlockForm renders the admin form for adding or editing an owner block
+func (m *Repository) renderBlockForm(w http.ResponseWriter, r *http.Request, block models.RoomRestriction, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["block"] = block
+	data["rooms"] = rooms
+	data["categories"] = models.BlockCategories
+
+	render.Template(w, r, "admin-block.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// blockCalendarURL returns the admin calendar of the month an owner block starts in
+func blockCalendarURL(block models.RoomRestriction) string {
+	return fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", block.StartDate.Year(), block.StartDate.Month())
+}
+
+// AdminRooms lists all the rooms for the admin
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form for adding a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomForm(w, r, models.Room{MaxOccupancy: 2}, forms.New(nil))
+}
+
+// AdminPostNewRoom adds a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := m.roomFromForm(r, models.Room{})
+	if !form.Valid() {
+		m.renderRoomForm(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room added")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form for editing a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomForm(w, r, room, forms.New(nil))
+}
+
+// AdminPostShowRoom saves changes to a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := m.roomFromForm(r, room)
+	if !form.Valid() {
+		m.renderRoomForm(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteRoom(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// roomFromForm copies the posted room form onto room and validates it
+func (m *Repository) roomFromForm(r *http.Request, room models.Room) (models.Room, *forms.Form) {
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	room.Image = strings.TrimSpace(r.Form.Get("image"))
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.BedTypes = strings.TrimSpace(r.Form.Get("bed_types"))
+
+	room.Amenities = nil
+	for _, x := range r.Form["amenities"] {
+		id, err := strconv.Atoi(x)
+		if err == nil {
+			room.Amenities = append(room.Amenities, models.Amenity{ID: id})
+		}
+	}
+
+	if room.Slug == "" {
+		room.Slug = helpers.Slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "max_occupancy", "base_rate")
+	form.IsSlug("slug")
+
+	maxOccupancy, err := strconv.Atoi(r.Form.Get("max_occupancy"))
+	if err != nil || maxOccupancy < 1 {
+		form.Errors.Add("max_occupancy", "Must be a whole number of at least 1")
+	}
+	room.MaxOccupancy = maxOccupancy
+
+	baseRate, err := pricing.ParseAmount(r.Form.Get("base_rate"))
+	if err != nil {
+		form.Errors.Add("base_rate", "Must be an amount, like 120.00")
+	}
+	room.BaseRate = baseRate
+
+	if form.Valid() {
+		existing, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+		if err == nil && existing.ID != room.ID {
+			form.Errors.Add("slug", "This slug is already used by another room")
+		}
+	}
+
+	return room, form
+}
+
+// renderRoomForm renders the admin form for adding or editing a room
+func (m *Repository) renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	selected := make(map[int]bool)
+	for _, a := range room.Amenities {
+		selected[a.ID] = true
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["amenities"] = amenities
+	data["selected_amenities"] = selected
+
+	render.Template(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminAmenities lists the amenities rooms can have
+func (m *Repository) AdminAmenities(w http.ResponseWriter, r *http.Request) {
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["amenities"] = amenities
+
+	render.Template(w, r, "admin-amenities.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostAmenities adds an amenity
+func (m *Repository) AdminPostAmenities(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("amenity_name")
+
+	if !form.Valid() {
+		amenities, err := m.DB.AllAmenities(r.Context())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["amenities"] = amenities
+
+		render.Template(w, r, "admin-amenities.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertAmenity(r.Context(), models.Amenity{
+		AmenityName: strings.TrimSpace(r.Form.Get("amenity_name")),
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Amenity added")
+	http.Redirect(w, r, "/admin/amenities", http.StatusSeeOther)
+}
+
+// AdminDeleteAmenity deletes an amenity
+func (m *Repository) AdminDeleteAmenity(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteAmenity(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Amenity deleted")
+	http.Redirect(w, r, "/admin/amenities", http.StatusSeeOther)
+}
+
+// AdminAPITokens lists the API tokens of the staff. A token that was just made is shown with
+// its secret, this once.
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	m.renderAPITokens(w, r, forms.New(nil))
+}
+
+// AdminPostAPIToken makes an API token for the staff member who is logged in
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	if userID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Log in to make an API token")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	if !form.Valid() {
+		m.renderAPITokens(w, r, form)
+		return
+	}
+
+	_, secret, err := m.DB.InsertAPIToken(r.Context(), models.APIToken{
+		UserID: userID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "api_token", secret)
+	m.App.Session.Put(r.Context(), "flash", "API token made; copy it now, it won't be shown again")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+// AdminDeleteAPIToken revokes an API token
+func (m *Repository) AdminDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteAPIToken(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+// renderAPITokens renders the admin API tokens page
+func (m *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	tokens, err := m.DB.AllAPITokens(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["tokens"] = tokens
+
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = m.App.Session.PopString(r.Context(), "api_token")
+
+	render.Template(w, r, "admin-api-tokens.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// priceReservation prices every room of res for the dates of res, and makes the first of
+// them the reservation's RoomID and Room
+func (m *Repository) priceReservation(ctx context.Context, res models.Reservation) (models.Reservation, error) {
+	if len(res.Rooms) == 0 {
+		return res, errors.New("reservation has no rooms")
+	}
+
+	rooms := make([]models.ReservationRoom, len(res.Rooms))
+	copy(rooms, res.Rooms)
+
+	res.TotalPrice = 0
+	for i := range rooms {
+		room, err := m.DB.GetRoomByID(ctx, rooms[i].RoomID)
+		if err != nil {
+			return res, err
+		}
+
+		quote, err := m.quoteStay(ctx, room, res.StartDate, res.EndDate)
+		if err != nil {
+			return res, err
+		}
+
+		rooms[i].Room = room
+		rooms[i].TotalPrice = quote.Total
+		rooms[i].PriceBreakdown = quote.Nights
+		res.TotalPrice += quote.Total
+	}
+
+	res.Rooms = rooms
+	res.RoomID = rooms[0].RoomID
+	res.Room = rooms[0].Room
+
+	return res, nil
+}
+
+// quoteStay prices a stay in room with the room's current rates
+func (m *Repository) quoteStay(ctx context.Context, room models.Room, start, end time.Time) (models.Quote, error) {
+	seasons, err := m.DB.GetSeasonalRatesForRoom(ctx, room.ID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	modifiers, err := m.DB.GetRateModifiersForRoom(ctx, room.ID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, seasons, modifiers, start, end)
+}
+
+// AdminRoomRates shows the seasonal rates and day of week modifiers of a room
+func (m *Repository) AdminRoomRates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomRates(w, r, room, forms.New(nil))
+}
+
+// AdminPostSeasonalRate adds a seasonal rate to a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("season_name", "start_date", "end_date", "nightly_rate")
+
+	layout := "01/02/2006"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The season must end after it starts")
+	}
+	nightlyRate, err := pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Must be an amount, like 120.00")
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertSeasonalRate(r.Context(), models.SeasonalRate{
+		RoomID:      room.ID,
+		SeasonName:  strings.TrimSpace(r.Form.Get("season_name")),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		NightlyRate: nightlyRate,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Season added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate deletes a seasonal rate of a room
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteSeasonalRate(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Season deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", roomID), http.StatusSeeOther)
+}
+
+// AdminPostRateModifiers saves the day of week modifiers of a room
+func (m *Repository) AdminPostRateModifiers(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	var modifiers []models.RateModifier
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		field := fmt.Sprintf("percent_%d", d)
+		if strings.TrimSpace(r.Form.Get(field)) == "" {
+			continue
+		}
+
+		percent, err := strconv.Atoi(strings.TrimSpace(r.Form.Get(field)))
+		if err != nil || percent <= -100 {
+			form.Errors.Add(field, "Must be a whole percentage, greater than -100")
+			continue
+		}
+
+		modifiers = append(modifiers, models.RateModifier{
+			RoomID:  room.ID,
+			Weekday: d,
+			Percent: percent,
+		})
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRateModifiersForRoom(r.Context(), room.ID, modifiers)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", room.ID), http.StatusSeeOther)
+}
+
+// renderRoomRates renders the admin rates page of a room
+func (m *Repository) renderRoomRates(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	seasons, err := m.DB.GetSeasonalRatesForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	modifiers, err := m.DB.GetRateModifiersForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	weekdays := make([]models.RateModifier, 7)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays[d].Weekday = d
+	}
+	for _, x := range modifiers {
+		weekdays[x.Weekday].Percent = x.Percent
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["seasons"] = seasons
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-room-rates.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminRoomStayRules shows the stay rules of a room
+func (m *Repository) AdminRoomStayRules(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomStayRules(w, r, room, forms.New(nil))
+}
+
+// AdminPostStayRule adds a stay rule to a room
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "01/02/2006"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The rule must end after it starts")
+	}
+
+	nights := func(field string) int {
+		if strings.TrimSpace(r.Form.Get(field)) == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(r.Form.Get(field)))
+		if err != nil || n < 0 {
+			form.Errors.Add(field, "Must be a whole number of nights")
+			return 0
+		}
+		return n
+	}
+	minNights := nights("min_nights")
+	maxNights := nights("max_nights")
+	if maxNights > 0 && maxNights < minNights {
+		form.Errors.Add("max_nights", "Can't be less than the minimum stay")
+	}
+
+	var weekdays []time.Weekday
+	for _, x := range r.Form["arrival_weekdays"] {
+		d, err := strconv.Atoi(x)
+		if err == nil && d >= int(time.Sunday) && d <= int(time.Saturday) {
+			weekdays = append(weekdays, time.Weekday(d))
+		}
+	}
+
+	rule := models.StayRule{
+		RoomID:            room.ID,
+		StartDate:         startDate,
+		EndDate:           endDate,
+		MinNights:         minNights,
+		MaxNights:         maxNights,
+		ClosedToArrival:   r.Form.Get("closed_to_arrival") != "",
+		ClosedToDeparture: r.Form.Get("closed_to_departure") != "",
+		ArrivalWeekdays:   weekdays,
+	}
+
+	if form.Valid() && rule.MinNights == 0 && rule.MaxNights == 0 && !rule.ClosedToArrival &&
+		!rule.ClosedToDeparture && len(rule.ArrivalWeekdays) == 0 {
+		form.Errors.Add("min_nights", "Choose at least one restriction")
+	}
+
+	if !form.Valid() {
+		m.renderRoomStayRules(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/stay-rules", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule deletes a stay rule of a room
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteStayRule(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/stay-rules", roomID), http.StatusSeeOther)
+}
+
+// renderRoomStayRules renders the admin stay rules page of a room
+func (m *Repository) renderRoomStayRules(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	rules, err := m.DB.GetStayRulesForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	weekdays := make([]time.Weekday, 7)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays[d] = d
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rules"] = rules
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-room-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminRoomCalendars shows the address of the calendar feed of a room, and the external
+// calendars imported into it
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomCalendars(w, r, room, forms.New(nil))
+}
+
+// AdminPostCalendarFeed adds an external calendar to a room and imports it right away
+func (m *Repository) AdminPostCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// calendar apps are given webcal:// addresses, which are fetched over https
+	url := strings.TrimSpace(r.Form.Get("url"))
+	if strings.HasPrefix(strings.ToLower(url), "webcal://") {
+		url = "https://" + url[len("webcal://"):]
+	}
+	r.PostForm.Set("url", url)
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "url")
+	if form.Has("url") {
+		form.IsURL("url")
+	}
+
+	if !form.Valid() {
+		m.renderRoomCalendars(w, r, room, form)
+		return
+	}
+
+	feed := models.CalendarFeed{
+		RoomID: room.ID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+		URL:    url,
+	}
+	feed.ID, err = m.DB.InsertCalendarFeed(r.Context(), feed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.syncCalendarFeed(r, feed, "Calendar added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+}
+
+// AdminSyncCalendarFeed imports an external calendar of a room now, rather than waiting for
+// the next sync
+func (m *Repository) AdminSyncCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	feed, err := m.DB.GetCalendarFeedByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.syncCalendarFeed(r, feed, "Calendar synced")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", roomID), http.StatusSeeOther)
+}
+
+// syncCalendarFeed imports feed and tells the admin how it went. Failures are recorded on the
+// feed, so they are shown as a warning rather than an error page.
+func (m *Repository) syncCalendarFeed(r *http.Request, feed models.CalendarFeed, done string) {
+	result, err := calsync.New(m.DB).Sync(r.Context(), feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s, but it could not be imported: %v", done, err))
+		return
+	}
+	if len(result.Conflicts) > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s, but %d of its events fall on dates the room is already taken for", done, len(result.Conflicts)))
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s: %d added, %d moved, %d removed", done, result.Added, result.Moved, result.Removed))
+}
+
+// AdminDeleteCalendarFeed stops importing an external calendar of a room, and frees the dates
+// it blocked
+func (m *Repository) AdminDeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteCalendarFeed(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", roomID), http.StatusSeeOther)
+}
+
+// AdminResetCalendarToken gives the calendar feed of a room a new address, for when the old
+// one was shared with a site the room is no longer listed on
+func (m *Repository) AdminResetCalendarToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	_, err := m.DB.ResetRoomCalendarToken(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "The calendar feed has a new address; give it to the booking sites the room is listed on")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+// renderRoomCalendars renders the admin calendars page of a room
+func (m *Repository) renderRoomCalendars(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	feeds, err := m.DB.GetCalendarFeedsForRoom(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	scheme := "http"
+	if m.App.InProduction {
+		scheme = "https"
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["feeds"] = feeds
+	data["feed_url"] = fmt.Sprintf("%s://%s/rooms/%d/calendar.ics?token=%s", scheme, r.Host, room.ID, room.CalendarToken)
+
+	render.Template(w, r, "admin-room-calendars.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// ManageBooking shows the form where guests look up their reservation
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostManageBooking looks up a reservation by its confirmation code and the guest's email
+func (m *Repository) PostManageBooking(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirmation_code", "email")
+	form.IsEmail("email")
+
+	if form.Valid() {
+		res, err := m.lookUpBooking(r.Context(), r.Form.Get("confirmation_code"), r.Form.Get("email"))
+		if err == nil {
+			_ = m.App.Session.RenewToken(r.Context())
+			m.App.Session.Put(r.Context(), "manage_reservation_id", res.ID)
+			http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+			return
+		}
+		if err != sql.ErrNoRows {
+			helpers.ServerError(w, err)
+			return
+		}
+		form.Errors.Add("confirmation_code", "We couldn't find a booking with this confirmation code and email")
+	}
+
+	render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: form,
+	})
+}
+
+// lookUpBooking returns the reservation with a confirmation code, if it was made with email.
+// It returns sql.ErrNoRows when there is no such reservation.
+func (m *Repository) lookUpBooking(ctx context.Context, code, email string) (models.Reservation, error) {
+	res, err := m.DB.GetReservationByCode(ctx, code)
+	if err != nil {
+		return res, err
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(res.Email), strings.TrimSpace(email)) {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	return res, nil
+}
+
+// ManageBookingShow shows the guest's reservation, with forms to change its dates or cancel it
+func (m *Repository) ManageBookingShow(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["changeable"] = bookingChangeable(res)
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("01/02/2006")
+	stringMap["end_date"] = res.EndDate.Format("01/02/2006")
+
+	render.Template(w, r, "manage-booking-show.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostManageBookingChange moves the guest's reservation to new dates, if the room is free
+func (m *Repository) PostManageBookingChange(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if !bookingChangeable(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	layout := "01/02/2006"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid arrival date")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid departure date")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+
+	if !endDate.After(startDate) || !startDate.After(time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "Please choose an arrival date in the future, and a departure date after it")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+
+	oldStart, oldEnd := res.StartDate, res.EndDate
+
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	res, err = m.priceReservation(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the change raises the calendar sequence, so the guest's calendar takes the new dates
+	res.CalendarSequence++
+
+	mail, err := guestAndOwnerEmails(res,
+		models.ChangedReservationEmail{Reservation: res, OldStartDate: oldStart, OldEndDate: oldEnd},
+		models.ChangedReservationEmail{Reservation: res, OldStartDate: oldStart, OldEndDate: oldEnd, ForOwner: true},
+		ical.MethodRequest)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	events, err := reservationEvents(models.EventReservationUpdated, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res, mail, events)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, your rooms aren't available for those dates")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		m.App.Session.Put(r.Context(), "error", violation.Reason)
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+}
+
+// PostManageBookingCancel cancels the guest's reservation
+func (m *Repository) PostManageBookingCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if !bookingChangeable(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+
+	mail, err := cancelledReservationEmails(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.Cancelled = 1
+	events, err := reservationEvents(models.EventReservationCancelled, res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.CancelReservation(r.Context(), res.ID, mail, events)
+	if errors.Is(err, repository.ErrAlreadyCancelled) {
+		m.App.Session.Put(r.Context(), "error", "This reservation is already cancelled")
+		http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/manage-booking/show", http.StatusSeeOther)
+}
+
+// managedReservation returns the reservation the guest looked up. When there is none it
+// redirects to the look up form and returns false.
+func (m *Repository) managedReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id := m.App.Session.GetInt(r.Context(), "manage_reservation_id")
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please look up your booking first")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// bookingChangeable reports whether a guest may still change or cancel a reservation
+func bookingChangeable(res models.Reservation) bool {
+	return res.Cancelled == 0 && res.StartDate.After(time.Now())
+}
+
+// cancelledReservationEmails let the guest and the owner know that a reservation is cancelled.
+// The cancellation raises the calendar sequence, so the guest's calendar drops the stay.
+func cancelledReservationEmails(res models.Reservation) ([]models.MailData, error) {
+	res.CalendarSequence++
+
+	return guestAndOwnerEmails(res,
+		models.CancelledReservationEmail{Reservation: res},
+		models.CancelledReservationEmail{Reservation: res, ForOwner: true},
+		ical.MethodCancel)
+}
+
+// guestAndOwnerEmails renders the email of guest to the guest of res, with the calendar event
+// of the stay sent with method, and the one of owner to the owner
+func guestAndOwnerEmails(res models.Reservation, guest, owner models.EmailData, method string) ([]models.MailData, error) {
+	toGuest, err := render.Email(guest)
+	if err != nil {
+		return nil, err
+	}
+	toGuest.To = res.Email
+	toGuest.From = "info@LeafVillage.com"
+	toGuest.Attachments = append(toGuest.Attachments, stayCalendar(res, method))
+
+	toOwner, err := render.Email(owner)
+	if err != nil {
+		return nil, err
+	}
+	toOwner.To = "Naruto@LeafVillage.com"
+	toOwner.From = "info@LeafVillage.com"
+
+	return []models.MailData{toGuest, toOwner}, nil
+}
+
+// stayCalendar is the .ics file of the stay of res, from check-in to check-out. Its UID comes
+// from the reservation, so that a calendar app updates the event it has when a change or a
+// cancellation comes with a higher sequence.
+func stayCalendar(res models.Reservation, method string) models.Attachment {
+	status := ical.StatusConfirmed
+	if method == ical.MethodCancel {
+		status = ical.StatusCancelled
+	}
+
+	c := ical.Calendar{
+		Method: method,
+		Events: []ical.Event{{
+			UID:         fmt.Sprintf("reservation-%d@LeafVillage.com", res.ID),
+			Sequence:    res.CalendarSequence,
+			Start:       res.StartDate,
+			End:         res.EndDate,
+			Summary:     fmt.Sprintf("Stay at Leaf Village: %s", res.RoomNames()),
+			Location:    fmt.Sprintf("%s, Leaf Village", res.RoomNames()),
+			Description: fmt.Sprintf("Confirmation code: %s", res.ConfirmationCode),
+			Status:      status,
+			Organizer:   ical.Address{Name: "Leaf Village", Email: "info@LeafVillage.com"},
+			Attendees:   []ical.Address{{Name: res.FirstName + " " + res.LastName, Email: res.Email}},
+		}},
+	}
+
+	return models.Attachment{
+		Name:        "reservation.ics",
+		ContentType: fmt.Sprintf("%s; charset=utf-8; method=%s", ical.ContentType, method),
+		Data:        c.Bytes(),
+	}
+}
@@ -9,7 +9,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/ical"
 	"github.com/taldrori/bookings/internal/models"
 )
 
@@ -21,10 +25,16 @@ var theTests = []struct {
 }{
 	{"home", "/", "Get", http.StatusOK},
 	{"about", "/about", "Get", http.StatusOK},
-	{"jq", "/jonins-quarters", "Get", http.StatusOK},
-	{"hs", "/hokages-suite", "Get", http.StatusOK},
+	{"rooms", "/rooms", "Get", http.StatusOK},
+	{"jq", "/rooms/jonins-quarters", "Get", http.StatusOK},
+	{"hs", "/rooms/hokages-suite", "Get", http.StatusOK},
+	{"non-existent-room", "/rooms/no-such-room", "Get", http.StatusNotFound},
 	{"search-availability", "/search-availability", "Get", http.StatusOK},
 	{"contact", "/contact", "Get", http.StatusOK},
+	{"manage-booking", "/manage-booking", "Get", http.StatusOK},
+	{"calendar-feed", "/rooms/1/calendar.ics?token=room-1-token", "Get", http.StatusOK},
+	{"calendar-feed-wrong-token", "/rooms/1/calendar.ics?token=room-2-token", "Get", http.StatusNotFound},
+	{"calendar-feed-no-token", "/rooms/1/calendar.ics", "Get", http.StatusNotFound},
 	// {"post-search-availability", "/search-availability", "POST", []postData{
 	// 	{key: "start", value: "01/01/2020"},
 	// 	{key: "end", value: "01/02/2020"},
@@ -60,7 +70,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "Jonin's Quarters",
@@ -141,6 +153,8 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
+	sentMail.Reset()
+
 	handler := http.HandlerFunc(Repo.PostReservation)
 
 	handler.ServeHTTP(rr, req)
@@ -149,6 +163,30 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
+	// the guest and the owner are emailed
+	sent := sentMail.Sent()
+	if len(sent) != 2 {
+		t.Fatalf("PostReservation handler sent %d emails, wanted 2", len(sent))
+	}
+	if sent[0].To != "tal@drori.com" || sent[0].Subject != "Reservation Confirmation LV-TEST-01" ||
+		!strings.Contains(sent[0].Text, "Dear Tal,") || !strings.Contains(sent[0].Content, "LV-TEST-01") {
+		t.Errorf("PostReservation handler sent an unexpected confirmation %+v", sent[0])
+	}
+	if sent[1].To != "Naruto@LeafVillage.com" || sent[1].Subject != "Reservation Notification LV-TEST-01" {
+		t.Errorf("PostReservation handler sent an unexpected notification %+v", sent[1])
+	}
+
+	// the confirmation comes with the stay for the guest's calendar
+	ics := stayCalendarOf(sent[0])
+	for _, want := range []string{"METHOD:REQUEST", "UID:reservation-1@LeafVillage.com", "SEQUENCE:0", "STATUS:CONFIRMED"} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("PostReservation handler attached a calendar without %q:\n%s", want, ics)
+		}
+	}
+	if len(sent[1].Attachments) != 0 {
+		t.Errorf("PostReservation handler attached %d files to the notification, wanted none", len(sent[1].Attachments))
+	}
+
 	// no reservation in context
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
 	ctx = getCTX(req)
@@ -326,6 +364,60 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler failed when trying to insert to restriction: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+
+	// test for a room that got booked by someone else in the meantime
+	reqBody = "start_date=01/01/2070"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=01/03/2070")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Tal")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Drori")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=tal@drori.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=555555555")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCTX(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code when the room is unavailable: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler redirected to %s when the room is unavailable, wanted /search-availability", rr.Header().Get("Location"))
+	}
+
+	// test for a stay that breaks a stay rule
+	reqBody = "start_date=01/01/2045"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=01/02/2045")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Tal")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Drori")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=tal@drori.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=555555555")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+	ctx = getCTX(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler redirected to %s when the stay breaks a stay rule, wanted /search-availability", rr.Header().Get("Location"))
+	}
+	if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "at least 3 nights") {
+		t.Errorf("PostReservation handler didn't give the stay rule as the reason: %q", msg)
+	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
@@ -369,6 +461,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	if j.OK {
 		t.Error("Got availability when none was expected in AvailabilityJSON")
 	}
+	checkAPISpec(t, "POST", "/search-availability-json", rr)
 
 	/*****************************************
 	// second case -- rooms not available
@@ -408,6 +501,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	if !j.OK {
 		t.Error("Got no availability when some was expected in AvailabilityJSON")
 	}
+	checkAPISpec(t, "POST", "/search-availability-json", rr)
 
 	/*****************************************
 	// third case -- no request body
@@ -432,16 +526,12 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// this time we want to parse JSON and get the expected response
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse json!")
-	}
-
-	// since we specified a start date < 2049-12-31, we expect availability
-	if j.OK || j.Message != "Internal server error" {
-		t.Error("Got availability when request body was empty")
+	// a request without a form is the client's fault
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("AvailabilityJSON returned wrong response code when request body was empty: got %d, wanted %d", rr.Code, http.StatusBadRequest)
 	}
+	checkAPIError(t, rr, helpers.CodeInvalidRequest, "")
+	checkAPISpec(t, "POST", "/search-availability-json", rr)
 
 	/*****************************************
 	// fourth case -- database error
@@ -469,15 +559,548 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// this time we want to parse JSON and get the expected response
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AvailabilityJSON returned wrong response code when simulating database error: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+	checkAPIError(t, rr, helpers.CodeInternal, "")
+	checkAPISpec(t, "POST", "/search-availability-json", rr)
+
+	/*****************************************
+	// fifth case -- stay breaks a stay rule
+	*****************************************/
+	reqBody = "start=01/01/2045"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=01/02/2045")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+	ctx = getCTX(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AvailabilityJson)
+	handler.ServeHTTP(rr, req)
+
+	j = jsonResponse{}
 	err = json.Unmarshal([]byte(rr.Body.String()), &j)
 	if err != nil {
 		t.Error("failed to parse json!")
 	}
 
-	// since we specified a start date < 2049-12-31, we expect availability
-	if j.OK || j.Message != "Error querying database" {
-		t.Error("Got availability when simulating database error")
+	if j.OK || !strings.Contains(j.Message, "at least 3 nights") {
+		t.Errorf("Expected the stay rule as the reason in AvailabilityJSON, got ok %t and message %q", j.OK, j.Message)
+	}
+
+	/*****************************************
+	// sixth case -- invalid dates and room
+	*****************************************/
+	var tests = []struct {
+		name   string
+		body   string
+		fields string
+	}{
+		{"invalid arrival", "start=2050-01-01&end=01/02/2050&room_id=1", "start"},
+		{"departure before arrival", "start=01/03/2050&end=01/02/2050&room_id=1", "end"},
+		{"invalid room", "start=01/01/2050&end=01/02/2050&room_id=one", "room_id"},
+		{"nothing", "csrf_token=abc", "end,room_id,start"},
+	}
+
+	for _, e := range tests {
+		req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(e.body))
+		req = req.WithContext(getCTX(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr = httptest.NewRecorder()
+		handler = http.HandlerFunc(Repo.AvailabilityJson)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("AvailabilityJSON returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, http.StatusBadRequest)
+		}
+		checkAPIError(t, rr, helpers.CodeInvalidRequest, e.fields)
+		checkAPISpec(t, "POST", "/search-availability-json", rr)
+	}
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"rooms available", "start=01/01/2040&end=01/02/2040", http.StatusOK},
+		{"rooms available for guests and amenities", "start=01/01/2040&end=01/02/2040&guests=2&amenities=1", http.StatusOK},
+		{"no room for that many guests", "start=01/01/2040&end=01/02/2040&guests=10", http.StatusSeeOther},
+		{"invalid guests", "start=01/01/2040&end=01/02/2040&guests=none", http.StatusSeeOther},
+		{"no rooms available", "start=01/01/2050&end=01/02/2050", http.StatusSeeOther},
+		{"a room left out by a stay rule", "start=01/01/2045&end=01/02/2045", http.StatusOK},
+		{"invalid arrival", "start=2040-01-01&end=01/02/2040", http.StatusSeeOther},
+		{"departure before arrival", "start=01/02/2040&end=01/01/2040", http.StatusSeeOther},
+		{"database error", "start=01/01/2060&end=01/02/2060", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailability)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("PostAvailability handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"valid", "room_name=Genin Dorm&slug=genin-dorm&max_occupancy=6&base_rate=120.00&amenities=1&amenities=2", http.StatusSeeOther},
+		{"slug generated from name", "room_name=Genin's Dorm&max_occupancy=6&base_rate=120.00", http.StatusSeeOther},
+		{"missing name", "room_name=&slug=genin-dorm&max_occupancy=6&base_rate=120.00", http.StatusOK},
+		{"invalid slug", "room_name=Genin Dorm&slug=Genin Dorm&max_occupancy=6&base_rate=120.00", http.StatusOK},
+		{"slug already used", "room_name=Genin Dorm&slug=jonins-quarters&max_occupancy=6&base_rate=120.00", http.StatusOK},
+		{"invalid max occupancy", "room_name=Genin Dorm&slug=genin-dorm&max_occupancy=0&base_rate=120.00", http.StatusOK},
+		{"invalid base rate", "room_name=Genin Dorm&slug=genin-dorm&max_occupancy=6&base_rate=abc", http.StatusOK},
+		{"insert fails", "room_name=fail&max_occupancy=6&base_rate=120.00", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostNewRoom handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminShowRoom(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid", "1", http.StatusOK},
+		{"deleted room", "99", http.StatusNotFound},
+		{"database error", "1000", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/rooms/"+e.id, nil)
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminShowRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminShowRoom handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostStayRule(t *testing.T) {
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"minimum stay", "start_date=07/01/2050&end_date=09/01/2050&min_nights=3", http.StatusSeeOther},
+		{"arrival weekdays", "start_date=07/01/2050&end_date=09/01/2050&arrival_weekdays=5&arrival_weekdays=6", http.StatusSeeOther},
+		{"closed to departure", "start_date=08/10/2050&end_date=08/12/2050&closed_to_departure=1", http.StatusSeeOther},
+		{"no restriction", "start_date=07/01/2050&end_date=09/01/2050", http.StatusOK},
+		{"invalid nights", "start_date=07/01/2050&end_date=09/01/2050&min_nights=two", http.StatusOK},
+		{"maximum below minimum", "start_date=07/01/2050&end_date=09/01/2050&min_nights=7&max_nights=3", http.StatusOK},
+		{"ends before it starts", "start_date=09/01/2050&end_date=07/01/2050&min_nights=3", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/stay-rules", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostStayRule handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostNewBlock(t *testing.T) {
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "room_id=1&start_date=03/01/2050&end_date=03/15/2050&category=maintenance&reason=Renovation", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=3"},
+		{"missing room", "start_date=03/01/2050&end_date=03/15/2050&category=maintenance", http.StatusOK, ""},
+		{"unknown category", "room_id=1&start_date=03/01/2050&end_date=03/15/2050&category=party", http.StatusOK, ""},
+		{"ends before it starts", "room_id=1&start_date=03/15/2050&end_date=03/01/2050&category=owner_use", http.StatusOK, ""},
+		{"room taken", "room_id=1&start_date=01/01/2070&end_date=01/15/2070&category=out_of_order", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/new", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostNewBlock)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostNewBlock handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("AdminPostNewBlock handler redirected to %s for %s, wanted %s", rr.Header().Get("Location"), e.name, e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowBlock(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"valid", "1", "start_date=03/01/2050&end_date=03/20/2050&category=maintenance&reason=Renovation, and painting", http.StatusSeeOther},
+		{"invalid date", "1", "start_date=someday&end_date=03/20/2050&category=maintenance", http.StatusOK},
+		{"room taken", "1", "start_date=01/01/2070&end_date=01/15/2070&category=maintenance", http.StatusOK},
+		{"no such block", "2", "start_date=03/01/2050&end_date=03/20/2050&category=maintenance", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/"+e.id, strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowBlock)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostShowBlock handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminDashboard(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCTX(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminDashboard)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminDashboard handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"Naruto@LeafVillage.com", "connection refused", "/admin/resend-email/2/do"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the dashboard to show %q", want)
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2050&m=4", nil)
+	ctx := getCTX(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminReservationsCalander)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AdminReservationsCalander handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// the row of days of room 2, whose stay checks out on April 3, the day a block begins
+	body := rr.Body.String()
+	i := strings.Index(body, "Hokages Suite")
+	if i < 0 {
+		t.Fatal("expected the calendar of room 2")
+	}
+	table := body[i:]
+	table = table[:strings.Index(table, "</table>")]
+	row := table[strings.LastIndex(table, "<tr>"):]
+
+	days := 0
+	for _, cell := range strings.Split(row, "<td")[1:] {
+		n := 1
+		fmt.Sscanf(cell, ` colspan="%d"`, &n)
+		days += n
+	}
+	if days != 30 {
+		t.Errorf("expected a cell for each of the 30 days of April, got %d", days)
+	}
+	if n := strings.Count(row, `text-danger">R<`); n != 2 {
+		t.Errorf("expected the stay to take the nights of April 1 and 2, got %d", n)
+	}
+	if !strings.Contains(row, `colspan="3"`) || !strings.Contains(row, "/admin/blocks/5/show") {
+		t.Error("expected the block to span April 3 to 5")
+	}
+}
+
+func TestRepository_AdminResendEmail(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid", "2", http.StatusSeeOther},
+		{"database error", "1000", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/resend-email/"+e.id+"/do", nil)
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminResendEmail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminResendEmail handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/dashboard" {
+			t.Errorf("AdminResendEmail handler redirected to %s for %s, wanted /admin/dashboard", rr.Header().Get("Location"), e.name)
+		}
+	}
+}
+
+func TestRepository_RoomCalendarFeed(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/rooms/1/calendar.ics?token=room-1-token", nil)
+	ctx := getCTX(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.RoomCalendarFeed)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("RoomCalendarFeed handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+		t.Errorf("expected a calendar, got %s", rr.Header().Get("Content-Type"))
+	}
+
+	c, err := ical.Parse(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reservation, but not the hold nor the block imported from another site
+	var uids []string
+	for _, e := range c.Events {
+		uids = append(uids, e.UID)
+	}
+	if strings.Join(uids, ",") != "restriction-1@LeafVillage.com" {
+		t.Errorf("expected only the reservation in the feed, got %v", uids)
+	}
+}
+
+func TestRepository_AdminPostCalendarFeed(t *testing.T) {
+	// the calendar is imported as soon as it is added
+	feed := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ical.Calendar{}.Bytes())
+	}))
+	defer feed.Close()
+	address := strings.TrimPrefix(feed.URL, "https://")
+
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"valid", "name=Other Site&url=https://" + address + "/1.ics", http.StatusSeeOther},
+		{"webcal address", "name=Other Site&url=webcal://" + address + "/1.ics", http.StatusSeeOther},
+		{"missing name", "name=&url=https://other.site/calendar/1.ics", http.StatusOK},
+		{"missing address", "name=Other Site&url=", http.StatusOK},
+		{"not an address", "name=Other Site&url=other.site/calendar", http.StatusOK},
+		{"not a web address", "name=Other Site&url=file:///etc/passwd", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/calendars", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCalendarFeed)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostCalendarFeed handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/rooms/1/calendars" {
+			t.Errorf("AdminPostCalendarFeed handler redirected to %s for %s, wanted /admin/rooms/1/calendars", rr.Header().Get("Location"), e.name)
+		}
+	}
+}
+
+func TestRepository_AdminWebhooks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/webhooks", nil)
+	ctx := getCTX(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminWebhooks)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminWebhooks handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"https://partner.site/hooks/bookings", "reservation.cancelled", `value="block.removed"`} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the webhooks page to show %q", want)
+		}
+	}
+	if strings.Contains(rr.Body.String(), "whsec_test") {
+		t.Error("expected the webhooks page not to show the secrets")
+	}
+}
+
+func TestRepository_AdminPostWebhook(t *testing.T) {
+	tests := []struct {
+		name               string
+		reqBody            string
+		expectedStatusCode int
+	}{
+		{"valid", "url=https://partner.site/hooks&events=reservation.created&events=block.added", http.StatusSeeOther},
+		{"missing address", "url=&events=reservation.created", http.StatusOK},
+		{"not a web address", "url=ftp://partner.site/hooks&events=reservation.created", http.StatusOK},
+		{"no events", "url=https://partner.site/hooks", http.StatusOK},
+		{"unknown event", "url=https://partner.site/hooks&events=room.painted", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks", strings.NewReader(e.reqBody))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostWebhook)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminPostWebhook handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			if rr.Header().Get("Location") != "/admin/webhooks" {
+				t.Errorf("AdminPostWebhook handler redirected to %s for %s, wanted /admin/webhooks", rr.Header().Get("Location"), e.name)
+			}
+			if session.GetString(ctx, "webhook_secret") != "whsec_new" {
+				t.Errorf("expected the secret of the new webhook to be kept for showing it once")
+			}
+		}
+	}
+}
+
+func TestRepository_AdminWebhookDeliveries(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/webhooks/deliveries", nil)
+	ctx := getCTX(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminWebhookDeliveries)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminWebhookDeliveries handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"reservation.created", "503 Service Unavailable", "/admin/resend-webhook-delivery/2/do"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the delivery log to show %q", want)
+		}
+	}
+}
+
+func TestRepository_AdminResendWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid", "2", http.StatusSeeOther},
+		{"database error", "1000", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/resend-webhook-delivery/"+e.id+"/do", nil)
+		ctx := getCTX(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminResendWebhookDelivery)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("AdminResendWebhookDelivery handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/webhooks/deliveries" {
+			t.Errorf("AdminResendWebhookDelivery handler redirected to %s for %s, wanted /admin/webhooks/deliveries", rr.Header().Get("Location"), e.name)
+		}
 	}
 }
 
@@ -488,3 +1111,238 @@ func getCTX(req *http.Request) context.Context {
 	}
 	return ctx
 }
+
+func TestRepository_BookRoom(t *testing.T) {
+	var tests = []struct {
+		name             string
+		query            string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"room is held", "id=1&s=01/01/2050&e=01/03/2050", http.StatusSeeOther, "/make-reservation"},
+		{"room was just taken", "id=1&s=01/01/2070&e=01/03/2070", http.StatusSeeOther, "/search-availability"},
+		{"invalid start date", "id=1&s=invalid&e=01/03/2050", http.StatusInternalServerError, ""},
+		{"missing room", "id=3&s=01/01/2050&e=01/03/2050", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/book-room?"+e.query, nil)
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("BookRoom handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("BookRoom handler redirected to %s for %s, wanted %s", rr.Header().Get("Location"), e.name, e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_PostManageBooking(t *testing.T) {
+	var tests = []struct {
+		name             string
+		body             string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"found", "confirmation_code=LV-TEST-01&email=Tal@Drori.com", http.StatusSeeOther, "/manage-booking/show"},
+		{"code in lower case", "confirmation_code=lv-test-01&email=tal@drori.com", http.StatusSeeOther, "/manage-booking/show"},
+		{"wrong email", "confirmation_code=LV-TEST-01&email=someone@else.com", http.StatusOK, ""},
+		{"unknown code", "confirmation_code=LV-NOPE-42&email=tal@drori.com", http.StatusOK, ""},
+		{"booking number instead of code", "confirmation_code=1&email=tal@drori.com", http.StatusOK, ""},
+		{"missing email", "confirmation_code=LV-TEST-01", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/manage-booking", strings.NewReader(e.body))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostManageBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("PostManageBooking handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("PostManageBooking handler redirected to %s for %s, wanted %s", rr.Header().Get("Location"), e.name, e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_PostManageBookingChange(t *testing.T) {
+	var tests = []struct {
+		name          string
+		reservationID int
+		body          string
+		expectedFlash bool
+	}{
+		{"changed", 1, "start_date=02/01/2050&end_date=02/04/2050", true},
+		{"room taken", 1, "start_date=01/01/2070&end_date=01/04/2070", false},
+		{"breaks a stay rule", 1, "start_date=01/01/2045&end_date=01/02/2045", false},
+		{"departure before arrival", 1, "start_date=02/04/2050&end_date=02/01/2050", false},
+		{"invalid date", 1, "start_date=invalid&end_date=02/04/2050", false},
+		{"cancelled reservation", 2, "start_date=02/01/2050&end_date=02/04/2050", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/manage-booking/change", strings.NewReader(e.body))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "manage_reservation_id", e.reservationID)
+
+		sentMail.Reset()
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostManageBookingChange)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("PostManageBookingChange handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); (flash != "") != e.expectedFlash {
+			t.Errorf("PostManageBookingChange handler for %s: unexpected flash %q", e.name, flash)
+		}
+
+		// a change updates the event in the guest's calendar
+		if sent := sentMail.Sent(); e.expectedFlash && len(sent) > 0 {
+			ics := stayCalendarOf(sent[0])
+			for _, want := range []string{"METHOD:REQUEST", "UID:reservation-1@LeafVillage.com", "SEQUENCE:1",
+				"DTSTART;VALUE=DATE:20500201", "DTEND;VALUE=DATE:20500204"} {
+				if !strings.Contains(ics, want) {
+					t.Errorf("PostManageBookingChange handler for %s attached a calendar without %q:\n%s", e.name, want, ics)
+				}
+			}
+		}
+	}
+
+	// the guest hasn't looked up a booking
+	req, _ := http.NewRequest("POST", "/manage-booking/change", nil)
+	ctx := getCTX(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostManageBookingChange)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/manage-booking" {
+		t.Errorf("PostManageBookingChange handler redirected to %s without a looked up booking, wanted /manage-booking", rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_PostManageBookingCancel(t *testing.T) {
+	var tests = []struct {
+		name          string
+		reservationID int
+		expectedFlash bool
+		expectedMail  int
+	}{
+		{"cancelled", 1, true, 2},
+		{"already cancelled", 2, false, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/manage-booking/cancel", nil)
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "manage_reservation_id", e.reservationID)
+
+		sentMail.Reset()
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostManageBookingCancel)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("PostManageBookingCancel handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); (flash != "") != e.expectedFlash {
+			t.Errorf("PostManageBookingCancel handler for %s: unexpected flash %q", e.name, flash)
+		}
+		sent := sentMail.Sent()
+		if len(sent) != e.expectedMail {
+			t.Errorf("PostManageBookingCancel handler for %s sent %d emails, wanted %d", e.name, len(sent), e.expectedMail)
+		}
+		for _, x := range sent {
+			if !strings.HasPrefix(x.Subject, "Reservation Cancelled") {
+				t.Errorf("PostManageBookingCancel handler for %s sent an email about %q", e.name, x.Subject)
+			}
+		}
+
+		// a cancellation removes the event from the guest's calendar
+		if len(sent) > 0 {
+			ics := stayCalendarOf(sent[0])
+			for _, want := range []string{"METHOD:CANCEL", "UID:reservation-1@LeafVillage.com", "SEQUENCE:1", "STATUS:CANCELLED"} {
+				if !strings.Contains(ics, want) {
+					t.Errorf("PostManageBookingCancel handler for %s attached a calendar without %q:\n%s", e.name, want, ics)
+				}
+			}
+		}
+	}
+}
+
+// stayCalendarOf returns the unfolded calendar attached to msg, or an empty string
+func stayCalendarOf(msg models.MailData) string {
+	for _, x := range msg.Attachments {
+		if x.Name == "reservation.ics" && strings.HasPrefix(x.ContentType, "text/calendar") {
+			return strings.ReplaceAll(string(x.Data), "\r\n ", "")
+		}
+	}
+	return ""
+}
+
+func TestRepository_PostChooseRooms(t *testing.T) {
+	reservation := models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	var tests = []struct {
+		name             string
+		body             string
+		expectedCode     int
+		expectedLocation string
+		expectedRooms    int
+	}{
+		{"two rooms", "room_ids=1&room_ids=2", http.StatusSeeOther, "/make-reservation", 2},
+		{"one room", "room_ids=1", http.StatusSeeOther, "/make-reservation", 1},
+		{"no rooms", "", http.StatusSeeOther, "/search-availability", 0},
+		{"invalid room", "room_ids=abc", http.StatusBadRequest, "", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/choose-rooms", strings.NewReader(e.body))
+		ctx := getCTX(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostChooseRooms)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("PostChooseRooms handler returned wrong response code for %s: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("PostChooseRooms handler redirected to %s for %s, wanted %s", rr.Header().Get("Location"), e.name, e.expectedLocation)
+		}
+		if e.expectedRooms > 0 {
+			res := session.Get(ctx, "reservation").(models.Reservation)
+			if len(res.Rooms) != e.expectedRooms {
+				t.Errorf("PostChooseRooms handler for %s: expected %d rooms but got %d", e.name, e.expectedRooms, len(res.Rooms))
+			}
+			if holds, _ := session.Get(ctx, "hold_ids").([]int); len(holds) != e.expectedRooms {
+				t.Errorf("PostChooseRooms handler for %s: expected %d holds but got %d", e.name, e.expectedRooms, len(holds))
+			}
+		}
+	}
+}
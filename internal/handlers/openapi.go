@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/openapi"
+	"github.com/taldrori/bookings/internal/render"
+)
+
+// apiSpec describes the JSON endpoints. The tests check the responses of the handlers
+// against it.
+var apiSpec = newAPISpec()
+
+// newAPISpec describes the JSON endpoints, from the types their handlers encode
+func newAPISpec() *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title: "Bookings API",
+		Description: "The JSON API of the bookings site. Dates are days written yyyy-mm-dd, and prices are in cents. " +
+			"Reservations and restrictions need the bearer token of a staff member, made on the API Tokens admin page.",
+		Version: "1",
+	})
+
+	d.Components.SecuritySchemes["staffToken"] = openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "The token of a staff member, like bk_3f9c…",
+	}
+	staff := []map[string][]string{{"staffToken": {}}}
+
+	apiError := d.Define("Error", helpers.ErrorResponse{})
+	room := d.Define("Room", apiRoom{})
+	availability := d.Define("Availability", apiAvailability{})
+	month := d.Define("MonthAvailability", apiMonth{})
+	reservation := d.Define("Reservation", apiReservation{})
+	newReservation := d.Define("NewReservation", apiNewReservation{})
+	restriction := d.Define("Restriction", apiRestriction{})
+	roomAvailability := d.Define("RoomAvailability", jsonResponse{})
+
+	errorResponse := func(description string) *openapi.Response {
+		return openapi.JSONBody(description, apiError)
+	}
+	serverError := errorResponse("Something went wrong on the server")
+	unauthorized := errorResponse("The bearer token is missing or not valid")
+
+	date := func(name, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Required: true, Description: description, Schema: &openapi.Schema{Type: "string", Format: "date"}}
+	}
+	code := openapi.Parameter{Name: "code", In: "path", Required: true, Description: "The confirmation code of the reservation", Schema: &openapi.Schema{Type: "string"}}
+	one := 1.0
+
+	d.Add("GET", "/api/v1/rooms", &openapi.Operation{
+		OperationID: "listRooms",
+		Summary:     "Lists the rooms",
+		Tags:        []string{"Rooms"},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The rooms", openapi.ObjectOf(map[string]*openapi.Schema{"rooms": openapi.ArrayOf(room)})),
+			"default": serverError,
+		},
+	})
+
+	d.Add("GET", "/api/v1/availability", &openapi.Operation{
+		OperationID: "searchAvailability",
+		Summary:     "Tells which rooms are free for a stay",
+		Description: "Lists the rooms free for the stay with its price, and the rooms that aren't with the reason. " +
+			"With room_id, tells about that room only.",
+		Tags: []string{"Rooms"},
+		Parameters: []openapi.Parameter{
+			date("start_date", "The day the guest arrives"),
+			date("end_date", "The day the guest leaves"),
+			{Name: "room_id", In: "query", Description: "The room to ask about", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "guests", In: "query", Description: "How many guests the rooms must sleep, when room_id isn't given", Schema: &openapi.Schema{Type: "integer", Minimum: &one}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The availability of the rooms", availability),
+			"400":     errorResponse("The dates, room_id or guests are not valid"),
+			"404":     errorResponse("There is no room room_id"),
+			"default": serverError,
+		},
+	})
+
+	d.Add("GET", "/api/v1/availability/month", &openapi.Operation{
+		OperationID: "monthAvailability",
+		Summary:     "Tells which nights of a month the rooms are free",
+		Description: "Lists every night of the month for the rooms, or for room_id, with whether it is free, " +
+			"so that a date picker can grey out the nights that are taken.",
+		Tags: []string{"Rooms"},
+		Parameters: []openapi.Parameter{
+			{Name: "month", In: "query", Required: true, Description: "The month, like 2050-01", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "room_id", In: "query", Description: "The room to ask about", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The nights of the month, room by room", month),
+			"400":     errorResponse("The month or room_id is not valid"),
+			"404":     errorResponse("There is no room room_id"),
+			"default": serverError,
+		},
+	})
+
+	d.Add("POST", "/api/v1/reservations", &openapi.Operation{
+		OperationID: "createReservation",
+		Summary:     "Books rooms for a guest",
+		Description: "The guest is sent the confirmation email, and the owner is told.",
+		Tags:        []string{"Reservations"},
+		Security:    staff,
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content:  map[string]openapi.MediaType{"application/json": {Schema: newReservation}},
+		},
+		Responses: map[string]*openapi.Response{
+			"201": {
+				Description: "The reservation was made",
+				Headers:     map[string]openapi.Header{"Location": {Description: "The address of the reservation", Schema: &openapi.Schema{Type: "string"}}},
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: reservation}},
+			},
+			"400":     errorResponse("The body is not a JSON reservation"),
+			"401":     unauthorized,
+			"409":     errorResponse("A room is already taken for some of the dates"),
+			"422":     errorResponse("A field is not valid, or a stay rule forbids the stay"),
+			"default": serverError,
+		},
+	})
+
+	d.Add("GET", "/api/v1/reservations/{code}", &openapi.Operation{
+		OperationID: "getReservation",
+		Summary:     "Returns a reservation",
+		Tags:        []string{"Reservations"},
+		Security:    staff,
+		Parameters:  []openapi.Parameter{code},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The reservation", reservation),
+			"401":     unauthorized,
+			"404":     errorResponse("No reservation has this confirmation code"),
+			"default": serverError,
+		},
+	})
+
+	d.Add("POST", "/api/v1/reservations/{code}/cancel", &openapi.Operation{
+		OperationID: "cancelReservation",
+		Summary:     "Cancels a reservation",
+		Description: "The guest and the owner are told. Cancelling a cancelled reservation changes nothing.",
+		Tags:        []string{"Reservations"},
+		Security:    staff,
+		Parameters:  []openapi.Parameter{code},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The cancelled reservation", reservation),
+			"401":     unauthorized,
+			"404":     errorResponse("No reservation has this confirmation code"),
+			"default": serverError,
+		},
+	})
+
+	d.Add("GET", "/api/v1/restrictions", &openapi.Operation{
+		OperationID: "listRestrictions",
+		Summary:     "Lists what keeps the rooms from being booked",
+		Description: "Lists the reservations, blocks and holds of the rooms, or of room_id, between two days.",
+		Tags:        []string{"Restrictions"},
+		Security:    staff,
+		Parameters: []openapi.Parameter{
+			date("start_date", "The first day"),
+			date("end_date", "The day after the last"),
+			{Name: "room_id", In: "query", Description: "The room to list the restrictions of", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("The restrictions, by start date", openapi.ObjectOf(map[string]*openapi.Schema{"restrictions": openapi.ArrayOf(restriction)})),
+			"400":     errorResponse("The dates or room_id are not valid"),
+			"401":     unauthorized,
+			"default": serverError,
+		},
+	})
+
+	d.Add("POST", "/search-availability-json", &openapi.Operation{
+		OperationID: "checkRoomAvailability",
+		Summary:     "Tells whether a room is free for a stay",
+		Description: "What the room pages ask. Being a form, it needs the CSRF token of the page and its cookie.",
+		Tags:        []string{"Site"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]openapi.MediaType{"application/x-www-form-urlencoded": {Schema: openapi.ObjectOf(map[string]*openapi.Schema{
+				"start":      {Type: "string", Description: "The day the guest arrives, like 01/31/2050"},
+				"end":        {Type: "string", Description: "The day the guest leaves, like 02/02/2050"},
+				"room_id":    {Type: "integer"},
+				"csrf_token": {Type: "string"},
+			})}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200":     openapi.JSONBody("Whether the room is free", roomAvailability),
+			"400":     errorResponse("The dates or room_id are not valid"),
+			"default": serverError,
+		},
+	})
+
+	return d
+}
+
+// OpenAPI serves the OpenAPI document of the JSON endpoints
+func (m *Repository) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	out, err := apiSpec.JSON()
+	if err != nil {
+		helpers.JSONServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// APIDocs shows the OpenAPI document, where the endpoints can be tried
+func (m *Repository) APIDocs(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "api-docs.page.tmpl", &models.TemplateData{})
+}
@@ -17,18 +17,26 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/mailer"
 	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/pricing"
 	"github.com/taldrori/bookings/internal/render"
 )
 
 var app config.Appconfig
 var session *scs.SessionManager
+
+// sentMail keeps the emails the handlers send
+var sentMail = &mailer.Memory{}
 var pathToTemplates = "./../../templates"
+var pathToEmailTemplates = "./../../email-templates"
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatPrice": pricing.FormatAmount,
 }
 
 func TestMain(m *testing.M) {
@@ -38,6 +46,7 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]int{})
 
 	//change to true in production
 	app.InProduction = false
@@ -55,12 +64,7 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
-
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
+	app.HoldDuration = 15 * time.Minute
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -68,23 +72,23 @@ func TestMain(m *testing.M) {
 	}
 
 	app.TemplateCache = tc
+
+	etc, err := CreateTestEmailTemplateCache()
+	if err != nil {
+		log.Fatal("Cannot create email template cache")
+	}
+
+	app.EmailTemplateCache = etc
 	app.UseChache = true
 
-	repo := NewTestRepo(&app)
+	repo := NewTestRepo(&app, sentMail)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 
@@ -95,8 +99,9 @@ func getRoutes() http.Handler {
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
 	mux.Get("/contact", Repo.Contact)
-	mux.Get("/jonins-quarters", Repo.Jonins)
-	mux.Get("/hokages-suite", Repo.Hokages)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendarFeed)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -106,6 +111,8 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/manage-booking", Repo.ManageBooking)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -163,3 +170,30 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	return myCache, nil
 }
+
+// CreateTestEmailTemplateCache creates the email template cache as a map
+func CreateTestEmailTemplateCache() (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	emails, err := filepath.Glob(fmt.Sprintf("%s/*.email.tmpl", pathToEmailTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, email := range emails {
+		name := filepath.Base(email)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(email)
+		if err != nil {
+			return myCache, err
+		}
+
+		ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.tmpl", pathToEmailTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = ts
+	}
+
+	return myCache, nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/taldrori/bookings/internal/forms"
+	"github.com/taldrori/bookings/internal/helpers"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/render"
+	"github.com/taldrori/bookings/internal/webhooks"
+)
+
+// webhookLogSize is how many deliveries the delivery log shows
+const webhookLogSize = 100
+
+// webhookEvents returns event, about data, for the change it tells about to queue for the
+// webhooks in its own transaction
+func webhookEvents(event string, data interface{}) ([]models.WebhookEvent, error) {
+	payload, err := webhooks.Payload(event, data)
+	if err != nil {
+		return nil, err
+	}
+	return []models.WebhookEvent{{Type: event, Payload: payload}}, nil
+}
+
+// reservationEvents returns event about res, shown as the API shows it
+func reservationEvents(event string, res models.Reservation) ([]models.WebhookEvent, error) {
+	return webhookEvents(event, map[string]interface{}{"reservation": apiReservationOf(res)})
+}
+
+// blockEvents returns event about an owner block, shown as the API shows it
+func blockEvents(event string, block models.RoomRestriction) ([]models.WebhookEvent, error) {
+	// a block that was just made hasn't been read back with its restriction
+	if block.RestrictionID == 0 {
+		block.RestrictionID = models.RestrictionOwnerBlock
+	}
+	return webhookEvents(event, map[string]interface{}{"block": apiRestrictionOf(block)})
+}
+
+// createdReservationEvents tells the webhooks about a new reservation. They are queued in the
+// transaction that makes it.
+func createdReservationEvents(res models.Reservation) ([]models.WebhookEvent, error) {
+	return reservationEvents(models.EventReservationCreated, res)
+}
+
+// addedBlockEvents tells the webhooks about a new owner block. They are queued in the
+// transaction that makes it.
+func addedBlockEvents(block models.RoomRestriction) ([]models.WebhookEvent, error) {
+	return blockEvents(models.EventBlockAdded, block)
+}
+
+// AdminWebhooks lists the webhooks, with the form for adding one
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	m.renderWebhooks(w, r, forms.New(nil))
+}
+
+// AdminPostWebhook adds a webhook. Its secret is shown once, for the receiver to check the
+// signatures with.
+func (m *Repository) AdminPostWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	r.PostForm.Set("url", strings.TrimSpace(r.Form.Get("url")))
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	if form.Has("url") {
+		form.IsURL("url")
+	}
+
+	var events []string
+	for _, x := range models.WebhookEvents {
+		for _, y := range r.PostForm["events"] {
+			if x == y {
+				events = append(events, x)
+			}
+		}
+	}
+	if len(events) == 0 {
+		form.Errors.Add("events", "Choose at least one event")
+	}
+
+	if !form.Valid() {
+		m.renderWebhooks(w, r, form)
+		return
+	}
+
+	_, secret, err := m.DB.InsertWebhook(r.Context(), models.Webhook{
+		URL:    r.PostForm.Get("url"),
+		Events: events,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "webhook_secret", secret)
+	m.App.Session.Put(r.Context(), "flash", "Webhook added; copy its secret now, it won't be shown again")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminDeleteWebhook removes a webhook, with its deliveries
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteWebhook(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook removed")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminWebhookDeliveries shows the latest deliveries, with how each went
+func (m *Repository) AdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := m.DB.RecentWebhookDeliveries(r.Context(), webhookLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["deliveries"] = deliveries
+
+	render.Template(w, r, "admin-webhook-deliveries.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendWebhookDelivery makes a delivery again, with a fresh set of attempts
+func (m *Repository) AdminResendWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.ResendWebhookDelivery(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Delivery queued again")
+	http.Redirect(w, r, "/admin/webhooks/deliveries", http.StatusSeeOther)
+}
+
+// renderWebhooks renders the admin webhooks page
+func (m *Repository) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	hooks, err := m.DB.AllWebhooks(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the events ticked in the form, to tick them again when it is shown with errors
+	ticked := make(map[string]bool)
+	for _, x := range form.Values["events"] {
+		ticked[x] = true
+	}
+
+	data := make(map[string]interface{})
+	data["webhooks"] = hooks
+	data["events"] = models.WebhookEvents
+	data["ticked"] = ticked
+
+	stringMap := make(map[string]string)
+	stringMap["new_secret"] = m.App.Session.PopString(r.Context(), "webhook_secret")
+
+	render.Template(w, r, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -1,9 +1,12 @@
 package helpers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/taldrori/bookings/internal/config"
 )
@@ -19,12 +22,57 @@ func ClientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
 
+// ServerError logs err and responds with a 500. Queries cancelled because the client went away
+// and queries that timed out are logged without a stack trace, so they stand apart from bugs.
 func ServerError(w http.ResponseWriter, err error) {
+	logServerError(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func logServerError(err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		app.InfoLog.Println("Request cancelled:", err)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		app.ErrorLog.Println("Query timed out:", err)
+		return
+	}
+
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
 	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 func IsAuthenticated(r *http.Request) bool {
 	return app.Session.Exists(r.Context(), "user_id")
 }
+
+// BearerToken returns the token of the Authorization header of r, or "" when it has none
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
+
+// Slugify turns a room name into a url friendly slug, e.g. "Jonin's Quarters" -> "jonins-quarters"
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c == '\'' || c == '`':
+			continue
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/taldrori/bookings/internal/forms"
+)
+
+// The codes of the JSON errors, for programs to tell them apart without reading the message
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeNotFound         = "not_found"
+	CodeRoomUnavailable  = "room_unavailable"
+	CodeStayRuleViolated = "stay_rule_violated"
+	CodeInternal         = "internal_error"
+)
+
+// ErrorResponse is the body of every JSON error
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// Error says what went wrong: Code for programs, Message for people, and Fields the problems
+// of each field of the request, when it had some
+type Error struct {
+	Code    string              `json:"code" enum:"invalid_request,validation_failed,unauthorized,not_found,room_unavailable,stay_rule_violated,internal_error"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty" doc:"The problems of each field, by field name"`
+}
+
+// WriteJSON responds with v encoded as JSON
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		JSONServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// JSONError responds with a JSON error of code
+func JSONError(w http.ResponseWriter, status int, code, message string) {
+	if status < http.StatusInternalServerError {
+		app.InfoLog.Println("Client error with status of", status)
+	}
+	WriteJSON(w, status, ErrorResponse{Error: Error{Code: code, Message: message}})
+}
+
+// JSONFormError responds with a JSON error of code that has the errors of form, field by field
+func JSONFormError(w http.ResponseWriter, status int, code string, form *forms.Form) {
+	app.InfoLog.Println("Client error with status of", status)
+
+	fields := make(map[string][]string, len(form.Errors))
+	for field, msgs := range form.Errors {
+		fields[field] = msgs
+	}
+
+	WriteJSON(w, status, ErrorResponse{Error: Error{
+		Code:    code,
+		Message: "Some fields are not valid",
+		Fields:  fields,
+	}})
+}
+
+// JSONServerError logs err the way ServerError does, and responds with a JSON 500
+func JSONServerError(w http.ResponseWriter, err error) {
+	logServerError(err)
+
+	out, _ := json.MarshalIndent(ErrorResponse{Error: Error{
+		Code:    CodeInternal,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}}, "", "    ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(out)
+}
@@ -0,0 +1,372 @@
+// Package ical writes iCalendar (RFC 5545) calendars of all-day events, which calendar apps
+// add as they are or, by UID and sequence, use to update an event they already have. It also
+// reads the dates of the events of the calendars other booking sites publish.
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The methods of a calendar sent by email (RFC 5546). A request adds an event or updates it,
+// a cancel removes it.
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// The states of an event
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// ContentType is the MIME type of a calendar
+const ContentType = "text/calendar"
+
+// ProdID names the product that made the calendars
+const ProdID = "-//Leaf Village//Bookings//EN"
+
+// Address is someone taking part in an event
+type Address struct {
+	Name  string
+	Email string
+}
+
+// Event is an all-day event from the day of Start to the day before End. A calendar app knows
+// the event by its UID, and takes a version with a higher Sequence as an update.
+type Event struct {
+	UID         string
+	Sequence    int
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Location    string
+	Description string
+	Status      string
+	Organizer   Address
+	Attendees   []Address
+	// Recurring is set, by Parse, on an event that repeats by an RRULE or RDATE. Parse doesn't
+	// expand the rule, so only the first occurrence is read.
+	Recurring bool
+}
+
+// Calendar is a set of events, sent with a method when it goes out by email. Name is shown by
+// the calendar apps that subscribe to it.
+type Calendar struct {
+	Method string
+	Name   string
+	Events []Event
+}
+
+// Bytes encodes the calendar, with lines ended by CRLF and folded at 75 octets
+func (c Calendar) Bytes() []byte {
+	var b bytes.Buffer
+
+	line := func(s string) {
+		writeFolded(&b, s)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:" + ProdID)
+	line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		line("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		line("X-WR-CALNAME:" + Escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+
+		line("BEGIN:VEVENT")
+		line("UID:" + Escape(e.UID))
+		line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		line("DTSTAMP:" + stamp.UTC().Format("20060102T150405Z"))
+		line("DTSTART;VALUE=DATE:" + e.Start.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + e.End.Format("20060102"))
+		if e.Summary != "" {
+			line("SUMMARY:" + Escape(e.Summary))
+		}
+		if e.Location != "" {
+			line("LOCATION:" + Escape(e.Location))
+		}
+		if e.Description != "" {
+			line("DESCRIPTION:" + Escape(e.Description))
+		}
+		if e.Organizer.Email != "" {
+			line("ORGANIZER" + commonName(e.Organizer.Name) + ":mailto:" + e.Organizer.Email)
+		}
+		for _, x := range e.Attendees {
+			line("ATTENDEE" + commonName(x.Name) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:" + x.Email)
+		}
+		if e.Status != "" {
+			line("STATUS:" + e.Status)
+		}
+		line("TRANSP:OPAQUE")
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	return b.Bytes()
+}
+
+// Escape escapes the characters that have a meaning in a text value
+func Escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(s)
+}
+
+// Unescape turns a text value back into the text it was escaped from
+func Unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
+
+// ErrNotCalendar is returned by Parse for a file that isn't an iCalendar file, like the login
+// page of a booking site that has let the address of a calendar expire
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Parse reads the events of a calendar. The days of events with a time are the days they
+// start and end on in their own time zone, and an event that starts and ends on the same day
+// takes that day. Lines Parse doesn't know are skipped, as are the components inside an event,
+// like its alarms. Recurrence rules aren't expanded: a recurring event is read as its first
+// occurrence and marked as Recurring.
+func Parse(r io.Reader) (Calendar, error) {
+	var c Calendar
+
+	lines, err := unfold(r)
+	if err != nil {
+		return c, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return c, ErrNotCalendar
+	}
+
+	var e *Event
+	var duration string
+	// nested is how deep in the components inside the event, like a VALARM, the line is; their
+	// properties, such as the DURATION of an alarm, aren't the event's
+	nested := 0
+	for _, x := range lines {
+		name, params, value := property(x)
+
+		switch {
+		case name == "BEGIN" && e != nil:
+			nested++
+		case name == "END" && nested > 0:
+			nested--
+		case nested > 0:
+			continue
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = &Event{}
+			duration = ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && e != nil:
+			if e.Start.IsZero() {
+				return c, fmt.Errorf("event %q has no start", e.UID)
+			}
+			if e.End.IsZero() && duration != "" {
+				e.End, err = addDuration(e.Start, duration)
+				if err != nil {
+					return c, fmt.Errorf("event %q: %w", e.UID, err)
+				}
+			}
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			c.Events = append(c.Events, *e)
+			e = nil
+		case e == nil:
+			switch name {
+			case "METHOD":
+				c.Method = strings.ToUpper(value)
+			case "X-WR-CALNAME":
+				c.Name = Unescape(value)
+			}
+		case name == "UID":
+			e.UID = value
+		case name == "SEQUENCE":
+			e.Sequence, _ = strconv.Atoi(value)
+		case name == "DTSTART", name == "DTEND":
+			day, err := parseDay(value, params)
+			if err != nil {
+				return c, fmt.Errorf("event %q: %s: %w", e.UID, name, err)
+			}
+			if name == "DTSTART" {
+				e.Start = day
+			} else {
+				e.End = day
+			}
+		case name == "DURATION":
+			duration = value
+		case name == "SUMMARY":
+			e.Summary = Unescape(value)
+		case name == "LOCATION":
+			e.Location = Unescape(value)
+		case name == "DESCRIPTION":
+			e.Description = Unescape(value)
+		case name == "STATUS":
+			e.Status = strings.ToUpper(value)
+		case name == "RRULE", name == "RDATE":
+			e.Recurring = true
+		}
+	}
+
+	return c, nil
+}
+
+// unfold reads the content lines of a calendar, joining the folded ones. Lines ended with LF
+// alone are taken too.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		x := strings.TrimRight(s.Text(), "\r")
+		if x == "" {
+			continue
+		}
+		if (x[0] == ' ' || x[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += x[1:]
+			continue
+		}
+		lines = append(lines, x)
+	}
+
+	// a byte order mark isn't part of the first line
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\uFEFF")
+	}
+
+	return lines, s.Err()
+}
+
+// property splits a content line into its upper case name, its parameters and its value
+func property(line string) (string, map[string]string, string) {
+	params := map[string]string{}
+
+	// the value starts at the first colon that isn't in a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, ch := range line {
+		if ch == '"' {
+			quoted = !quoted
+		} else if ch == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", params, ""
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	for _, x := range parts[1:] {
+		kv := strings.SplitN(x, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+// parseDay returns the day of a DATE or DATE-TIME value, at midnight UTC
+func parseDay(value string, params map[string]string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if strings.EqualFold(params["VALUE"], "DATE") || len(value) == 8 {
+		return time.Parse("20060102", value)
+	}
+
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// addDuration adds a DURATION value, like P3D or P1W, to the day start. A part of a day counts
+// as a day.
+func addDuration(start time.Time, value string) (time.Time, error) {
+	days := 0
+	partDay := false
+	number := ""
+	inTime := false
+
+	for _, ch := range strings.TrimPrefix(strings.ToUpper(value), "+") {
+		switch {
+		case ch >= '0' && ch <= '9':
+			number += string(ch)
+			continue
+		case ch == 'P':
+		case ch == 'T':
+			inTime = true
+		case ch == 'W', ch == 'D', ch == 'H', ch == 'M' && inTime, ch == 'S':
+			n, err := strconv.Atoi(number)
+			if err != nil {
+				return start, fmt.Errorf("invalid duration %q", value)
+			}
+			switch ch {
+			case 'W':
+				days += 7 * n
+			case 'D':
+				days += n
+			default:
+				partDay = partDay || n > 0
+			}
+		default:
+			return start, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+	}
+
+	if partDay {
+		days++
+	}
+
+	return start.AddDate(0, 0, days), nil
+}
+
+// commonName is the CN parameter of name, quoted as it may hold a separator
+func commonName(name string) string {
+	name = strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(name)
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
+
+// writeFolded writes a content line, folding it so that no line is longer than 75 octets
+// without splitting a UTF-8 character
+func writeFolded(b *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// the space that starts a continuation line counts towards its length
+		limit = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
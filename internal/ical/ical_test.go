@@ -0,0 +1,221 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarBytes(t *testing.T) {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := Calendar{
+		Method: MethodRequest,
+		Events: []Event{{
+			UID:         "reservation-7@LeafVillage.com",
+			Sequence:    2,
+			Stamp:       time.Date(2049, 12, 1, 10, 30, 0, 0, time.UTC),
+			Start:       start,
+			End:         start.AddDate(0, 0, 3),
+			Summary:     "Stay at Jonin's Quarters, Leaf Village",
+			Description: "Confirmation code: LV-ABC-123\nSee you soon; bring a towel",
+			Status:      StatusConfirmed,
+			Organizer:   Address{Name: "Leaf Village", Email: "info@LeafVillage.com"},
+			Attendees:   []Address{{Name: `"Tal" Drori`, Email: "tal@drori.com"}},
+		}},
+	}
+
+	// long lines are folded, with a space starting each continuation
+	s := strings.ReplaceAll(string(c.Bytes()), "\r\n ", "")
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Leaf Village//Bookings//EN\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:reservation-7@LeafVillage.com\r\n",
+		"SEQUENCE:2\r\n",
+		"DTSTAMP:20491201T103000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500104\r\n",
+		`SUMMARY:Stay at Jonin's Quarters\, Leaf Village` + "\r\n",
+		`DESCRIPTION:Confirmation code: LV-ABC-123\nSee you soon\; bring a towel` + "\r\n",
+		`ORGANIZER;CN="Leaf Village":mailto:info@LeafVillage.com` + "\r\n",
+		`ATTENDEE;CN="Tal Drori";ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:tal@drori.com` + "\r\n",
+		"STATUS:CONFIRMED\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected the calendar to contain %q, got:\n%s", want, s)
+		}
+	}
+
+	if strings.Contains(strings.ReplaceAll(s, "\r\n", ""), "\n") {
+		t.Error("expected every line to end with CRLF")
+	}
+}
+
+func TestFolding(t *testing.T) {
+	long := strings.Repeat("é", 100)
+	c := Calendar{Events: []Event{{UID: "1", Summary: long}}}
+
+	var unfolded string
+	for _, line := range strings.Split(strings.TrimSuffix(string(c.Bytes()), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("expected lines of at most 75 octets, got %d: %q", len(line), line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded += line[1:]
+		} else {
+			unfolded += "\n" + line
+		}
+	}
+
+	if !strings.Contains(unfolded, "\nSUMMARY:"+long+"\n") {
+		t.Errorf("expected the summary to unfold to its value, got %q", unfolded)
+	}
+}
+
+func TestParse(t *testing.T) {
+	// a feed like the ones of booking sites, with LF line ends and a folded summary
+	feed := "BEGIN:VCALENDAR\n" +
+		"VERSION:2.0\n" +
+		"PRODID:-//Other Site//EN\n" +
+		"X-WR-CALNAME:Jonin's Quarters\\, upstairs\n" +
+		"BEGIN:VEVENT\n" +
+		"DTSTAMP:20491201T103000Z\n" +
+		"DTSTART;VALUE=DATE:20500101\n" +
+		"DTEND;VALUE=DATE:20500104\n" +
+		"UID:abc-1@other.site\n" +
+		`SUMMARY:Reserved\; guest` + "\n" +
+		"  from far away\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\n" +
+		"DTSTART;TZID=\"Asia/Tokyo\":20500110T150000\n" +
+		"DTEND;TZID=\"Asia/Tokyo\":20500112T110000\n" +
+		"UID:abc-2@other.site\n" +
+		"SEQUENCE:3\n" +
+		"STATUS:cancelled\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\n" +
+		"DTSTART:20500120T120000Z\n" +
+		"DURATION:P1DT2H\n" +
+		"UID:abc-3@other.site\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\n" +
+		"DTSTART;VALUE=DATE:20500201\n" +
+		"UID:abc-4@other.site\n" +
+		"END:VEVENT\n" +
+		"END:VCALENDAR\n"
+
+	c, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Name != "Jonin's Quarters, upstairs" {
+		t.Errorf("expected the calendar name to be unescaped, got %q", c.Name)
+	}
+
+	day := func(m time.Month, d int) time.Time { return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC) }
+	var tests = []struct {
+		uid      string
+		start    time.Time
+		end      time.Time
+		sequence int
+		status   string
+		summary  string
+	}{
+		{"abc-1@other.site", day(1, 1), day(1, 4), 0, "", "Reserved; guest from far away"},
+		{"abc-2@other.site", day(1, 10), day(1, 12), 3, StatusCancelled, ""},
+		{"abc-3@other.site", day(1, 20), day(1, 22), 0, "", ""},
+		{"abc-4@other.site", day(2, 1), day(2, 2), 0, "", ""},
+	}
+
+	if len(c.Events) != len(tests) {
+		t.Fatalf("expected %d events, got %d", len(tests), len(c.Events))
+	}
+	for i, e := range tests {
+		got := c.Events[i]
+		if got.UID != e.uid || !got.Start.Equal(e.start) || !got.End.Equal(e.end) || got.Sequence != e.sequence ||
+			got.Status != e.status || got.Summary != e.summary {
+			t.Errorf("event %d: expected %s from %s to %s, got %+v", i, e.uid, e.start, e.end, got)
+		}
+	}
+}
+
+func TestParseNestedComponents(t *testing.T) {
+	// the alarm has a summary, a description and a duration of its own
+	feed := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:abc-1@other.site\r\n" +
+		"DTSTART;VALUE=DATE:20500101\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"ACTION:DISPLAY\r\n" +
+		"SUMMARY:Check in soon\r\n" +
+		"DESCRIPTION:Reminder\r\n" +
+		"TRIGGER:-PT15M\r\n" +
+		"DURATION:PT15M\r\n" +
+		"REPEAT:2\r\n" +
+		"END:VALARM\r\n" +
+		"DURATION:P3D\r\n" +
+		"SUMMARY:Reserved\r\n" +
+		"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	c, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(c.Events))
+	}
+
+	e := c.Events[0]
+	if !e.End.Equal(time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the event to last its own 3 days, got until %s", e.End)
+	}
+	if e.Summary != "Reserved" || e.Description != "" {
+		t.Errorf("expected the alarm's summary and description to be skipped, got %q and %q", e.Summary, e.Description)
+	}
+	if !e.Recurring {
+		t.Error("expected the event to be marked as recurring")
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := Calendar{Method: MethodPublish, Name: "Room 1", Events: []Event{
+		{UID: "restriction-1@LeafVillage.com", Start: start, End: start.AddDate(0, 0, 2), Summary: strings.Repeat("Booked, ", 20)},
+	}}
+
+	parsed, err := Parse(bytes.NewReader(c.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method != MethodPublish || parsed.Name != "Room 1" || len(parsed.Events) != 1 {
+		t.Fatalf("unexpected calendar %+v", parsed)
+	}
+	e := parsed.Events[0]
+	if e.UID != c.Events[0].UID || !e.Start.Equal(start) || !e.End.Equal(c.Events[0].End) || e.Summary != c.Events[0].Summary {
+		t.Errorf("expected %+v, got %+v", c.Events[0], e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		feed string
+	}{
+		{"a web page", "<!DOCTYPE html><html><body>Please log in</body></html>"},
+		{"empty", ""},
+		{"event without a start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"invalid date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:2050\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		_, err := Parse(strings.NewReader(e.feed))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package mailer sends emails through the transport chosen in the settings: an SMTP server, a
+// maildir on disk, or the log
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg models.MailData) error
+}
+
+// New returns the mailer of the transport chosen in c. The smtp transport sends through s, and
+// the log transport writes to logger.
+func New(c config.Mail, s config.SMTP, logger *log.Logger) (Mailer, error) {
+	switch c.Transport {
+	case "smtp":
+		return NewSMTP(s)
+	case "file":
+		if c.Dir == "" {
+			return nil, fmt.Errorf("the file mail transport needs a directory, e.g. -maildir")
+		}
+		return &File{Dir: c.Dir}, nil
+	case "log":
+		return &Log{Logger: logger}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q, expected smtp, file or log", c.Transport)
+	}
+}
+
+// SMTP sends emails through a mail server, authenticating when a user or password is set
+type SMTP struct {
+	server *mail.SMTPServer
+}
+
+// NewSMTP returns a mailer that sends through the server of s
+func NewSMTP(s config.SMTP) (*SMTP, error) {
+	server := mail.NewSMTPClient()
+	server.Host = s.Host
+	server.Port = s.Port
+	server.Username = s.User
+	server.Password = s.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	switch s.Encryption {
+	case "", "none":
+		server.Encryption = mail.EncryptionNone
+	case "starttls":
+		server.Encryption = mail.EncryptionSTARTTLS
+	case "tls":
+		server.Encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q, expected none, starttls or tls", s.Encryption)
+	}
+
+	return &SMTP{server: server}, nil
+}
+
+func (m *SMTP) Send(msg models.MailData) error {
+	email, err := compose(msg)
+	if err != nil {
+		return err
+	}
+
+	client, err := m.server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
+
+// File drops emails in a maildir, where mail clients and tests can read them
+type File struct {
+	Dir string
+}
+
+// deliveries makes the names of the files File writes unique within the process
+var deliveries uint64
+
+func (m *File) Send(msg models.MailData) error {
+	email, err := compose(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err = os.MkdirAll(filepath.Join(m.Dir, sub), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a maildir message is written in tmp, then moved to new once it is complete
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(), atomic.AddUint64(&deliveries, 1),
+		strings.NewReplacer("/", "_", ":", "_").Replace(host))
+
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	err = os.WriteFile(tmp, []byte(email.GetMessage()), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
+
+// Log writes emails to a logger instead of sending them
+type Log struct {
+	Logger *log.Logger
+}
+
+func (m *Log) Send(msg models.MailData) error {
+	body := msg.Text
+	if body == "" {
+		body = msg.Content
+	}
+
+	m.Logger.Printf("Email from %s to %s: %s\n%s\n", msg.From, msg.To, msg.Subject, body)
+	return nil
+}
+
+// Memory keeps the emails it is given, for tests to look at
+type Memory struct {
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+func (m *Memory) Send(msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the emails sent so far
+func (m *Memory) Sent() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.sent...)
+}
+
+// Reset forgets the emails sent so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+}
+
+// compose builds the message of an email, with its plain text part when it has one, and its
+// attachments
+func compose(msg models.MailData) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+	if msg.Text == "" {
+		email.SetBody(mail.TextHTML, msg.Content)
+	} else {
+		email.SetBody(mail.TextPlain, msg.Text)
+		email.AddAlternative(mail.TextHTML, msg.Content)
+	}
+
+	for _, x := range msg.Attachments {
+		email.Attach(&mail.File{Name: x.Name, MimeType: x.ContentType, Data: x.Data})
+	}
+
+	return email, email.Error
+}
@@ -0,0 +1,199 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/models"
+)
+
+var testMsg = models.MailData{
+	To:      "john@smith.com",
+	From:    "info@LeafVillage.com",
+	Subject: "Reservation Confirmation",
+	Content: "<p><strong>See you soon</strong></p>",
+	Text:    "See you soon, John\n",
+	Attachments: []models.Attachment{
+		{Name: "reservation.ics", ContentType: "text/calendar; method=REQUEST", Data: []byte("BEGIN:VCALENDAR\r\n")},
+	},
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name      string
+		mail      config.Mail
+		smtp      config.SMTP
+		expectErr bool
+	}{
+		{"smtp", config.Mail{Transport: "smtp"}, config.SMTP{Host: "localhost", Port: 25, Encryption: "starttls"}, false},
+		{"smtp with implicit tls", config.Mail{Transport: "smtp"}, config.SMTP{Host: "localhost", Port: 465, Encryption: "tls"}, false},
+		{"unknown encryption", config.Mail{Transport: "smtp"}, config.SMTP{Encryption: "ssl3"}, true},
+		{"file", config.Mail{Transport: "file", Dir: "mail"}, config.SMTP{}, false},
+		{"file without a directory", config.Mail{Transport: "file"}, config.SMTP{}, true},
+		{"log", config.Mail{Transport: "log"}, config.SMTP{}, false},
+		{"unknown transport", config.Mail{Transport: "pigeon"}, config.SMTP{}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.mail, e.smtp, log.New(os.Stdout, "", 0))
+		if (err != nil) != e.expectErr {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.expectErr, err)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &File{Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		err := m.Send(testMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 emails in the maildir, got %d", len(files))
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: <john@smith.com>", "Subject: Reservation Confirmation",
+		"Content-Type: multipart/alternative", "Content-Type: text/plain", "See you soon, John",
+		"Content-Type: text/html", "<strong>See you soon</strong>",
+		"Content-Type: text/calendar; method=REQUEST", `name="reservation.ics"`, "QkVHSU46VkNBTEVOREFSDQo="} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected the email to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, "tmp", "*"))
+	if len(tmp) != 0 {
+		t.Errorf("expected nothing left in tmp, got %v", tmp)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: log.New(&buf, "", 0)}
+
+	err := m.Send(testMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "to john@smith.com: Reservation Confirmation\nSee you soon, John") {
+		t.Errorf("expected the plain text part to be logged, got %q", buf.String())
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	m, err := NewSMTP(config.SMTP{Host: host, Port: portNumber, Encryption: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(testMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "Subject: Reservation Confirmation") || !strings.Contains(data, "See you soon") {
+		t.Errorf("unexpected message received by the server:\n%s", data)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+	_ = m.Send(testMsg)
+
+	sent := m.Sent()
+	if len(sent) != 1 || sent[0].To != "john@smith.com" {
+		t.Errorf("expected the email to be kept, got %+v", sent)
+	}
+
+	m.Reset()
+	if len(m.Sent()) != 0 {
+		t.Error("expected no email after Reset")
+	}
+}
+
+// fakeSMTPServer accepts one SMTP session without encryption or authentication, and sends the
+// message it receives on the returned channel
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().String(), received
+}
@@ -0,0 +1,328 @@
+// Package migrate applies SQL migrations to the database, and keeps track of the ones applied
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBehind is returned by Check when the database misses migrations the binary expects
+var ErrBehind = errors.New("database schema is behind")
+
+// versionTable lists the applied versions. It is the table soda used, so databases that were
+// migrated with it carry on from where they were.
+const versionTable = "schema_migration"
+
+// lockKey identifies the advisory lock taken while migrating
+const lockKey = 7239461850
+
+// versionLayout is the layout of migration versions, a UTC timestamp
+const versionLayout = "20060102150405"
+
+var fileName = regexp.MustCompile(`^(\d{14})_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one change to the schema, with the SQL that makes it and the SQL that undoes it
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Load reads the migrations in fsys, oldest first. Every migration needs an up file; one
+// without a down file only has its version forgotten when it is rolled back.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[string]*Migration{}
+	for _, file := range files {
+		parts := fileName.FindStringSubmatch(file)
+		if parts == nil {
+			return nil, fmt.Errorf("migration %s isn't named <version>_<name>.up.sql or <version>_<name>.down.sql", file)
+		}
+		version, name, direction := parts[1], parts[2], parts[3]
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %s is named both %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Create writes empty up and down files for a new migration called name in dir, and returns
+// their paths
+func Create(dir, name string, now time.Time) (string, string, error) {
+	name = strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}), "_"))
+	if name == "" {
+		return "", "", errors.New("migration name is empty")
+	}
+
+	base := filepath.Join(dir, now.UTC().Format(versionLayout)+"_"+name)
+	up, down := base+".up.sql", base+".down.sql"
+
+	for _, file := range []string{up, down} {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		err = f.Close()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return up, down, nil
+}
+
+// Migrator applies migrations to a Postgres database
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+
+	// lock takes a lock that keeps other migrators out until the returned function is called
+	lock func(ctx context.Context, conn *sql.Conn) (func(), error)
+}
+
+// New returns a migrator of migrations for db
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+		lock:       advisoryLock,
+	}
+}
+
+// advisoryLock takes a Postgres advisory lock on the session of conn, which waits for any
+// other migrator to finish
+func advisoryLock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	_, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return nil, fmt.Errorf("taking the migration lock: %w", err)
+	}
+
+	return func() {
+		// the lock goes with the session anyway, so a failure here is harmless
+		_, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+	}, nil
+}
+
+// Up applies the migrations that haven't been applied yet, oldest first, and returns them.
+// Each migration runs in its own transaction, so a failing one leaves the earlier ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, x := range m.Migrations {
+			if applied[x.Version] {
+				continue
+			}
+
+			err = run(ctx, conn, x.Up, `insert into `+versionTable+` (version) values ($1)`, x.Version)
+			if err != nil {
+				return fmt.Errorf("applying migration %s_%s: %w", x.Version, x.Name, err)
+			}
+			done = append(done, x)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the latest steps applied migrations, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			x := m.Migrations[i]
+			if !applied[x.Version] {
+				continue
+			}
+
+			err = run(ctx, conn, x.Down, `delete from `+versionTable+` where version = $1`, x.Version)
+			if err != nil {
+				return fmt.Errorf("rolling back migration %s_%s: %w", x.Version, x.Name, err)
+			}
+			done = append(done, x)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists every migration, oldest first, and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, x := range m.Migrations {
+			statuses = append(statuses, Status{Migration: x, Applied: applied[x.Version]})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Check returns ErrBehind when some of the migrations haven't been applied to the database. It
+// waits for a migration in progress to finish.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, x := range statuses {
+		if !x.Applied {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w: %d of %d migrations are pending, run bookings migrate up",
+			ErrBehind, pending, len(m.Migrations))
+	}
+
+	return nil
+}
+
+// locked runs fn with a connection that holds the migration lock, and creates the version
+// table if needed
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = conn.ExecContext(ctx, `create table if not exists `+versionTable+` (
+		version varchar(14) not null primary key
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// run executes the statements of a migration and records it with the version statement, in a
+// single transaction
+func run(ctx context.Context, conn *sql.Conn, statements, versionStmt, version string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(statements) != "" {
+		_, err = tx.ExecContext(ctx, statements)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, versionStmt, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedVersions reads the version table
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	applied := map[string]bool{}
+
+	rows, err := conn.QueryContext(ctx, `select version from `+versionTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version string
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,204 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/taldrori/bookings/migrations"
+)
+
+var testMigrations = fstest.MapFS{
+	"20260101000000_create_guests.up.sql":   {Data: []byte("create table guests (id integer primary key, name text);")},
+	"20260101000000_create_guests.down.sql": {Data: []byte("drop table guests;")},
+	"20260102000000_add_email.up.sql":       {Data: []byte("alter table guests add column email text;")},
+	"20260102000000_add_email.down.sql":     {Data: []byte("alter table guests drop column email;")},
+	"20260103000000_seed_guests.up.sql":     {Data: []byte("insert into guests (name, email) values ('Kakashi', 'k@konoha');")},
+}
+
+// newTestMigrator returns a migrator of testMigrations for an empty SQLite database, which has
+// no advisory locks
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	loaded, err := Load(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m := New(db, loaded)
+	m.lock = func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		return func() {}, nil
+	}
+
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	loaded, err := Load(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 migrations, got %d", len(loaded))
+	}
+	if loaded[0].Version != "20260101000000" || loaded[0].Name != "create_guests" || loaded[0].Down == "" {
+		t.Errorf("unexpected first migration %+v", loaded[0])
+	}
+	if loaded[2].Down != "" {
+		t.Errorf("expected the last migration to have no down file")
+	}
+
+	var tests = []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"bad name", fstest.MapFS{"create_guests.up.sql": {Data: []byte("select 1;")}}},
+		{"no up file", fstest.MapFS{"20260101000000_create_guests.down.sql": {Data: []byte("select 1;")}}},
+		{"two names", fstest.MapFS{
+			"20260101000000_create_guests.up.sql":  {Data: []byte("select 1;")},
+			"20260101000000_create_hosts.down.sql": {Data: []byte("select 1;")},
+		}},
+	}
+
+	for _, e := range tests {
+		_, err := Load(e.fsys)
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) == 0 {
+		t.Fatal("expected the embedded migrations")
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	err := m.Check(ctx)
+	if !errors.Is(err, ErrBehind) {
+		t.Errorf("expected an empty database to be behind, got %v", err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 3 {
+		t.Errorf("expected 3 migrations to be applied, got %d", len(done))
+	}
+
+	err = m.Check(ctx)
+	if err != nil {
+		t.Errorf("expected the database to be up to date, got %v", err)
+	}
+
+	var email string
+	err = m.DB.QueryRow(`select email from guests`).Scan(&email)
+	if err != nil || email != "k@konoha" {
+		t.Errorf("expected the seeded guest, got %q, %v", email, err)
+	}
+
+	// running up again does nothing
+	done, err = m.Up(ctx)
+	if err != nil || len(done) != 0 {
+		t.Errorf("expected nothing to apply, got %d, %v", len(done), err)
+	}
+
+	done, err = m.Down(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 || done[0].Version != "20260103000000" || done[1].Version != "20260102000000" {
+		t.Errorf("expected the 2 latest migrations to be rolled back, got %+v", done)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := []bool{true, false, false}
+	for i, x := range statuses {
+		if x.Applied != applied[i] {
+			t.Errorf("expected migration %s applied to be %v", x.Version, applied[i])
+		}
+	}
+
+	err = m.Check(ctx)
+	if !errors.Is(err, ErrBehind) {
+		t.Errorf("expected the database to be behind after rolling back, got %v", err)
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	m.Migrations = append(m.Migrations, Migration{Version: "20260104000000", Name: "broken", Up: "create tabel oops;"})
+
+	done, err := m.Up(ctx)
+	if err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	if len(done) != 3 {
+		t.Errorf("expected the migrations before the broken one to be applied, got %d", len(done))
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[3].Applied {
+		t.Error("expected the broken migration not to be recorded")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 19, 0, 0, 0, time.UTC)
+
+	up, down, err := Create(dir, "Add Notes to rooms", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "20261018190000_add_notes_to_rooms.up.sql" ||
+		filepath.Base(down) != "20261018190000_add_notes_to_rooms.down.sql" {
+		t.Errorf("unexpected files %s and %s", up, down)
+	}
+	for _, file := range []string{up, down} {
+		if _, err := os.Stat(file); err != nil {
+			t.Error(err)
+		}
+	}
+
+	_, _, err = Create(dir, "add notes to rooms", now)
+	if err == nil {
+		t.Error("expected an existing migration not to be overwritten")
+	}
+
+	_, _, err = Create(dir, " - ", now)
+	if err == nil {
+		t.Error("expected an empty name to be refused")
+	}
+}
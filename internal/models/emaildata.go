@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// EmailData is the data of an email, which names the template the email is rendered with
+type EmailData interface {
+	EmailTemplate() string
+}
+
+// ConfirmationEmail confirms a new reservation to the guest
+type ConfirmationEmail struct {
+	Reservation Reservation
+}
+
+func (ConfirmationEmail) EmailTemplate() string { return "confirmation" }
+
+// NewReservationEmail lets the owner know that a reservation was made
+type NewReservationEmail struct {
+	Reservation Reservation
+}
+
+func (NewReservationEmail) EmailTemplate() string { return "new-reservation" }
+
+// ChangedReservationEmail lets the guest, or the owner when ForOwner is set, know that a
+// reservation moved from the old dates to its current ones
+type ChangedReservationEmail struct {
+	Reservation  Reservation
+	OldStartDate time.Time
+	OldEndDate   time.Time
+	ForOwner     bool
+}
+
+func (ChangedReservationEmail) EmailTemplate() string { return "changed-reservation" }
+
+// CancelledReservationEmail lets the guest, or the owner when ForOwner is set, know that a
+// reservation was cancelled
+type CancelledReservationEmail struct {
+	Reservation Reservation
+	ForOwner    bool
+}
+
+func (CancelledReservationEmail) EmailTemplate() string { return "cancelled-reservation" }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -16,10 +17,26 @@ type User struct {
 }
 
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID           int
+	RoomName     string
+	Slug         string
+	Image        string
+	MaxOccupancy int
+	Description  string
+	BedTypes     string
+	BaseRate     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Amenities    []Amenity
+	// CalendarToken is the secret in the address of the calendar feed of the room
+	CalendarToken string
+}
+
+type Amenity struct {
+	ID          int
+	AmenityName string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 type Restriction struct {
@@ -30,20 +47,123 @@ type Restriction struct {
 }
 
 type Reservation struct {
+	ID               int
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomID           int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Room             Room
+	Processed        int
+	Cancelled        int
+	ConfirmationCode string
+	TotalPrice       int
+	Rooms            []ReservationRoom
+	// CalendarSequence counts the updates sent to the guest's calendar since the confirmation
+	CalendarSequence int
+}
+
+// RoomNames lists the names of the rooms of the reservation
+func (r Reservation) RoomNames() string {
+	if len(r.Rooms) == 0 {
+		return r.Room.RoomName
+	}
+
+	names := make([]string, 0, len(r.Rooms))
+	for _, x := range r.Rooms {
+		names = append(names, x.Room.RoomName)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// ReservationRoom is one of the rooms booked by a reservation. A group books several rooms
+// for the same dates under a single reservation; RoomID and Room of the reservation are
+// those of its first room.
+type ReservationRoom struct {
+	ID             int
+	ReservationID  int
+	RoomID         int
+	TotalPrice     int
+	PriceBreakdown []NightPrice
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+}
+
+// StayRule limits the stays in a room that touch the dates from StartDate up to, but not
+// including, EndDate. Zero MinNights or MaxNights, and empty ArrivalWeekdays, mean no limit.
+type StayRule struct {
+	ID                int
+	RoomID            int
+	StartDate         time.Time
+	EndDate           time.Time
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	ArrivalWeekdays   []time.Weekday
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// RoomExclusion is a room left out of a search, and why
+type RoomExclusion struct {
+	Room   Room
+	Reason string
+}
+
+// SeasonalRate overrides a room's base rate for the nights from StartDate up to, but not including, EndDate
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	SeasonName  string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RateModifier raises (or, when negative, lowers) a room's nightly rate by Percent on Weekday
+type RateModifier struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
 	RoomID    int
+	Weekday   time.Weekday
+	Percent   int
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Room      Room
-	Processed int
 }
 
+// NightPrice is the price of a single night of a stay. Amounts are in cents
+type NightPrice struct {
+	Date       time.Time `json:"date"`
+	SeasonName string    `json:"season_name,omitempty"`
+	Rate       int       `json:"rate"`
+	Percent    int       `json:"percent,omitempty"`
+	Price      int       `json:"price"`
+}
+
+// Quote is the price of a stay, night by night. Amounts are in cents
+type Quote struct {
+	Nights []NightPrice
+	Total  int
+}
+
+// The restrictions that take a room for some dates, stored in RoomRestriction.RestrictionID.
+// A hold keeps a room while a guest fills in the reservation form, and an external block is
+// imported from the calendar of another booking site.
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+	RestrictionExternal    = 4
+)
+
 type RoomRestriction struct {
 	ID            int
 	StartDate     time.Time
@@ -51,17 +171,212 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	ExpiresAt     time.Time
+	Reason        string
+	Category      string
+	// FeedID is the external calendar an imported block comes from, and ExternalUID the UID
+	// of its event there
+	FeedID      int
+	ExternalUID string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// BlockCategory is a kind of owner block
+type BlockCategory struct {
+	Value string
+	Label string
+}
+
+// BlockCategories are the kinds of owner blocks, stored in RoomRestriction.Category
+var BlockCategories = []BlockCategory{
+	{Value: "maintenance", Label: "Maintenance"},
+	{Value: "owner_use", Label: "Owner use"},
+	{Value: "out_of_order", Label: "Out of order"},
+}
+
+// CategoryLabel returns the label of the category of an owner block
+func (rr RoomRestriction) CategoryLabel() string {
+	if rr.Imported() {
+		return "External"
+	}
+	for _, x := range BlockCategories {
+		if x.Value == rr.Category {
+			return x.Label
+		}
+	}
+	return "Blocked"
+}
+
+// Imported reports whether the block was imported from the calendar of another booking site
+func (rr RoomRestriction) Imported() bool {
+	return rr.RestrictionID == RestrictionExternal
+}
+
+// Hold reports whether the restriction holds the room for a guest who is still filling in the
+// reservation form
+func (rr RoomRestriction) Hold() bool {
+	return rr.RestrictionID == RestrictionHold
+}
+
+// CalendarFeed is the iCal address of the calendar of a room on another booking site, which
+// is imported as blocks
+type CalendarFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// CalendarFeedSync counts what a sync of a calendar feed did to its blocks. The events that
+// fall on dates the room is already taken for are left out and listed in Conflicts.
+type CalendarFeedSync struct {
+	Added     int
+	Moved     int
+	Removed   int
+	Conflicts []RoomRestriction
+}
+
+// APIToken lets the programs of a staff member use the API. Only a hash of its secret is
+// stored, so the secret is shown once, when the token is made.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
+// BlockSpan is an owner block as shown on the admin calendar, from its first day in the
+// month shown and for Days days
+type BlockSpan struct {
+	Block RoomRestriction
+	Days  int
+}
+
+type MailData struct {
+	To      string
+	From    string
+	Subject string
+	// Content is the HTML of the email, and Text its plain text alternative
+	Content string
+	Text    string
+	// Template is the email template the message was rendered with
+	Template    string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent with an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// The states of an outbox message. A failed message has run out of attempts and waits for an
+// admin to resend it.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email stored in the outbox until it is delivered
+type OutboxMessage struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
 }
 
-type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+// The events a webhook can subscribe to
+const (
+	EventReservationCreated   = "reservation.created"
+	EventReservationUpdated   = "reservation.updated"
+	EventReservationProcessed = "reservation.processed"
+	EventReservationCancelled = "reservation.cancelled"
+	EventReservationDeleted   = "reservation.deleted"
+	EventBlockAdded           = "block.added"
+	EventBlockUpdated         = "block.updated"
+	EventBlockRemoved         = "block.removed"
+)
+
+// WebhookEvents are all the events, in the order the admin pages list them
+var WebhookEvents = []string{
+	EventReservationCreated,
+	EventReservationUpdated,
+	EventReservationProcessed,
+	EventReservationCancelled,
+	EventReservationDeleted,
+	EventBlockAdded,
+	EventBlockUpdated,
+	EventBlockRemoved,
+}
+
+// WebhookEvent is an event to be delivered to the webhooks that subscribe to it. Payload is
+// the body they are posted.
+type WebhookEvent struct {
+	Type    string
+	Payload []byte
+}
+
+// Webhook is an address the events are posted to. Secret signs the deliveries, so that the
+// receiver can tell they come from the site.
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Subscribes reports whether the webhook wants event
+func (h Webhook) Subscribes(event string) bool {
+	for _, x := range h.Events {
+		if x == event {
+			return true
+		}
+	}
+	return false
+}
+
+// The states of a webhook delivery. A failed delivery has run out of attempts and waits for
+// an admin to resend it.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookDelivery is an event stored until it is posted to a webhook. ResponseStatus is the
+// status the webhook answered the last attempt with, or 0 when it didn't answer.
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	Event          string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	NextAttemptAt  time.Time
+	LastError      string
+	DeliveredAt    time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Webhook        Webhook
 }
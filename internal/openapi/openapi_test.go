@@ -0,0 +1,132 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type testNight struct {
+	Date  string `json:"date" format:"date"`
+	Price int    `json:"price" doc:"In cents"`
+}
+
+type testStay struct {
+	Code   string      `json:"code"`
+	Status string      `json:"status" enum:"booked,cancelled"`
+	Note   string      `json:"note,omitempty"`
+	Nights []testNight `json:"nights"`
+	Paid   bool        `json:"paid"`
+	secret string
+}
+
+func testDocument() *Document {
+	d := New(Info{Title: "Test", Version: "1"})
+	stay := d.Define("Stay", testStay{})
+	d.Add("GET", "/stays/{code}", &Operation{
+		OperationID: "getStay",
+		Summary:     "Returns a stay",
+		Responses: map[string]*Response{
+			"200": JSONBody("The stay", stay),
+			"201": {
+				Description: "Made",
+				Headers:     map[string]Header{"Location": {Schema: &Schema{Type: "string"}}},
+				Content:     map[string]MediaType{"application/json": {Schema: stay}},
+			},
+			"default": {Description: "Oops", Content: map[string]MediaType{"text/plain": {}}},
+		},
+	})
+	return d
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(testStay{})
+
+	if s.Type != "object" || strings.Join(s.Required, ",") != "code,status,nights,paid" {
+		t.Errorf("expected an object requiring all but note, got %+v", s)
+	}
+	if _, ok := s.Properties["secret"]; ok || len(s.Properties) != 5 {
+		t.Errorf("expected the exported fields only, got %v", s.Properties)
+	}
+	if s.AdditionalProperties == nil || *s.AdditionalProperties {
+		t.Error("expected no additional properties to be allowed")
+	}
+	if strings.Join(s.Properties["status"].Enum, ",") != "booked,cancelled" {
+		t.Errorf("expected the enum tag to be read, got %v", s.Properties["status"].Enum)
+	}
+
+	nights := s.Properties["nights"]
+	if nights.Type != "array" || nights.Items.Properties["date"].Format != "date" || nights.Items.Properties["price"].Type != "integer" {
+		t.Errorf("expected an array of nights, got %+v", nights.Items)
+	}
+	if nights.Items.Properties["price"].Description != "In cents" {
+		t.Errorf("expected the doc tag to be read, got %q", nights.Items.Properties["price"].Description)
+	}
+
+	out, err := testDocument().JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"$ref": "#/components/schemas/Stay"`) || !strings.Contains(string(out), `"openapi": "3.0.3"`) {
+		t.Errorf("expected the document to refer to its schemas, got %s", out)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	d := testDocument()
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		status int
+		header http.Header
+		body   string
+		valid  bool
+	}{
+		{"valid", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":[{"date":"2050-01-01","price":100}],"paid":false}`, true},
+		{"optional property", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","note":"late","nights":[],"paid":true}`, true},
+		{"missing property", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":[]}`, false},
+		{"undescribed property", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":[],"paid":true,"extra":1}`, false},
+		{"null array", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":null,"paid":true}`, false},
+		{"not in the enum", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"lost","nights":[],"paid":true}`, false},
+		{"not a date", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":[{"date":"01/01/2050","price":100}],"paid":true}`, false},
+		{"not an integer", "GET", "/stays/{code}", 200, jsonHeader, `{"code":"A","status":"booked","nights":[{"date":"2050-01-01","price":1.5}],"paid":true}`, false},
+		{"wrong type", "GET", "/stays/{code}", 200, jsonHeader, `{"code":1,"status":"booked","nights":[],"paid":true}`, false},
+		{"not json", "GET", "/stays/{code}", 200, jsonHeader, `<html>`, false},
+		{"wrong media type", "GET", "/stays/{code}", 200, http.Header{"Content-Type": {"text/html"}}, `<html>`, false},
+		{"missing header", "GET", "/stays/{code}", 201, jsonHeader, `{"code":"A","status":"booked","nights":[],"paid":true}`, false},
+		{"default response", "GET", "/stays/{code}", 500, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, "Internal Server Error", true},
+		{"undescribed method", "POST", "/stays/{code}", 200, jsonHeader, `{}`, false},
+		{"undescribed path", "GET", "/stays", 200, jsonHeader, `{}`, false},
+	}
+
+	for _, e := range tests {
+		err := d.ValidateResponse(e.method, e.path, e.status, e.header, []byte(e.body))
+		if e.valid && err != nil {
+			t.Errorf("%s: expected the response to be valid, got %v", e.name, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%s: expected the response to be invalid", e.name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d := testDocument()
+
+	var v interface{}
+	_ = json.Unmarshal([]byte(`[{"code":"A","status":"cancelled","nights":[],"paid":false}]`), &v)
+
+	err := d.Validate(ArrayOf(Ref("Stay")), v)
+	if err != nil {
+		t.Errorf("expected an array of stays to be valid, got %v", err)
+	}
+
+	err = d.Validate(ArrayOf(Ref("Room")), v)
+	if err == nil || !strings.Contains(err.Error(), "unknown schema") {
+		t.Errorf("expected an unknown reference to be reported, got %v", err)
+	}
+}
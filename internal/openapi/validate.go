@@ -0,0 +1,189 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidateResponse checks that a response of the operation of method on the path template
+// path is one the document describes: its status, its headers, its media type and, for JSON,
+// its body
+func (d *Document) ValidateResponse(method, path string, status int, header http.Header, body []byte) error {
+	op := d.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("%s %s is not described", method, path)
+	}
+
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s doesn't describe the status %d", method, path, status)
+	}
+
+	for name := range resp.Headers {
+		if header.Get(name) == "" {
+			return fmt.Errorf("%s %s answered %d without the header %s", method, path, status, name)
+		}
+	}
+
+	if len(resp.Content) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%s %s answered %d without a media type", method, path, status)
+	}
+	content, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s %s answered %d with %s, which isn't described", method, path, status, mediaType)
+	}
+	if mediaType != "application/json" || content.Schema == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	if err != nil {
+		return fmt.Errorf("%s %s answered %d with invalid JSON: %v", method, path, status, err)
+	}
+
+	err = d.Validate(content.Schema, v)
+	if err != nil {
+		return fmt.Errorf("%s %s answered %d with %v", method, path, status, err)
+	}
+	return nil
+}
+
+// Validate checks that the decoded JSON value v matches the schema s. Numbers must have been
+// decoded as json.Number or float64.
+func (d *Document) Validate(s *Schema, v interface{}) error {
+	return d.validate(s, v, "body")
+}
+
+func (d *Document) validate(s *Schema, v interface{}, at string) error {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		ref, ok := d.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s refers to the unknown schema %s", at, s.Ref)
+		}
+		return d.validate(ref, v, at)
+	}
+
+	if v == nil {
+		return fmt.Errorf("%s is null", at)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not an object", at)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s has no %s", at, name)
+			}
+		}
+
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s has the undescribed property %s", at, name)
+				}
+				continue
+			}
+			err := d.validate(p, obj[name], at+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not an array", at)
+		}
+		if s.Items == nil {
+			return nil
+		}
+		for i, x := range items {
+			err := d.validate(s.Items, x, fmt.Sprintf("%s[%d]", at, i))
+			if err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s is not a string", at)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return fmt.Errorf("%s is %q, not one of %s", at, str, strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "date" {
+			_, err := time.Parse("2006-01-02", str)
+			if err != nil {
+				return fmt.Errorf("%s is %q, not a date", at, str)
+			}
+		}
+
+	case "integer", "number":
+		var f float64
+		switch n := v.(type) {
+		case json.Number:
+			var err error
+			f, err = n.Float64()
+			if err != nil {
+				return fmt.Errorf("%s is not a number", at)
+			}
+		case float64:
+			f = n
+		default:
+			return fmt.Errorf("%s is not a number", at)
+		}
+		if s.Type == "integer" && f != math.Trunc(f) {
+			return fmt.Errorf("%s is not an integer", at)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s is less than %v", at, *s.Minimum)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s is not a boolean", at)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+// Package outbox delivers the emails queued in the outbox table, with a dispatcher of the
+// dispatch package.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/taldrori/bookings/internal/dispatch"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+)
+
+// Dispatcher delivers the due messages of the outbox
+type Dispatcher struct {
+	*dispatch.Dispatcher
+
+	DB   repository.DatabaseRepo
+	Send func(models.MailData) error
+}
+
+// New returns a dispatcher of the outbox of db with the default settings, which delivers
+// messages with send
+func New(db repository.DatabaseRepo, send func(models.MailData) error) *Dispatcher {
+	d := &Dispatcher{DB: db, Send: send}
+	d.Dispatcher = dispatch.New(d)
+	return d
+}
+
+// Claim claims the due messages of the outbox
+func (d *Dispatcher) Claim(ctx context.Context, limit int, lease time.Duration) ([]dispatch.Job, error) {
+	msgs, err := d.DB.ClaimOutboxMessages(ctx, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+
+	jobs := make([]dispatch.Job, 0, len(msgs))
+	for _, msg := range msgs {
+		jobs = append(jobs, &message{d: d, msg: msg})
+	}
+	return jobs, nil
+}
+
+// message is the job of sending an email of the outbox. A message that fails its last attempt
+// can be resent from the dashboard.
+type message struct {
+	d   *Dispatcher
+	msg models.OutboxMessage
+}
+
+func (x *message) Attempts() int {
+	return x.msg.Attempts
+}
+
+func (x *message) Do(ctx context.Context) error {
+	return x.d.Send(x.msg.Mail)
+}
+
+func (x *message) Done(ctx context.Context) error {
+	return x.d.DB.MarkOutboxMessageSent(ctx, x.msg.ID)
+}
+
+func (x *message) Retry(ctx context.Context, err error, next time.Time) error {
+	return x.d.DB.RetryOutboxMessage(ctx, x.msg.ID, err.Error(), next)
+}
+
+func (x *message) Fail(ctx context.Context, err error) error {
+	return x.d.DB.FailOutboxMessage(ctx, x.msg.ID, err.Error())
+}
+
+func (x *message) String() string {
+	return "the email to " + x.msg.Mail.To
+}
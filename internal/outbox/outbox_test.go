@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/dispatch"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/repository/dbrepo/dbtest"
+)
+
+// newTestOutbox returns a SQLite repository whose outbox holds an email to each of to
+func newTestOutbox(t *testing.T, to ...string) repository.DatabaseRepo {
+	t.Helper()
+
+	repo := dbtest.NewSQLiteRepo(t)
+
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  start,
+		EndDate:    start.AddDate(0, 0, 2),
+		TotalPrice: 10000,
+		Rooms:      []models.ReservationRoom{{RoomID: 1, TotalPrice: 10000}},
+	}
+
+	_, _, err := repo.CreateReservation(context.Background(), res, nil, func(res models.Reservation) ([]models.MailData, error) {
+		var mail []models.MailData
+		for _, x := range to {
+			mail = append(mail, models.MailData{To: x, From: "info@LeafVillage.com", Subject: "Reservation Confirmation"})
+		}
+		return mail, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	return repo
+}
+
+// failingTo returns a send function that fails for the address bad, and records the emails
+// it sent
+func failingTo(bad string, sent *[]string) func(models.MailData) error {
+	var mu sync.Mutex
+	return func(msg models.MailData) error {
+		if msg.To == bad {
+			return errors.New("mailbox unavailable")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		*sent = append(*sent, msg.To)
+		return nil
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	repo := newTestOutbox(t, "one@here.com", "bad@here.com", "two@here.com")
+
+	var sent []string
+	d := New(repo, failingTo("bad@here.com", &sent))
+	d.MaxAttempts = 3
+	// without a delay, the email to bad@here.com is sent again in the same dispatch until the
+	// third failure
+	d.BaseDelay = 0
+
+	d.Dispatch(context.Background())
+
+	if len(sent) != 2 {
+		t.Errorf("expected 2 emails to be sent, got %v", sent)
+	}
+	if stats := d.Stats(); stats != (dispatch.Stats{Delivered: 2, Retried: 2, Failed: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	undelivered, err := repo.UndeliveredOutboxMessages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undelivered) != 1 {
+		t.Fatalf("expected 1 undelivered email, got %d", len(undelivered))
+	}
+	msg := undelivered[0]
+	if msg.Mail.To != "bad@here.com" || msg.Status != models.OutboxFailed || msg.Attempts != 3 || msg.LastError != "mailbox unavailable" {
+		t.Errorf("expected the email to bad@here.com to have failed 3 times, got %+v", msg)
+	}
+}
+
+func TestDispatchBacksOff(t *testing.T) {
+	repo := newTestOutbox(t, "bad@here.com")
+
+	var sent []string
+	d := New(repo, failingTo("bad@here.com", &sent))
+
+	d.Dispatch(context.Background())
+
+	undelivered, err := repo.UndeliveredOutboxMessages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := undelivered[0]
+	if msg.Status != models.OutboxPending || msg.Attempts != 1 {
+		t.Errorf("expected the email to be pending after 1 attempt, got %+v", msg)
+	}
+	if wait := time.Until(msg.NextAttemptAt); wait < 25*time.Second || wait > 35*time.Second {
+		t.Errorf("expected the next attempt in about 30s, got %s", wait)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	repo := newTestOutbox(t, "one@here.com", "two@here.com")
+
+	var sent []string
+	d := New(repo, failingTo("", &sent))
+	d.PollInterval = time.Hour
+
+	d.Start()
+	stats, finished := d.Stop(context.Background())
+
+	if !finished {
+		t.Error("expected the dispatcher to finish")
+	}
+	if stats.Delivered != 2 || len(sent) != 2 {
+		t.Errorf("expected the 2 due emails to be sent before stopping, got %+v", stats)
+	}
+}
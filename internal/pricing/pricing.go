@@ -0,0 +1,144 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+// ErrInvalidRange is returned when a stay doesn't end after it starts
+var ErrInvalidRange = errors.New("departure must be after arrival")
+
+// Quote prices every night of a stay in room, from start up to, but not including, end.
+// A night is charged the room's base rate, or the rate of the season it falls in, adjusted
+// by the modifier for its day of the week. When seasons overlap the one that starts last wins.
+func Quote(room models.Room, seasons []models.SeasonalRate, modifiers []models.RateModifier, start, end time.Time) (models.Quote, error) {
+	var quote models.Quote
+
+	start = truncateToDay(start)
+	end = truncateToDay(end)
+
+	if !end.After(start) {
+		return quote, ErrInvalidRange
+	}
+
+	percents := make(map[time.Weekday]int)
+	for _, x := range modifiers {
+		percents[x.Weekday] += x.Percent
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightPrice{
+			Date: d,
+			Rate: room.BaseRate,
+		}
+
+		if season, ok := seasonFor(seasons, d); ok {
+			night.SeasonName = season.SeasonName
+			night.Rate = season.NightlyRate
+		}
+
+		night.Percent = percents[d.Weekday()]
+		night.Price = applyPercent(night.Rate, night.Percent)
+
+		quote.Nights = append(quote.Nights, night)
+		quote.Total += night.Price
+	}
+
+	return quote, nil
+}
+
+// seasonFor returns the season the night of d falls in, if any
+func seasonFor(seasons []models.SeasonalRate, d time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+
+	for _, x := range seasons {
+		if d.Before(truncateToDay(x.StartDate)) || !d.Before(truncateToDay(x.EndDate)) {
+			continue
+		}
+		if !ok || x.StartDate.After(found.StartDate) {
+			found = x
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// applyPercent adds percent to amount, rounding to the nearest cent
+func applyPercent(amount, percent int) int {
+	v := amount * (100 + percent)
+	if v >= 0 {
+		return (v + 50) / 100
+	}
+	return (v - 50) / 100
+}
+
+func truncateToDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// FormatAmount formats an amount in cents, e.g. 12050 -> "$120.50"
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseAmount parses an amount typed in by a person, e.g. "120.5" or "$120.50", into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	if s == "" {
+		return 0, errors.New("amount is empty")
+	}
+
+	whole, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("%q has more than two decimal places", s)
+	}
+	// ".50" is fifty cents and "120." is 120 dollars, but "." is nothing
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("%q is not a valid amount", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || (fraction != "" && !isDigits(fraction)) {
+		return 0, fmt.Errorf("%q is not a valid amount", s)
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	w, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid amount", s)
+	}
+	f, _ := strconv.Atoi(fraction)
+
+	return w*100 + f, nil
+}
+
+// isDigits reports whether s is made of ASCII digits only; unlike strconv.Atoi, it refuses a
+// sign
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
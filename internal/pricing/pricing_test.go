@@ -0,0 +1,115 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestQuote(t *testing.T) {
+	room := models.Room{ID: 1, BaseRate: 10000}
+
+	seasons := []models.SeasonalRate{
+		{SeasonName: "Summer", StartDate: date("2050-07-01"), EndDate: date("2050-09-01"), NightlyRate: 15000},
+		{SeasonName: "Festival", StartDate: date("2050-08-10"), EndDate: date("2050-08-12"), NightlyRate: 30000},
+	}
+
+	// 20% on Fridays and Saturdays
+	modifiers := []models.RateModifier{
+		{Weekday: time.Friday, Percent: 20},
+		{Weekday: time.Saturday, Percent: 20},
+	}
+
+	var tests = []struct {
+		name          string
+		start         string
+		end           string
+		expectedTotal int
+		expectedCount int
+	}{
+		// 2050-06-06 is a Monday
+		{"base rate on week nights", "2050-06-06", "2050-06-08", 20000, 2},
+		{"weekend surcharge", "2050-06-09", "2050-06-12", 10000 + 12000 + 12000, 3},
+		{"into the season", "2050-06-29", "2050-07-02", 10000 + 10000 + 18000, 3},
+		{"overlapping season wins when it starts last", "2050-08-09", "2050-08-13", 15000 + 30000 + 30000 + 18000, 4},
+	}
+
+	for _, e := range tests {
+		quote, err := Quote(room, seasons, modifiers, date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+		if quote.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, quote.Total)
+		}
+		if len(quote.Nights) != e.expectedCount {
+			t.Errorf("%s: expected %d nights but got %d", e.name, e.expectedCount, len(quote.Nights))
+		}
+	}
+
+	_, err := Quote(room, seasons, modifiers, date("2050-06-08"), date("2050-06-08"))
+	if err != ErrInvalidRange {
+		t.Error("expected an error for a stay with no nights")
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12050, "$120.50"},
+		{-250, "-$2.50"},
+	}
+
+	for _, e := range tests {
+		if got := FormatAmount(e.cents); got != e.expected {
+			t.Errorf("FormatAmount(%d): expected %s but got %s", e.cents, e.expected, got)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		s        string
+		expected int
+		valid    bool
+	}{
+		{"120", 12000, true},
+		{"120.5", 12050, true},
+		{"$120.05", 12005, true},
+		{"", 0, false},
+		{"12.345", 0, false},
+		{"-3", 0, false},
+		{"abc", 0, false},
+		{".50", 50, true},
+		{"120.", 12000, true},
+		{".", 0, false},
+		{"1.+5", 0, false},
+		{"1.+", 0, false},
+		{"+1", 0, false},
+		{"1.-5", 0, false},
+	}
+
+	for _, e := range tests {
+		got, err := ParseAmount(e.s)
+		if e.valid && err != nil {
+			t.Errorf("ParseAmount(%q): unexpected error %s", e.s, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("ParseAmount(%q): expected an error", e.s)
+		}
+		if got != e.expected {
+			t.Errorf("ParseAmount(%q): expected %d but got %d", e.s, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+var pathToEmailTemplates = "./email-templates"
+
+// Email renders the email of data with its template, which defines the "subject" and the
+// "body" of the email and puts them in a layout. The plain text part is made from the body.
+// The caller fills in the sender and the recipient.
+func Email(data models.EmailData) (models.MailData, error) {
+	var tc map[string]*template.Template
+
+	if app.UseChache {
+		tc = app.EmailTemplateCache
+	} else {
+		var err error
+		tc, err = CreateEmailTemplateCache()
+		if err != nil {
+			return models.MailData{}, err
+		}
+	}
+
+	name := data.EmailTemplate()
+	t, ok := tc[name+".email.tmpl"]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("can't get email template %s from cache", name)
+	}
+
+	var subject, body, page bytes.Buffer
+
+	err := t.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	err = t.ExecuteTemplate(&body, "body", data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	err = t.Execute(&page, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		// the subject is a header, not HTML
+		Subject:  strings.Join(strings.Fields(html.UnescapeString(subject.String())), " "),
+		Content:  strings.TrimSpace(page.String()),
+		Text:     HTMLToText(body.String()),
+		Template: name,
+	}, nil
+}
+
+// CreateEmailTemplateCache parses the email templates, each with the email layouts, into a map
+func CreateEmailTemplateCache() (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	emails, err := filepath.Glob(fmt.Sprintf("%s/*.email.tmpl", pathToEmailTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, email := range emails {
+		name := filepath.Base(email)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(email)
+		if err != nil {
+			return myCache, err
+		}
+
+		ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.tmpl", pathToEmailTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = ts
+	}
+
+	return myCache, nil
+}
+
+var (
+	textLinks  = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	textBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr|table)>`)
+	textTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+	textBlanks = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText turns the HTML of an email body into plain text: paragraphs and line breaks
+// become new lines, links show their address, and the other tags are dropped
+func HTMLToText(s string) string {
+	s = textLinks.ReplaceAllStringFunc(s, func(link string) string {
+		parts := textLinks.FindStringSubmatch(link)
+		text := strings.TrimSpace(textTags.ReplaceAllString(parts[2], ""))
+		if text == "" || text == parts[1] {
+			return parts[1]
+		}
+		return fmt.Sprintf("%s (%s)", text, parts[1])
+	})
+
+	// the white space of the HTML source doesn't show, only the breaks do
+	s = strings.Join(strings.Fields(s), " ")
+	s = textBreaks.ReplaceAllString(s, "$0\n")
+	s = strings.ReplaceAll(s, "</p>\n", "</p>\n\n")
+	s = html.UnescapeString(textTags.ReplaceAllString(s, ""))
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.TrimSpace(textBlanks.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
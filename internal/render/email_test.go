@@ -0,0 +1,120 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+// unknownEmail names a template that doesn't exist
+type unknownEmail struct{}
+
+func (unknownEmail) EmailTemplate() string { return "unknown" }
+
+func testEmailReservation() models.Reservation {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	return models.Reservation{
+		FirstName:        `<b>Tal</b> & "Co"`,
+		LastName:         "Drori",
+		Email:            "tal@drori.com",
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 2),
+		ConfirmationCode: "LV-ABC-123",
+		TotalPrice:       20000,
+		Rooms: []models.ReservationRoom{{
+			Room: models.Room{RoomName: "Jonin's Quarters"},
+			PriceBreakdown: []models.NightPrice{
+				{Date: start, Price: 10000},
+				{Date: start.AddDate(0, 0, 1), Price: 10000},
+			},
+		}},
+	}
+}
+
+func TestEmail(t *testing.T) {
+	pathToEmailTemplates = "./../../email-templates"
+	tc, err := CreateEmailTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.EmailTemplateCache = tc
+	app.UseChache = true
+
+	res := testEmailReservation()
+	start := res.StartDate.AddDate(0, 0, -7)
+
+	var tests = []struct {
+		name    string
+		data    models.EmailData
+		subject string
+		text    []string
+	}{
+		{"confirmation", models.ConfirmationEmail{Reservation: res}, "Reservation Confirmation LV-ABC-123",
+			[]string{`Dear <b>Tal</b> & "Co",`, "Jonin's Quarters", "Sat 01/01/2050: $100.00", "Total: $200.00"}},
+		{"new reservation", models.NewReservationEmail{Reservation: res}, "Reservation Notification LV-ABC-123",
+			[]string{"(tal@drori.com)", "Confirmation code: LV-ABC-123"}},
+		{"changed", models.ChangedReservationEmail{Reservation: res, OldStartDate: start, OldEndDate: start.AddDate(0, 0, 2)},
+			"Reservation Changed LV-ABC-123", []string{"moved from 12/25/2049 - 12/27/2049 to 01/01/2050 - 01/03/2050", "Dear"}},
+		{"changed, for the owner", models.ChangedReservationEmail{Reservation: res, OldStartDate: start, OldEndDate: start.AddDate(0, 0, 2), ForOwner: true},
+			"Reservation Changed LV-ABC-123", []string{"Booking LV-ABC-123 of"}},
+		{"cancelled", models.CancelledReservationEmail{Reservation: res}, "Reservation Cancelled LV-ABC-123",
+			[]string{"has been cancelled"}},
+	}
+
+	for _, e := range tests {
+		msg, err := Email(e.data)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+
+		if msg.Subject != e.subject {
+			t.Errorf("%s: expected subject %q, got %q", e.name, e.subject, msg.Subject)
+		}
+		if msg.Template != e.data.EmailTemplate() {
+			t.Errorf("%s: expected template %s, got %s", e.name, e.data.EmailTemplate(), msg.Template)
+		}
+		for _, want := range e.text {
+			if !strings.Contains(msg.Text, want) {
+				t.Errorf("%s: expected the text part to contain %q, got:\n%s", e.name, want, msg.Text)
+			}
+		}
+		if strings.Contains(strings.Replace(msg.Text, "<b>Tal</b>", "", -1), "<") {
+			t.Errorf("%s: expected no tags in the text part, got:\n%s", e.name, msg.Text)
+		}
+
+		// the guest's name is escaped in the HTML, which is put in the layout
+		if !strings.Contains(msg.Content, "&lt;b&gt;Tal&lt;/b&gt; &amp; &#34;Co&#34;") || strings.Contains(msg.Content, "<b>Tal</b>") {
+			t.Errorf("%s: expected the guest's name to be escaped in the HTML", e.name)
+		}
+		if !strings.Contains(msg.Content, "<title>"+e.subject+"</title>") {
+			t.Errorf("%s: expected the email to be put in the layout", e.name)
+		}
+	}
+
+	_, err = Email(unknownEmail{})
+	if err == nil {
+		t.Error("expected an error for an email without a template")
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	var tests = []struct {
+		html string
+		text string
+	}{
+		{"<p>Dear Tal,</p>\n  <p>See   you <strong>soon</strong>.</p>", "Dear Tal,\n\nSee you soon.\n"},
+		{"One<br>Two<br/>\nThree", "One\nTwo\nThree\n"},
+		{`<p>Visit <a href="https://leaf.village/manage">Manage Booking</a></p>`, "Visit Manage Booking (https://leaf.village/manage)\n"},
+		{`<a href="https://leaf.village">https://leaf.village</a>`, "https://leaf.village\n"},
+		{"<p>Fish &amp; chips &lt;3</p>", "Fish & chips <3\n"},
+	}
+
+	for _, e := range tests {
+		if got := HTMLToText(e.html); got != e.text {
+			t.Errorf("HTMLToText(%q): expected %q, got %q", e.html, e.text, got)
+		}
+	}
+}
@@ -12,13 +12,15 @@ import (
 	"github.com/justinas/nosurf"
 	"github.com/taldrori/bookings/internal/config"
 	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/pricing"
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"formatPrice": pricing.FormatAmount,
 }
 
 var app *config.Appconfig
@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+)
+
+// codeAlphabet leaves out characters that are easily mistaken for one another, like 0 and O
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newConfirmationCode returns a random confirmation code, like LV-7KQ3-M9
+func newConfirmationCode() (string, error) {
+	b := []byte("LV-0000-00")
+	for i := range b {
+		if b[i] != '0' {
+			continue
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = codeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// newCalendarToken returns a random token for the address of the calendar feed of a room
+func newCalendarToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newAPIToken returns a random secret for an API token
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "bk_" + hex.EncodeToString(b), nil
+}
+
+// hashAPIToken is how an API token is stored. The secret is random and long, so a fast hash
+// is enough to keep it from being read out of the database.
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newWebhookSecret returns a random secret for signing the deliveries of a webhook
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
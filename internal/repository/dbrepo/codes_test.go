@@ -0,0 +1,25 @@
+package dbrepo
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewConfirmationCode(t *testing.T) {
+	re := regexp.MustCompile(`^LV-[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{2}$`)
+	seen := make(map[string]bool)
+
+	for i := 0; i < 100; i++ {
+		code, err := newConfirmationCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !re.MatchString(code) {
+			t.Errorf("%s is not a valid confirmation code", code)
+		}
+		if seen[code] {
+			t.Errorf("got %s twice", code)
+		}
+		seen[code] = true
+	}
+}
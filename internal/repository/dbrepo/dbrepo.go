@@ -1,9 +1,12 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/mailer"
 	"github.com/taldrori/bookings/internal/repository"
 )
 
@@ -12,9 +15,16 @@ type postgressDBRepo struct {
 	DB  *sql.DB
 }
 
+type sqliteDBRepo struct {
+	App *config.Appconfig
+	DB  *sql.DB
+}
+
 type testDBRepo struct {
 	App *config.Appconfig
 	DB  *sql.DB
+	// Mailer sends the emails queued with a change right away, in place of the outbox
+	Mailer mailer.Mailer
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.Appconfig) repository.DatabaseRepo {
@@ -24,8 +34,39 @@ func NewPostgresRepo(conn *sql.DB, a *config.Appconfig) repository.DatabaseRepo
 	}
 }
 
-func NewTestingRepo(a *config.Appconfig) repository.DatabaseRepo {
-	return &testDBRepo{
+func NewSQLiteRepo(conn *sql.DB, a *config.Appconfig) repository.DatabaseRepo {
+	return &sqliteDBRepo{
 		App: a,
+		DB:  conn,
+	}
+}
+
+func NewTestingRepo(a *config.Appconfig, m mailer.Mailer) repository.DatabaseRepo {
+	return &testDBRepo{
+		App:    a,
+		Mailer: m,
+	}
+}
+
+// defaultTimeout bounds queries when the application doesn't configure a timeout
+const defaultTimeout = 3 * time.Second
+
+// withTimeout bounds the queries of a repository call by the configured database timeout, on
+// top of ctx being cancelled
+func (m *postgressDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.App)
+}
+
+// withTimeout bounds the queries of a repository call by the configured database timeout, on
+// top of ctx being cancelled
+func (m *sqliteDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, m.App)
+}
+
+func withTimeout(ctx context.Context, app *config.Appconfig) (context.Context, context.CancelFunc) {
+	timeout := defaultTimeout
+	if app != nil && app.DBTimeout > 0 {
+		timeout = app.DBTimeout
 	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,48 @@
+package dbrepo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/config"
+)
+
+func TestWithTimeout(t *testing.T) {
+	var tests = []struct {
+		name     string
+		app      *config.Appconfig
+		expected time.Duration
+	}{
+		{"no app config", nil, defaultTimeout},
+		{"no timeout configured", &config.Appconfig{}, defaultTimeout},
+		{"timeout configured", &config.Appconfig{DBTimeout: 10 * time.Second}, 10 * time.Second},
+	}
+
+	for _, e := range tests {
+		m := &postgressDBRepo{App: e.app}
+
+		before := time.Now()
+		ctx, cancel := m.withTimeout(context.Background())
+		deadline, ok := ctx.Deadline()
+		cancel()
+
+		if !ok {
+			t.Errorf("%s: expected a deadline", e.name)
+			continue
+		}
+		if d := deadline.Sub(before); d < e.expected || d > e.expected+time.Second {
+			t.Errorf("%s: expected a timeout of %s but got %s", e.name, e.expected, d)
+		}
+	}
+
+	// a cancelled request cancels its queries
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := (&postgressDBRepo{}).withTimeout(parent)
+	defer cancel()
+	cancelParent()
+
+	if ctx.Err() != context.Canceled {
+		t.Errorf("expected the query context to be cancelled with the request, got %v", ctx.Err())
+	}
+}
@@ -0,0 +1,27 @@
+// Package dbtest opens the databases the tests of the other packages work on. The tests of
+// dbrepo itself can't import it, since it imports dbrepo.
+package dbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/driver"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/repository/dbrepo"
+)
+
+// NewSQLiteRepo opens a fresh SQLite database, with its schema and seed data, in a temporary
+// directory of t, and closes it when the test is done
+func NewSQLiteRepo(t testing.TB) repository.DatabaseRepo {
+	t.Helper()
+
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+	if err != nil {
+		t.Fatalf("cannot open the sqlite database: %v", err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	return dbrepo.NewSQLiteRepo(db.SQL, &config.Appconfig{})
+}
@@ -0,0 +1,2675 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/stayrules"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sqliteDate formats a date the way the SQLite schema stores dates, so that they compare
+// as dates
+func sqliteDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// sqliteTime formats a moment in UTC, so that moments compare in the order they happened
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func (m *sqliteDBRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+// CreateReservation inserts a reservation, its rooms and the room restrictions that hold
+// their dates, in a single transaction. The transaction holds the write lock of the database
+// while the availability of the rooms is checked again, and the room_restrictions_no_overlap
+// triggers catch anything that still slips through, so two guests can never book the same
+// room for overlapping dates. It returns repository.ErrRoomUnavailable when any of the rooms
+// is already taken, in which case none of them is booked, and a *stayrules.Violation when the
+// stay breaks a stay rule of one of them. The guest's own holds are released in the same
+// transaction. It returns the id and the confirmation code of the new reservation. The emails
+// mail builds for it are put in the outbox, and the webhook events events builds for it are
+// queued, in the same transaction.
+func (m *sqliteDBRepo) CreateReservation(ctx context.Context, res models.Reservation, holdIDs []int, mail repository.MailFunc, events repository.EventsFunc) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	if len(res.Rooms) == 0 {
+		return 0, "", errors.New("reservation has no rooms")
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	err = sqliteDeleteExpiredHolds(ctx, tx)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, holdID := range holdIDs {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = ?1 and restriction_id = ?2`, holdID,
+			models.RestrictionHold)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	err = sqliteCheckRoomsAvailable(ctx, tx, res)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = sqliteCheckStayRules(ctx, tx, res)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var newID int
+	var code string
+
+	stmt := `insert into reservations
+	 		(first_name, last_name, email, phone, start_date, end_date, room_id,
+				total_price, confirmation_code, created_at, updated_at) 
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)
+			on conflict (confirmation_code) do nothing returning id`
+
+	// in the unlikely case that the code is taken, nothing is inserted and we try another one
+	for tries := 0; newID == 0; tries++ {
+		code, err = newConfirmationCode()
+		if err != nil {
+			return 0, "", err
+		}
+
+		err = tx.QueryRowContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			sqliteDate(res.StartDate),
+			sqliteDate(res.EndDate),
+			res.Rooms[0].RoomID,
+			res.TotalPrice,
+			code,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		if err == sql.ErrNoRows && tries < 5 {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	for _, x := range res.Rooms {
+		breakdown, err := json.Marshal(x.PriceBreakdown)
+		if err != nil {
+			return 0, "", err
+		}
+
+		stmt = `insert into reservation_rooms
+				(reservation_id, room_id, total_price, price_breakdown, created_at, updated_at)
+				values (?1, ?2, ?3, ?4, ?5, ?6)`
+
+		_, err = tx.ExecContext(ctx, stmt, newID, x.RoomID, x.TotalPrice, string(breakdown), time.Now(), time.Now())
+		if err != nil {
+			return 0, "", err
+		}
+
+		stmt = `insert into room_restrictions
+				(start_date, end_date, room_id, reservation_id, restriction_id,
+					created_at, updated_at) 
+				values (?1, ?2, ?3, ?4, ?5, ?6, ?7)`
+
+		_, err = tx.ExecContext(ctx, stmt,
+			sqliteDate(res.StartDate),
+			sqliteDate(res.EndDate),
+			x.RoomID,
+			newID,
+			models.RestrictionReservation,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			if sqliteIsOverlap(err) {
+				return 0, "", repository.ErrRoomUnavailable
+			}
+			return 0, "", err
+		}
+	}
+
+	res.ID = newID
+	res.ConfirmationCode = code
+
+	if mail != nil {
+		msgs, err := mail(res)
+		if err != nil {
+			return 0, "", err
+		}
+		err = sqliteInsertOutboxMessages(ctx, tx, msgs)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if events != nil {
+		evts, err := events(res)
+		if err != nil {
+			return 0, "", err
+		}
+		_, err = sqliteInsertWebhookDeliveries(ctx, tx, evts)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return newID, code, nil
+}
+
+// sqliteCheckRoomsAvailable returns repository.ErrRoomUnavailable when any of the rooms of res
+// is taken for some of its dates by anything but res itself
+func sqliteCheckRoomsAvailable(ctx context.Context, tx *sql.Tx, res models.Reservation) error {
+	query := `
+		select
+			count(id)
+		from
+			room_restrictions
+		where
+			room_id = ?1
+			and ?2 < end_date and ?3 > start_date
+			and (reservation_id is null or reservation_id <> ?4)`
+
+	for _, x := range res.Rooms {
+		var numRows int
+		err := tx.QueryRowContext(ctx, query, x.RoomID, sqliteDate(res.StartDate), sqliteDate(res.EndDate), res.ID).Scan(&numRows)
+		if err != nil {
+			return err
+		}
+		if numRows > 0 {
+			return repository.ErrRoomUnavailable
+		}
+	}
+
+	return nil
+}
+
+// sqliteCheckStayRules returns a *stayrules.Violation when the stay of res breaks a stay rule
+// of any of its rooms
+func sqliteCheckStayRules(ctx context.Context, tx *sql.Tx, res models.Reservation) error {
+	for _, x := range res.Rooms {
+		rules, err := sqliteStayRulesByRoom(ctx, tx, res.StartDate, res.EndDate, x.RoomID)
+		if err != nil {
+			return err
+		}
+
+		err = stayrules.Check(rules[x.RoomID], res.StartDate, res.EndDate)
+		if err != nil {
+			var v *stayrules.Violation
+			if len(res.Rooms) > 1 && x.Room.RoomName != "" && errors.As(err, &v) {
+				return &stayrules.Violation{Reason: fmt.Sprintf("%s: %s", x.Room.RoomName, v.Reason)}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteStayRulesByRoom returns the stay rules that apply to the arrival or the departure of a
+// stay from start to end, by room id. A roomID of 0 returns the rules of every room.
+func sqliteStayRulesByRoom(ctx context.Context, q queryer, start, end time.Time, roomID int) (map[int][]models.StayRule, error) {
+	rules := make(map[int][]models.StayRule)
+
+	query := `
+		select id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+			closed_to_departure, arrival_weekdays, created_at, updated_at
+		from stay_rules
+		where start_date <= ?2 and end_date > ?1 and (?3 = 0 or room_id = ?3)
+		order by start_date
+	`
+
+	rows, err := q.QueryContext(ctx, query, sqliteDate(start), sqliteDate(end), roomID)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sr models.StayRule
+		var weekdays int
+		err := rows.Scan(
+			&sr.ID,
+			&sr.RoomID,
+			&sr.StartDate,
+			&sr.EndDate,
+			&sr.MinNights,
+			&sr.MaxNights,
+			&sr.ClosedToArrival,
+			&sr.ClosedToDeparture,
+			&weekdays,
+			&sr.CreatedAt,
+			&sr.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		sr.ArrivalWeekdays = stayrules.Weekdays(weekdays)
+		rules[sr.RoomID] = append(rules[sr.RoomID], sr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// InsertHold holds a room from start to end for a guest who is filling in the reservation form,
+// until expires. It returns repository.ErrRoomUnavailable when the room is already taken.
+func (m *sqliteDBRepo) InsertHold(ctx context.Context, roomID int, start, end, expires time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = sqliteDeleteExpiredHolds(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+
+	stmt := `insert into room_restrictions
+	 		(start_date, end_date, room_id, restriction_id, expires_at,
+				created_at, updated_at) 
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		sqliteDate(start),
+		sqliteDate(end),
+		roomID,
+		models.RestrictionHold,
+		sqliteTime(expires),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		if sqliteIsOverlap(err) {
+			return 0, repository.ErrRoomUnavailable
+		}
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteHold releases a hold
+func (m *sqliteDBRepo) DeleteHold(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions where id = ?1 and restriction_id = ?2`, id,
+		models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds deletes the holds that have expired, and returns how many there were
+func (m *sqliteDBRepo) DeleteExpiredHolds(ctx context.Context) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_restrictions where restriction_id = ?1 and expires_at <= ?2`,
+		models.RestrictionHold, sqliteTime(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// sqliteDeleteExpiredHolds clears expired holds out of the way of a booking. The transaction
+// already holds the write lock of the database, so there is no need to lock the rooms.
+func sqliteDeleteExpiredHolds(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `delete from room_restrictions where restriction_id = ?1 and expires_at <= ?2`,
+		models.RestrictionHold, sqliteTime(time.Now()))
+
+	return err
+}
+
+// sqliteIsOverlap reports whether err was raised by the room_restrictions_no_overlap triggers
+func sqliteIsOverlap(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "room_restrictions_no_overlap")
+}
+
+// SearchAvailabilityByDatesByRoomID reports whether a room can be booked from start to end. When
+// it is free but the stay breaks one of its stay rules, it also returns the reason.
+func (m *sqliteDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select
+			count(id)
+		from
+			room_restrictions
+		where
+			room_id = ?1
+			and ?2 < end_date and ?3 > start_date
+			and (expires_at is null or expires_at > ?4);`
+
+	var numRows int
+
+	row := m.DB.QueryRowContext(ctx, query, roomID, sqliteDate(start), sqliteDate(end), sqliteTime(time.Now()))
+	err := row.Scan(&numRows)
+
+	if err != nil {
+		return false, "", err
+	}
+
+	if numRows > 0 {
+		return false, "", nil
+	}
+
+	rules, err := sqliteStayRulesByRoom(ctx, m.DB, start, end, roomID)
+	if err != nil {
+		return false, "", err
+	}
+
+	err = stayrules.Check(rules[roomID], start, end)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+
+	return true, "", nil
+}
+
+// SearchAvailabilityForAllRooms returns the rooms that can be booked from start to end for
+// guests, with all of amenityIDs. Rooms that are free but left out because the stay breaks one
+// of their stay rules are returned as exclusions, with the reason.
+func (m *sqliteDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenityIDs []int) ([]models.Room, []models.RoomExclusion, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+	var exclusions []models.RoomExclusion
+	query := `
+		select
+			r.id, r.room_name, r.slug, r.image, r.max_occupancy, r.description, r.bed_types, r.base_rate
+		from
+			rooms r
+		where r.id not in 
+		(select room_id from room_restrictions rr where ?1 < rr.end_date and ?2 > rr.start_date
+			and (rr.expires_at is null or rr.expires_at > ?4))
+		and r.max_occupancy >= ?3`
+
+	args := []interface{}{sqliteDate(start), sqliteDate(end), guests, sqliteTime(time.Now())}
+
+	if len(amenityIDs) > 0 {
+		placeholders := make([]string, len(amenityIDs))
+		for i, id := range amenityIDs {
+			args = append(args, id)
+			placeholders[i] = fmt.Sprintf("?%d", len(args))
+		}
+		args = append(args, len(amenityIDs))
+
+		query = fmt.Sprintf(`%s
+		and (select count(distinct ra.amenity_id) from room_amenities ra
+			where ra.room_id = r.id and ra.amenity_id in (%s)) = ?%d`,
+			query, strings.Join(placeholders, ", "), len(args))
+	}
+
+	query = fmt.Sprintf("%s\n\t\torder by r.room_name", query)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.Slug,
+			&room.Image,
+			&room.MaxOccupancy,
+			&room.Description,
+			&room.BedTypes,
+			&room.BaseRate,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rules, err := sqliteStayRulesByRoom(ctx, m.DB, start, end, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var available []models.Room
+	for _, room := range rooms {
+		room.Amenities, err = m.GetAmenitiesForRoom(ctx, room.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = stayrules.Check(rules[room.ID], start, end)
+		if err != nil {
+			exclusions = append(exclusions, models.RoomExclusion{Room: room, Reason: err.Error()})
+			continue
+		}
+		available = append(available, room)
+	}
+
+	return available, exclusions, nil
+}
+
+func (m *sqliteDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		select
+			id, room_name, slug, image, max_occupancy, description, bed_types, base_rate,
+			calendar_token, created_at, updated_at
+		from rooms where id = ?1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Image,
+		&room.MaxOccupancy,
+		&room.Description,
+		&room.BedTypes,
+		&room.BaseRate,
+		&room.CalendarToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	room.Amenities, err = m.GetAmenitiesForRoom(ctx, room.ID)
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *sqliteDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		select
+			id, room_name, slug, image, max_occupancy, description, bed_types, base_rate,
+			created_at, updated_at
+		from rooms where slug = ?1`
+
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Image,
+		&room.MaxOccupancy,
+		&room.Description,
+		&room.BedTypes,
+		&room.BaseRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	room.Amenities, err = m.GetAmenitiesForRoom(ctx, room.ID)
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// InsertRoom inserts a room into the database, with a new calendar token and its amenities, in a
+// single transaction
+func (m *sqliteDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	token, err := newCalendarToken()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into rooms (room_name, slug, image, max_occupancy, description, bed_types,
+				base_rate, calendar_token, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10) returning id`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Image,
+		room.MaxOccupancy,
+		room.Description,
+		room.BedTypes,
+		room.BaseRate,
+		token,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = sqliteReplaceRoomAmenities(ctx, tx, newID, room.Amenities)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// UpdateRoom updates a room in the database, and replaces its amenities with room.Amenities, in a
+// single transaction
+func (m *sqliteDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update rooms set room_name = ?1, slug = ?2, image = ?3, max_occupancy = ?4,
+		description = ?5, bed_types = ?6, base_rate = ?7, updated_at = ?8 where id = ?9`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query,
+		room.RoomName,
+		room.Slug,
+		room.Image,
+		room.MaxOccupancy,
+		room.Description,
+		room.BedTypes,
+		room.BaseRate,
+		time.Now(),
+		room.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = sqliteReplaceRoomAmenities(ctx, tx, room.ID, room.Amenities)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRoom deletes a room, together with its reservations and restrictions
+func (m *sqliteDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `delete from rooms where id = ?1`
+	_, err := m.DB.ExecContext(ctx, query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAmenities returns all the amenities a room can have
+func (m *sqliteDBRepo) AllAmenities(ctx context.Context) ([]models.Amenity, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var amenities []models.Amenity
+
+	query := `select id, amenity_name, created_at, updated_at from amenities order by amenity_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return amenities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Amenity
+		err := rows.Scan(
+			&a.ID,
+			&a.AmenityName,
+			&a.CreatedAt,
+			&a.UpdatedAt,
+		)
+		if err != nil {
+			return amenities, err
+		}
+		amenities = append(amenities, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return amenities, err
+	}
+
+	return amenities, nil
+}
+
+// InsertAmenity inserts an amenity into the database
+func (m *sqliteDBRepo) InsertAmenity(ctx context.Context, a models.Amenity) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into amenities (amenity_name, created_at, updated_at)
+			values (?1, ?2, ?3) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, a.AmenityName, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteAmenity deletes an amenity, and removes it from all the rooms
+func (m *sqliteDBRepo) DeleteAmenity(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `delete from amenities where id = ?1`
+	_, err := m.DB.ExecContext(ctx, query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAmenitiesForRoom returns the amenities of a room
+func (m *sqliteDBRepo) GetAmenitiesForRoom(ctx context.Context, roomID int) ([]models.Amenity, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var amenities []models.Amenity
+
+	query := `
+		select a.id, a.amenity_name, a.created_at, a.updated_at
+		from amenities a
+		inner join room_amenities ra on (ra.amenity_id = a.id)
+		where ra.room_id = ?1
+		order by a.amenity_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return amenities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Amenity
+		err := rows.Scan(
+			&a.ID,
+			&a.AmenityName,
+			&a.CreatedAt,
+			&a.UpdatedAt,
+		)
+		if err != nil {
+			return amenities, err
+		}
+		amenities = append(amenities, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return amenities, err
+	}
+
+	return amenities, nil
+}
+
+// sqliteReplaceRoomAmenities replaces the amenities of a room with amenities, as part of tx
+func sqliteReplaceRoomAmenities(ctx context.Context, tx *sql.Tx, roomID int, amenities []models.Amenity) error {
+	_, err := tx.ExecContext(ctx, `delete from room_amenities where room_id = ?1`, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into room_amenities (room_id, amenity_id, created_at, updated_at)
+			values (?1, ?2, ?3, ?4)`
+
+	for _, x := range amenities {
+		_, err = tx.ExecContext(ctx, stmt, roomID, x.ID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSeasonalRatesForRoom returns the seasonal rates of a room, ordered by start date
+func (m *sqliteDBRepo) GetSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `
+		select id, room_id, season_name, start_date, end_date, nightly_rate, created_at, updated_at
+		from seasonal_rates where room_id = ?1
+		order by start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sr models.SeasonalRate
+		err := rows.Scan(
+			&sr.ID,
+			&sr.RoomID,
+			&sr.SeasonName,
+			&sr.StartDate,
+			&sr.EndDate,
+			&sr.NightlyRate,
+			&sr.CreatedAt,
+			&sr.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, sr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate into the database
+func (m *sqliteDBRepo) InsertSeasonalRate(ctx context.Context, rate models.SeasonalRate) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into seasonal_rates (room_id, season_name, start_date, end_date, nightly_rate,
+				created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rate.RoomID,
+		rate.SeasonName,
+		sqliteDate(rate.StartDate),
+		sqliteDate(rate.EndDate),
+		rate.NightlyRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate
+func (m *sqliteDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from seasonal_rates where id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetStayRulesForRoom returns the stay rules of a room, ordered by start date
+func (m *sqliteDBRepo) GetStayRulesForRoom(ctx context.Context, roomID int) ([]models.StayRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rules []models.StayRule
+
+	query := `
+		select id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival,
+			closed_to_departure, arrival_weekdays, created_at, updated_at
+		from stay_rules where room_id = ?1
+		order by start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sr models.StayRule
+		var weekdays int
+		err := rows.Scan(
+			&sr.ID,
+			&sr.RoomID,
+			&sr.StartDate,
+			&sr.EndDate,
+			&sr.MinNights,
+			&sr.MaxNights,
+			&sr.ClosedToArrival,
+			&sr.ClosedToDeparture,
+			&weekdays,
+			&sr.CreatedAt,
+			&sr.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		sr.ArrivalWeekdays = stayrules.Weekdays(weekdays)
+		rules = append(rules, sr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// InsertStayRule inserts a stay rule into the database
+func (m *sqliteDBRepo) InsertStayRule(ctx context.Context, rule models.StayRule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into stay_rules (room_id, start_date, end_date, min_nights, max_nights,
+				closed_to_arrival, closed_to_departure, arrival_weekdays, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.RoomID,
+		sqliteDate(rule.StartDate),
+		sqliteDate(rule.EndDate),
+		rule.MinNights,
+		rule.MaxNights,
+		rule.ClosedToArrival,
+		rule.ClosedToDeparture,
+		stayrules.WeekdayMask(rule.ArrivalWeekdays),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteStayRule deletes a stay rule
+func (m *sqliteDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRateModifiersForRoom returns the day of week rate modifiers of a room
+func (m *sqliteDBRepo) GetRateModifiersForRoom(ctx context.Context, roomID int) ([]models.RateModifier, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var modifiers []models.RateModifier
+
+	query := `
+		select id, room_id, weekday, percent, created_at, updated_at
+		from rate_modifiers where room_id = ?1
+		order by weekday
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return modifiers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.RateModifier
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomID,
+			&rm.Weekday,
+			&rm.Percent,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return modifiers, err
+		}
+		modifiers = append(modifiers, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return modifiers, err
+	}
+
+	return modifiers, nil
+}
+
+// UpdateRateModifiersForRoom replaces the day of week rate modifiers of a room
+func (m *sqliteDBRepo) UpdateRateModifiersForRoom(ctx context.Context, roomID int, modifiers []models.RateModifier) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from rate_modifiers where room_id = ?1`, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into rate_modifiers (room_id, weekday, percent, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5)`
+
+	for _, x := range modifiers {
+		if x.Percent == 0 {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, stmt, roomID, int(x.Weekday), x.Percent, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *sqliteDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+				from users where id = ?1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	var u models.User
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+func (m *sqliteDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `update users set first_name = ?1, last_name = ?2, email = ?3, access_level = ?4, updated_at = ?5`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *sqliteDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = ?1", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", nil
+	}
+
+	return id, hashedPassword, nil
+}
+
+func (m *sqliteDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.cancelled,
+		r.total_price, r.confirmation_code, rm.id,
+		coalesce((select group_concat(room_name, ', ') from (select grm.room_name
+			from reservation_rooms rr left join rooms grm on (rr.room_id = grm.id)
+			where rr.reservation_id = r.id order by rr.id)), rm.room_name)
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		order by r.start_date asc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Cancelled,
+			&i.TotalPrice,
+			&i.ConfirmationCode,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+func (m *sqliteDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.cancelled,
+		r.total_price, r.confirmation_code, rm.id,
+		coalesce((select group_concat(room_name, ', ') from (select grm.room_name
+			from reservation_rooms rr left join rooms grm on (rr.room_id = grm.id)
+			where rr.reservation_id = r.id order by rr.id)), rm.room_name)
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where processed = 0
+		order by r.start_date asc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Cancelled,
+			&i.TotalPrice,
+			&i.ConfirmationCode,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+func (m *sqliteDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.cancelled,
+		r.total_price, r.confirmation_code, r.calendar_sequence, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.id = ?1
+	`
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.Cancelled,
+		&res.TotalPrice,
+		&res.ConfirmationCode,
+		&res.CalendarSequence,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+
+	if err != nil {
+		return res, err
+	}
+
+	query = `
+		select rr.id, rr.reservation_id, rr.room_id, rr.total_price,
+		coalesce(rr.price_breakdown, ''), rr.created_at, rr.updated_at, rm.id, rm.room_name, rm.slug
+		from reservation_rooms rr
+		left join rooms rm on (rr.room_id = rm.id)
+		where rr.reservation_id = ?1
+		order by rr.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x models.ReservationRoom
+		var breakdown string
+		err := rows.Scan(
+			&x.ID,
+			&x.ReservationID,
+			&x.RoomID,
+			&x.TotalPrice,
+			&breakdown,
+			&x.CreatedAt,
+			&x.UpdatedAt,
+			&x.Room.ID,
+			&x.Room.RoomName,
+			&x.Room.Slug,
+		)
+		if err != nil {
+			return res, err
+		}
+
+		if breakdown != "" {
+			err = json.Unmarshal([]byte(breakdown), &x.PriceBreakdown)
+			if err != nil {
+				return res, err
+			}
+		}
+
+		res.Rooms = append(res.Rooms, x)
+	}
+
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+
+	return res, nil
+
+}
+
+// GetReservationByCode returns the reservation with a confirmation code
+func (m *sqliteDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `select id from reservations where confirmation_code = ?1`,
+		strings.ToUpper(strings.TrimSpace(code))).Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationByID(ctx, id)
+}
+
+// UpdateReservation saves the guest's details of a reservation, and queues events for the
+// webhooks, in a single transaction
+func (m *sqliteDBRepo) UpdateReservation(ctx context.Context, res models.Reservation, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set first_name = ?1, last_name = ?2, email = ?3,
+		phone = ?4, updated_at = ?5 where id = ?6`
+
+	_, err = tx.ExecContext(ctx, query,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		time.Now(),
+		res.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReservation deletes a reservation, and queues events for the webhooks, in a single
+// transaction
+func (m *sqliteDBRepo) DeleteReservation(ctx context.Context, id int, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `delete from reservations where id = ?1`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeReservationDates moves a reservation, and the room restrictions that hold the dates
+// of its rooms, to res.StartDate and res.EndDate and stores the new prices of its rooms. It
+// returns repository.ErrRoomUnavailable when any of the rooms is taken for some of the new
+// dates, and a *stayrules.Violation when the new stay breaks a stay rule of one of them. The
+// calendar sequence of the reservation goes up by one, and the mail is put in the outbox and
+// the events are queued for the webhooks in the same transaction.
+func (m *sqliteDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = sqliteCheckRoomsAvailable(ctx, tx, res)
+	if err != nil {
+		return err
+	}
+
+	err = sqliteCheckStayRules(ctx, tx, res)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update reservations set start_date = ?1, end_date = ?2, total_price = ?3,
+		calendar_sequence = calendar_sequence + 1, updated_at = ?4 where id = ?5`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		sqliteDate(res.StartDate),
+		sqliteDate(res.EndDate),
+		res.TotalPrice,
+		time.Now(),
+		res.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, x := range res.Rooms {
+		breakdown, err := json.Marshal(x.PriceBreakdown)
+		if err != nil {
+			return err
+		}
+
+		stmt = `update reservation_rooms set total_price = ?1, price_breakdown = ?2, updated_at = ?3
+			where id = ?4`
+
+		_, err = tx.ExecContext(ctx, stmt, x.TotalPrice, string(breakdown), time.Now(), x.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt = `update room_restrictions set start_date = ?1, end_date = ?2, updated_at = ?3
+		where reservation_id = ?4`
+
+	_, err = tx.ExecContext(ctx, stmt, sqliteDate(res.StartDate), sqliteDate(res.EndDate), time.Now(), res.ID)
+	if err != nil {
+		if sqliteIsOverlap(err) {
+			return repository.ErrRoomUnavailable
+		}
+		return err
+	}
+
+	err = sqliteInsertOutboxMessages(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CancelReservation marks a reservation as cancelled, raises its calendar sequence and releases
+// its room restriction, puts the mail in the outbox and queues the events for the webhooks, in a
+// single transaction. It returns repository.ErrAlreadyCancelled, and changes nothing, when the
+// reservation was cancelled before.
+func (m *sqliteDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `update reservations set cancelled = 1,
+		calendar_sequence = calendar_sequence + 1, updated_at = ?1 where id = ?2 and cancelled = 0`,
+		time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = sqliteInsertOutboxMessages(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateProcessedForReservation marks a reservation as processed or not, and queues events for
+// the webhooks, in a single transaction
+func (m *sqliteDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set processed = ?1 where id = ?2`
+	_, err = tx.ExecContext(ctx, query, processed, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *sqliteDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `select id, room_name, slug, image, max_occupancy, description, bed_types,
+		base_rate, created_at, updated_at from rooms order by room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Slug,
+			&rm.Image,
+			&rm.MaxOccupancy,
+			&rm.Description,
+			&rm.BedTypes,
+			&rm.BaseRate,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+func (m *sqliteDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date,
+		expires_at, reason, category, coalesce(feed_id, 0), external_uid
+		from room_restrictions where ?1 < end_date and ?2 >= start_date
+		and room_id = ?3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(start), sqliteDate(end), roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		var expiresAt sql.NullTime
+		err := rows.Scan(
+			&rr.ID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.RoomID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&expiresAt,
+			&rr.Reason,
+			&rr.Category,
+			&rr.FeedID,
+			&rr.ExternalUID,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		rr.ExpiresAt = expiresAt.Time
+		restrictions = append(restrictions, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// TakenNights returns, by room, the nights from start up to, but not including, end that a
+// reservation, a block or a hold that hasn't expired keeps the room from being booked for.
+// A roomID of 0 asks about all the rooms. Rooms free for all the nights are left out.
+func (m *sqliteDBRepo) TakenNights(ctx context.Context, start, end time.Time, roomID int) (map[int][]time.Time, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		with recursive nights(night) as (
+			select ?1 where ?1 < ?2
+			union all
+			select date(night, '+1 day') from nights where date(night, '+1 day') < ?2
+		)
+		select rr.room_id, n.night
+		from nights n
+		join room_restrictions rr on rr.start_date <= n.night and rr.end_date > n.night
+		where (?3 = 0 or rr.room_id = ?3)
+		and (rr.expires_at is null or rr.expires_at > ?4)
+		group by rr.room_id, n.night
+		order by rr.room_id, n.night`
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(start), sqliteDate(end), roomID, sqliteTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nights := make(map[int][]time.Time)
+	for rows.Next() {
+		var id int
+		var night string
+		err := rows.Scan(&id, &night)
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.Parse("2006-01-02", night)
+		if err != nil {
+			return nil, err
+		}
+		nights[id] = append(nights[id], d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nights, nil
+}
+
+// InsertBlockForRoom blocks a room from block.StartDate up to, but not including,
+// block.EndDate. It returns repository.ErrRoomUnavailable when the room is already taken for
+// some of those dates. The webhook events events builds for the block are queued in the same
+// transaction.
+func (m *sqliteDBRepo) InsertBlockForRoom(ctx context.Context, block models.RoomRestriction, events repository.BlockEventsFunc) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		insert into room_restrictions (start_date, end_date, room_id, restriction_id, reason,
+		category, created_at, updated_at) values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8) returning id
+	`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, query,
+		sqliteDate(block.StartDate),
+		sqliteDate(block.EndDate),
+		block.RoomID,
+		models.RestrictionOwnerBlock,
+		block.Reason,
+		block.Category,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		if sqliteIsOverlap(err) {
+			return 0, repository.ErrRoomUnavailable
+		}
+		log.Println(err)
+		return 0, err
+	}
+
+	if events != nil {
+		block.ID = newID
+		evts, err := events(block)
+		if err != nil {
+			return 0, err
+		}
+		_, err = sqliteInsertWebhookDeliveries(ctx, tx, evts)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetBlockByID returns an owner block
+func (m *sqliteDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var block models.RoomRestriction
+
+	query := `
+		select rr.id, rr.restriction_id, rr.room_id, rr.start_date, rr.end_date, rr.reason,
+		rr.category, rr.created_at, rr.updated_at, r.id, r.room_name
+		from room_restrictions rr
+		left join rooms r on (rr.room_id = r.id)
+		where rr.id = ?1 and rr.restriction_id = ?2
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&block.ID,
+		&block.RestrictionID,
+		&block.RoomID,
+		&block.StartDate,
+		&block.EndDate,
+		&block.Reason,
+		&block.Category,
+		&block.CreatedAt,
+		&block.UpdatedAt,
+		&block.Room.ID,
+		&block.Room.RoomName,
+	)
+
+	if err != nil {
+		return block, err
+	}
+
+	return block, nil
+}
+
+// UpdateBlock moves an owner block to other dates, and changes its reason and category. It
+// returns repository.ErrRoomUnavailable when the room is already taken for some of the new
+// dates. The events are queued for the webhooks in the same transaction.
+func (m *sqliteDBRepo) UpdateBlock(ctx context.Context, block models.RoomRestriction, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		update room_restrictions set start_date = ?1, end_date = ?2, reason = ?3, category = ?4,
+		updated_at = ?5 where id = ?6 and restriction_id = ?7
+	`
+
+	_, err = tx.ExecContext(ctx, query,
+		sqliteDate(block.StartDate),
+		sqliteDate(block.EndDate),
+		block.Reason,
+		block.Category,
+		time.Now(),
+		block.ID,
+		models.RestrictionOwnerBlock,
+	)
+
+	if err != nil {
+		if sqliteIsOverlap(err) {
+			return repository.ErrRoomUnavailable
+		}
+		log.Println(err)
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBlockById deletes an owner block, and queues events for the webhooks, in a single
+// transaction
+func (m *sqliteDBRepo) DeleteBlockById(ctx context.Context, id int, events []models.WebhookEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		delete from room_restrictions where id = ?1 and restriction_id = ?2
+	`
+
+	_, err = tx.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = sqliteInsertWebhookDeliveries(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetRoomCalendarToken gives a room a new calendar token, so that the address of its
+// calendar feed changes, and returns it
+func (m *sqliteDBRepo) ResetRoomCalendarToken(ctx context.Context, roomID int) (string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	token, err := newCalendarToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `update rooms set calendar_token = ?1, updated_at = ?2 where id = ?3`,
+		token, time.Now(), roomID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AllCalendarFeeds returns the external calendars of all the rooms
+func (m *sqliteDBRepo) AllCalendarFeeds(ctx context.Context) ([]models.CalendarFeed, error) {
+	return m.calendarFeeds(ctx, `select `+calendarFeedColumns+`
+		from room_calendar_feeds f left join rooms r on (f.room_id = r.id)
+		order by f.id`)
+}
+
+// GetCalendarFeedsForRoom returns the external calendars of a room
+func (m *sqliteDBRepo) GetCalendarFeedsForRoom(ctx context.Context, roomID int) ([]models.CalendarFeed, error) {
+	return m.calendarFeeds(ctx, `select `+calendarFeedColumns+`
+		from room_calendar_feeds f left join rooms r on (f.room_id = r.id)
+		where f.room_id = ?1 order by f.id`, roomID)
+}
+
+func (m *sqliteDBRepo) calendarFeeds(ctx context.Context, query string, args ...interface{}) ([]models.CalendarFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var feeds []models.CalendarFeed
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		feed, err := scanCalendarFeed(rows)
+		if err != nil {
+			return feeds, err
+		}
+		feeds = append(feeds, feed)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
+
+// GetCalendarFeedByID returns an external calendar
+func (m *sqliteDBRepo) GetCalendarFeedByID(ctx context.Context, id int) (models.CalendarFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + calendarFeedColumns + `
+		from room_calendar_feeds f left join rooms r on (f.room_id = r.id)
+		where f.id = ?1`
+
+	return scanCalendarFeed(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertCalendarFeed adds an external calendar to a room
+func (m *sqliteDBRepo) InsertCalendarFeed(ctx context.Context, feed models.CalendarFeed) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_calendar_feeds (room_id, name, url, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		feed.RoomID,
+		feed.Name,
+		feed.URL,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCalendarFeed deletes an external calendar with the blocks imported from it
+func (m *sqliteDBRepo) DeleteCalendarFeed(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where feed_id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_calendar_feeds where id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SyncCalendarFeed makes the blocks imported from an external calendar match blocks, the
+// events it has now, known by their ExternalUID: the blocks of events that are gone are
+// removed, the ones of events that have other dates are moved, and the new events are added.
+// The events that fall on dates the room is already taken for are left out and returned as
+// conflicts. The time of the sync is recorded, and the last error of the feed cleared.
+func (m *sqliteDBRepo) SyncCalendarFeed(ctx context.Context, id int, blocks []models.RoomRestriction) (models.CalendarFeedSync, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var result models.CalendarFeedSync
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from room_calendar_feeds where id = ?1`, id).Scan(&roomID)
+	if err != nil {
+		return result, err
+	}
+
+	err = sqliteDeleteExpiredHolds(ctx, tx)
+	if err != nil {
+		return result, err
+	}
+
+	imported, err := sqliteImportedBlocks(ctx, tx, id)
+	if err != nil {
+		return result, err
+	}
+
+	current := make(map[string]bool)
+	for _, x := range blocks {
+		current[x.ExternalUID] = true
+	}
+
+	// removing first frees the dates of events that moved onto them
+	for uid, x := range imported {
+		if current[uid] {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = ?1`, x.ID)
+		if err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	for _, x := range blocks {
+		x.RoomID = roomID
+		old, ok := imported[x.ExternalUID]
+
+		if ok && old.StartDate.Equal(x.StartDate) && old.EndDate.Equal(x.EndDate) {
+			if old.Reason != x.Reason {
+				_, err = tx.ExecContext(ctx, `update room_restrictions set reason = ?1, updated_at = ?2 where id = ?3`,
+					x.Reason, time.Now(), old.ID)
+				if err != nil {
+					return result, err
+				}
+			}
+			continue
+		}
+
+		// the overlap triggers abort the statement, and leave the transaction usable
+		if ok {
+			_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = ?1, end_date = ?2,
+				reason = ?3, updated_at = ?4 where id = ?5`,
+				sqliteDate(x.StartDate), sqliteDate(x.EndDate), x.Reason, time.Now(), old.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id,
+				restriction_id, reason, feed_id, external_uid, created_at, updated_at)
+				values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)`,
+				sqliteDate(x.StartDate), sqliteDate(x.EndDate), roomID, models.RestrictionExternal, x.Reason, id, x.ExternalUID, time.Now(), time.Now())
+		}
+
+		switch {
+		case sqliteIsOverlap(err):
+			result.Conflicts = append(result.Conflicts, x)
+		case err != nil:
+			return result, err
+		case ok:
+			result.Moved++
+		default:
+			result.Added++
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_calendar_feeds set last_synced_at = ?1, last_error = '',
+		updated_at = ?2 where id = ?3`, sqliteTime(time.Now()), time.Now(), id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+// sqliteImportedBlocks returns the blocks imported from a feed by the UID of their event
+func sqliteImportedBlocks(ctx context.Context, tx *sql.Tx, feedID int) (map[string]models.RoomRestriction, error) {
+	blocks := make(map[string]models.RoomRestriction)
+
+	rows, err := tx.QueryContext(ctx, `select id, external_uid, start_date, end_date, reason
+		from room_restrictions where feed_id = ?1`, feedID)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x models.RoomRestriction
+		err := rows.Scan(&x.ID, &x.ExternalUID, &x.StartDate, &x.EndDate, &x.Reason)
+		if err != nil {
+			return blocks, err
+		}
+		blocks[x.ExternalUID] = x
+	}
+
+	return blocks, rows.Err()
+}
+
+// SetCalendarFeedError records why the last sync of an external calendar went wrong
+func (m *sqliteDBRepo) SetCalendarFeedError(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update room_calendar_feeds set last_error = ?1, updated_at = ?2 where id = ?3`,
+		lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAPITokens returns the API tokens of all the staff, without their secrets
+func (m *sqliteDBRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var tokens []models.APIToken
+
+	rows, err := m.DB.QueryContext(ctx, `select `+apiTokenColumns+`
+		from api_tokens t left join users u on (t.user_id = u.id)
+		order by t.id`)
+	if err != nil {
+		return tokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// InsertAPIToken makes a new API token for a staff member and returns its ID and its secret,
+// which isn't stored and can't be shown again
+func (m *sqliteDBRepo) InsertAPIToken(ctx context.Context, token models.APIToken) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	secret, err := newAPIToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var newID int
+
+	stmt := `insert into api_tokens (user_id, name, token_hash, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.Name,
+		hashAPIToken(secret),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	return newID, secret, nil
+}
+
+// AuthenticateAPIToken returns the API token with secret, and records that it was used. An
+// unknown secret returns sql.ErrNoRows.
+func (m *sqliteDBRepo) AuthenticateAPIToken(ctx context.Context, secret string) (models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + apiTokenColumns + `
+		from api_tokens t left join users u on (t.user_id = u.id)
+		where t.token_hash = ?1`
+
+	t, err := scanAPIToken(m.DB.QueryRowContext(ctx, query, hashAPIToken(secret)))
+	if err != nil {
+		return t, err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `update api_tokens set last_used_at = ?1 where id = ?2`, time.Now(), t.ID)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// DeleteAPIToken revokes an API token
+func (m *sqliteDBRepo) DeleteAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from api_tokens where id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sqliteInsertOutboxMessages puts emails in the outbox as part of tx, to be delivered right away
+func sqliteInsertOutboxMessages(ctx context.Context, tx *sql.Tx, msgs []models.MailData) error {
+	stmt := `insert into outbox (to_address, from_address, subject, content, text_content, template,
+			attachments, status, next_attempt_at, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)`
+
+	for _, x := range msgs {
+		attachments, err := encodeAttachments(x.Attachments)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			x.To,
+			x.From,
+			x.Subject,
+			x.Content,
+			x.Text,
+			x.Template,
+			attachments,
+			models.OutboxPending,
+			sqliteTime(time.Now()),
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimOutboxMessages returns up to limit pending messages that are due, oldest first, and
+// puts off their next attempt by lease so that no other worker takes them meanwhile. The write
+// lock of the database keeps two workers from claiming the same message.
+func (m *sqliteDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var msgs []models.OutboxMessage
+
+	query := `
+		update outbox set next_attempt_at = ?1, updated_at = ?2
+		where id in (
+			select id from outbox
+			where status = ?3 and next_attempt_at <= ?2
+			order by next_attempt_at, id
+			limit ?4)
+		returning ` + outboxColumns
+
+	now := time.Now()
+	rows, err := m.DB.QueryContext(ctx, query, sqliteTime(now.Add(lease)), sqliteTime(now), models.OutboxPending, limit)
+	if err != nil {
+		return msgs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return msgs, err
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+	})
+
+	return msgs, nil
+}
+
+// MarkOutboxMessageSent records that a message was delivered
+func (m *sqliteDBRepo) MarkOutboxMessageSent(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update outbox set status = ?1, attempts = attempts + 1, last_error = '', sent_at = ?2,
+		updated_at = ?2 where id = ?3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxSent, time.Now(), id)
+	return err
+}
+
+// RetryOutboxMessage records a failed attempt to deliver a message, which is tried again at next
+func (m *sqliteDBRepo) RetryOutboxMessage(ctx context.Context, id int, lastError string, next time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update outbox set attempts = attempts + 1, last_error = ?1, next_attempt_at = ?2,
+		updated_at = ?3 where id = ?4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, lastError, sqliteTime(next), time.Now(), id)
+	return err
+}
+
+// FailOutboxMessage records the last failed attempt to deliver a message, which is given up on
+// until an admin resends it
+func (m *sqliteDBRepo) FailOutboxMessage(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update outbox set status = ?1, attempts = attempts + 1, last_error = ?2,
+		updated_at = ?3 where id = ?4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxFailed, lastError, time.Now(), id)
+	return err
+}
+
+// UndeliveredOutboxMessages returns the pending and the failed messages, oldest first
+func (m *sqliteDBRepo) UndeliveredOutboxMessages(ctx context.Context) ([]models.OutboxMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var msgs []models.OutboxMessage
+
+	query := `select ` + outboxColumns + ` from outbox where status <> ?1 order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.OutboxSent)
+	if err != nil {
+		return msgs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return msgs, err
+	}
+
+	return msgs, nil
+}
+
+// ResendOutboxMessage makes a message due right away, with all its attempts again
+func (m *sqliteDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update outbox set status = ?1, attempts = 0, next_attempt_at = ?2, updated_at = ?3
+		where id = ?4 and status <> ?5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxPending, sqliteTime(time.Now()), time.Now(), id,
+		models.OutboxSent)
+	return err
+}
+
+// AllWebhooks returns the webhooks, oldest first
+func (m *sqliteDBRepo) AllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return queryWebhooks(ctx, m.DB)
+}
+
+// InsertWebhook adds a webhook and returns its ID and the secret its deliveries are signed with
+func (m *sqliteDBRepo) InsertWebhook(ctx context.Context, hook models.Webhook) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var newID int
+
+	stmt := `insert into webhooks (url, secret, events, created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5) returning id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		hook.URL,
+		secret,
+		strings.Join(hook.Events, ","),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	return newID, secret, nil
+}
+
+// DeleteWebhook removes a webhook with its deliveries
+func (m *sqliteDBRepo) DeleteWebhook(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from webhooks where id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// QueueWebhookEvent stores a delivery of payload for every webhook that subscribes to event,
+// to be posted right away, and returns how many it stored
+func (m *sqliteDBRepo) QueueWebhookEvent(ctx context.Context, event string, payload []byte) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	queued, err := sqliteInsertWebhookDeliveries(ctx, tx, []models.WebhookEvent{{Type: event, Payload: payload}})
+	if err != nil {
+		return 0, err
+	}
+
+	return queued, tx.Commit()
+}
+
+// sqliteInsertWebhookDeliveries stores, as part of tx, a delivery of each of events for every webhook that
+// subscribes to it, to be posted right away, and returns how many it stored
+func sqliteInsertWebhookDeliveries(ctx context.Context, tx *sql.Tx, events []models.WebhookEvent) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	hooks, err := queryWebhooks(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into webhook_deliveries (webhook_id, event, payload, status, next_attempt_at,
+			created_at, updated_at)
+			values (?1, ?2, ?3, ?4, ?5, ?6, ?7)`
+
+	queued := 0
+	for _, e := range events {
+		for _, h := range hooks {
+			if !h.Subscribes(e.Type) {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, stmt,
+				h.ID,
+				e.Type,
+				string(e.Payload),
+				models.WebhookPending,
+				sqliteTime(time.Now()),
+				time.Now(),
+				time.Now(),
+			)
+			if err != nil {
+				return 0, err
+			}
+			queued++
+		}
+	}
+
+	return queued, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due, oldest first, with
+// their webhook, and puts off their next attempt by lease so that no other worker takes them
+// meanwhile. SQLite can't return the columns of the webhooks from the update, so they are read
+// in the same transaction.
+func (m *sqliteDBRepo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return deliveries, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		update webhook_deliveries set next_attempt_at = ?1, updated_at = ?2
+		where id in (
+			select id from webhook_deliveries
+			where status = ?3 and next_attempt_at <= ?2
+			order by next_attempt_at, id
+			limit ?4)
+		returning id`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, sqliteTime(now.Add(lease)), sqliteTime(now), models.WebhookPending, limit)
+	if err != nil {
+		return deliveries, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return deliveries, err
+		}
+		ids = append(ids, fmt.Sprint(id))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+	if len(ids) == 0 {
+		return deliveries, tx.Commit()
+	}
+
+	// the ids are integers read from the database, so they can be written into the query
+	rows, err = tx.QueryContext(ctx, `select `+webhookDeliveryColumns+`
+		from webhook_deliveries d join webhooks w on (w.id = d.webhook_id)
+		where d.id in (`+strings.Join(ids, ", ")+`)
+		order by d.created_at, d.id`)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+// MarkWebhookDelivered records that a webhook accepted a delivery
+func (m *sqliteDBRepo) MarkWebhookDelivered(ctx context.Context, id, responseStatus int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set status = ?1, attempts = attempts + 1, response_status = ?2,
+		last_error = '', delivered_at = ?3, updated_at = ?3 where id = ?4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.WebhookDelivered, responseStatus, time.Now(), id)
+	return err
+}
+
+// RetryWebhookDelivery records a failed attempt to deliver an event, which is tried again at next
+func (m *sqliteDBRepo) RetryWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set attempts = attempts + 1, response_status = ?1,
+		last_error = ?2, next_attempt_at = ?3, updated_at = ?4 where id = ?5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, responseStatus, lastError, sqliteTime(next), time.Now(), id)
+	return err
+}
+
+// FailWebhookDelivery records the last failed attempt to deliver an event, which is given up on
+// until an admin resends it
+func (m *sqliteDBRepo) FailWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set status = ?1, attempts = attempts + 1, response_status = ?2,
+		last_error = ?3, updated_at = ?4 where id = ?5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.WebhookFailed, responseStatus, lastError, time.Now(), id)
+	return err
+}
+
+// RecentWebhookDeliveries returns the last limit deliveries, newest first, with their webhook
+func (m *sqliteDBRepo) RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	query := `select ` + webhookDeliveryColumns + `
+		from webhook_deliveries d join webhooks w on (w.id = d.webhook_id)
+		order by d.created_at desc, d.id desc
+		limit ?1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, nil
+}
+
+// ResendWebhookDelivery makes a delivery due right away, with all its attempts again. A delivery
+// that was accepted can be resent too, for a webhook that lost it.
+func (m *sqliteDBRepo) ResendWebhookDelivery(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set status = ?1, attempts = 0, next_attempt_at = ?2,
+		updated_at = ?3 where id = ?4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.WebhookPending, sqliteTime(time.Now()), time.Now(), id)
+	return err
+}
@@ -0,0 +1,692 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/config"
+	"github.com/taldrori/bookings/internal/driver"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/stayrules"
+)
+
+// newSQLiteTestRepo opens a fresh SQLite database, with its schema and seed data, in a
+// temporary directory
+func newSQLiteTestRepo(t *testing.T) repository.DatabaseRepo {
+	t.Helper()
+
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+	if err != nil {
+		t.Fatalf("cannot open the sqlite database: %v", err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	return NewSQLiteRepo(db.SQL, &config.Appconfig{})
+}
+
+func sqliteTestReservation(roomID int, start, end time.Time) models.Reservation {
+	return models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  start,
+		EndDate:    end,
+		TotalPrice: 10000,
+		Rooms:      []models.ReservationRoom{{RoomID: roomID, TotalPrice: 10000}},
+	}
+}
+
+func TestSQLiteReservations(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	if !repo.AllUsers(ctx) {
+		t.Error("expected the seeded admin user")
+	}
+
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 3)
+
+	id, code, err := repo.CreateReservation(ctx, sqliteTestReservation(1, start, end), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	res, err := repo.GetReservationByCode(ctx, code)
+	if err != nil {
+		t.Fatalf("GetReservationByCode: %v", err)
+	}
+	if res.ID != id || !res.StartDate.Equal(start) || !res.EndDate.Equal(end) {
+		t.Errorf("got reservation %d from %s to %s, expected %d from %s to %s",
+			res.ID, res.StartDate, res.EndDate, id, start, end)
+	}
+	if len(res.Rooms) != 1 || res.Rooms[0].RoomID != 1 {
+		t.Errorf("expected the reservation to book room 1, got %+v", res.Rooms)
+	}
+
+	// an overlapping booking of the same room is refused
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start.AddDate(0, 0, 2), end.AddDate(0, 0, 2)), nil, nil, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected ErrRoomUnavailable for an overlapping booking, got %v", err)
+	}
+
+	// the day of departure is free for the next guest
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, end, end.AddDate(0, 0, 2)), nil, nil, nil)
+	if err != nil {
+		t.Errorf("expected a back to back booking to be made, got %v", err)
+	}
+
+	available, _, err := repo.SearchAvailabilityByDatesByRoomID(ctx, start, end, 1)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityByDatesByRoomID: %v", err)
+	}
+	if available {
+		t.Error("expected room 1 to be booked")
+	}
+
+	rooms, _, err := repo.SearchAvailabilityForAllRooms(ctx, start, end, 1, nil)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityForAllRooms: %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("expected only room 2 to be available, got %+v", rooms)
+	}
+
+	// cancelling frees the room
+	err = repo.CancelReservation(ctx, id, nil, nil)
+	if err != nil {
+		t.Fatalf("CancelReservation: %v", err)
+	}
+	available, _, err = repo.SearchAvailabilityByDatesByRoomID(ctx, start, end, 1)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityByDatesByRoomID: %v", err)
+	}
+	if !available {
+		t.Error("expected room 1 to be free once the reservation is cancelled")
+	}
+
+	// the cancellation is the next version of the stay in the guest's calendar
+	res, err = repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetReservationByID: %v", err)
+	}
+	if res.Cancelled != 1 || res.CalendarSequence != 1 {
+		t.Errorf("expected a cancelled reservation with calendar sequence 1, got %d and %d", res.Cancelled, res.CalendarSequence)
+	}
+
+	// a second cancellation, like a double submit, changes nothing and sends nothing
+	err = repo.CancelReservation(ctx, id, []models.MailData{{To: "john@smith.com", Subject: "Reservation Cancelled"}},
+		[]models.WebhookEvent{{Type: models.EventReservationCancelled, Payload: []byte(`{}`)}})
+	if !errors.Is(err, repository.ErrAlreadyCancelled) {
+		t.Errorf("expected ErrAlreadyCancelled, got %v", err)
+	}
+	res, _ = repo.GetReservationByID(ctx, id)
+	if res.CalendarSequence != 1 {
+		t.Errorf("expected the calendar sequence to stay 1, got %d", res.CalendarSequence)
+	}
+	msgs, _ := repo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if len(msgs) != 0 {
+		t.Errorf("expected no email for the second cancellation, got %d", len(msgs))
+	}
+}
+
+func TestSQLiteRoomAmenities(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	room := models.Room{
+		RoomName:     "Genin Dorm",
+		Slug:         "genin-dorm",
+		MaxOccupancy: 6,
+		BaseRate:     12000,
+		Amenities:    []models.Amenity{{ID: 1}, {ID: 2}},
+	}
+	id, err := repo.InsertRoom(ctx, room)
+	if err != nil {
+		t.Fatalf("InsertRoom: %v", err)
+	}
+	amenities, err := repo.GetAmenitiesForRoom(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(amenities) != 2 {
+		t.Errorf("expected the new room to have 2 amenities, got %+v", amenities)
+	}
+
+	// an amenity that doesn't exist fails the update, which leaves the room as it was
+	room.ID = id
+	room.RoomName = "Chunin Dorm"
+	room.Amenities = []models.Amenity{{ID: 1}, {ID: 1000}}
+	err = repo.UpdateRoom(ctx, room)
+	if err == nil {
+		t.Fatal("expected UpdateRoom to fail on an unknown amenity")
+	}
+
+	saved, err := repo.GetRoomByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amenities, _ = repo.GetAmenitiesForRoom(ctx, id)
+	if saved.RoomName != "Genin Dorm" || len(amenities) != 2 {
+		t.Errorf("expected the failed update to change nothing, got %s with %+v", saved.RoomName, amenities)
+	}
+
+	room.Amenities = nil
+	err = repo.UpdateRoom(ctx, room)
+	if err != nil {
+		t.Fatalf("UpdateRoom: %v", err)
+	}
+	saved, _ = repo.GetRoomByID(ctx, id)
+	amenities, _ = repo.GetAmenitiesForRoom(ctx, id)
+	if saved.RoomName != "Chunin Dorm" || len(amenities) != 0 {
+		t.Errorf("expected the room renamed without amenities, got %s with %+v", saved.RoomName, amenities)
+	}
+}
+
+func TestSQLiteHoldsAndBlocks(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start := time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	holdID, err := repo.InsertHold(ctx, 2, start, end, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("InsertHold: %v", err)
+	}
+
+	_, err = repo.InsertHold(ctx, 2, start, end, time.Now().Add(time.Hour))
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected ErrRoomUnavailable for a second hold, got %v", err)
+	}
+
+	// the guest holding the room books it
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(2, start, end), []int{holdID}, nil, nil)
+	if err != nil {
+		t.Fatalf("expected the held room to be booked, got %v", err)
+	}
+
+	// an expired hold doesn't keep the room
+	later := end.AddDate(0, 0, 5)
+	_, err = repo.InsertHold(ctx, 1, later, later.AddDate(0, 0, 2), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("InsertHold: %v", err)
+	}
+	available, _, err := repo.SearchAvailabilityByDatesByRoomID(ctx, later, later.AddDate(0, 0, 2), 1)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityByDatesByRoomID: %v", err)
+	}
+	if !available {
+		t.Error("expected an expired hold to be ignored")
+	}
+	removed, err := repo.DeleteExpiredHolds(ctx)
+	if err != nil || removed != 1 {
+		t.Errorf("expected 1 expired hold to be deleted, got %d, %v", removed, err)
+	}
+
+	blockID, err := repo.InsertBlockForRoom(ctx, models.RoomRestriction{
+		RoomID:    1,
+		StartDate: later,
+		EndDate:   later.AddDate(0, 0, 7),
+		Reason:    "Renovation",
+		Category:  "maintenance",
+	}, nil)
+	if err != nil {
+		t.Fatalf("InsertBlockForRoom: %v", err)
+	}
+
+	block, err := repo.GetBlockByID(ctx, blockID)
+	if err != nil {
+		t.Fatalf("GetBlockByID: %v", err)
+	}
+	if block.Reason != "Renovation" || block.Category != "maintenance" || !block.EndDate.Equal(later.AddDate(0, 0, 7)) {
+		t.Errorf("unexpected block %+v", block)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, 1, later, later.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("GetRestrictionsForRoomByDate: %v", err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ID != blockID {
+		t.Errorf("expected the block in the restrictions of room 1, got %+v", restrictions)
+	}
+
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, later.AddDate(0, 0, 1), later.AddDate(0, 0, 3)), nil, nil, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected ErrRoomUnavailable for a blocked room, got %v", err)
+	}
+
+	err = repo.DeleteBlockById(ctx, blockID, nil)
+	if err != nil {
+		t.Fatalf("DeleteBlockById: %v", err)
+	}
+	_, err = repo.GetBlockByID(ctx, blockID)
+	if err == nil {
+		t.Error("expected the block to be deleted")
+	}
+}
+
+func TestSQLiteStayRules(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start := time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := repo.InsertStayRule(ctx, models.StayRule{
+		RoomID:    1,
+		StartDate: start,
+		EndDate:   start.AddDate(0, 1, 0),
+		MinNights: 3,
+	})
+	if err != nil {
+		t.Fatalf("InsertStayRule: %v", err)
+	}
+
+	available, reason, err := repo.SearchAvailabilityByDatesByRoomID(ctx, start, start.AddDate(0, 0, 1), 1)
+	if err != nil {
+		t.Fatalf("SearchAvailabilityByDatesByRoomID: %v", err)
+	}
+	if available || reason == "" {
+		t.Errorf("expected a one night stay to be refused with a reason, got %v %q", available, reason)
+	}
+
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 1)), nil, nil, nil)
+	var violation *stayrules.Violation
+	if !errors.As(err, &violation) {
+		t.Errorf("expected a stay rule violation, got %v", err)
+	}
+
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 3)), nil, nil, nil)
+	if err != nil {
+		t.Errorf("expected a three night stay to be booked, got %v", err)
+	}
+}
+
+func TestSQLiteOutbox(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	start := time.Date(2050, 9, 1, 0, 0, 0, 0, time.UTC)
+	mail := func(res models.Reservation) ([]models.MailData, error) {
+		return []models.MailData{
+			{To: res.Email, From: "info@LeafVillage.com", Subject: "Reservation Confirmation", Content: res.ConfirmationCode, Text: res.FirstName,
+				Attachments: []models.Attachment{{Name: "reservation.ics", ContentType: "text/calendar", Data: []byte("BEGIN:VCALENDAR")}}},
+			{To: "Naruto@LeafVillage.com", From: "info@LeafVillage.com", Subject: "Reservation Notification"},
+		}, nil
+	}
+
+	// a reservation whose emails can't be made isn't made either
+	_, _, err := repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil,
+		func(res models.Reservation) ([]models.MailData, error) {
+			return nil, errors.New("no template")
+		}, nil)
+	if err == nil {
+		t.Fatal("expected the reservation to fail with its emails")
+	}
+
+	_, code, err := repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil, mail, nil)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	// a reservation that isn't made puts nothing in the outbox
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil, mail, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+
+	msgs, err := repo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Mail.To != "john@smith.com" || msgs[0].Mail.Content != code || msgs[0].Mail.Text != "John" {
+		t.Fatalf("expected the 2 emails of the reservation, got %+v", msgs)
+	}
+	if a := msgs[0].Mail.Attachments; len(a) != 1 || a[0].Name != "reservation.ics" || string(a[0].Data) != "BEGIN:VCALENDAR" {
+		t.Errorf("expected the calendar attached to the confirmation, got %+v", a)
+	}
+	if a := msgs[1].Mail.Attachments; len(a) != 0 {
+		t.Errorf("expected no attachment on the notification, got %+v", a)
+	}
+
+	// claimed messages aren't handed out again during their lease
+	again, err := repo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(again) != 0 {
+		t.Errorf("expected no message to claim, got %d, %v", len(again), err)
+	}
+
+	err = repo.MarkOutboxMessageSent(ctx, msgs[0].ID)
+	if err != nil {
+		t.Fatalf("MarkOutboxMessageSent: %v", err)
+	}
+	err = repo.RetryOutboxMessage(ctx, msgs[1].ID, "connection refused", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("RetryOutboxMessage: %v", err)
+	}
+
+	again, err = repo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(again) != 1 || again[0].ID != msgs[1].ID || again[0].Attempts != 1 {
+		t.Fatalf("expected the retried message to be due, got %+v, %v", again, err)
+	}
+
+	err = repo.FailOutboxMessage(ctx, msgs[1].ID, "mailbox unavailable")
+	if err != nil {
+		t.Fatalf("FailOutboxMessage: %v", err)
+	}
+
+	undelivered, err := repo.UndeliveredOutboxMessages(ctx)
+	if err != nil {
+		t.Fatalf("UndeliveredOutboxMessages: %v", err)
+	}
+	if len(undelivered) != 1 || undelivered[0].Status != models.OutboxFailed || undelivered[0].LastError != "mailbox unavailable" {
+		t.Fatalf("expected the failed message only, got %+v", undelivered)
+	}
+
+	err = repo.ResendOutboxMessage(ctx, msgs[1].ID)
+	if err != nil {
+		t.Fatalf("ResendOutboxMessage: %v", err)
+	}
+	again, err = repo.ClaimOutboxMessages(ctx, 10, time.Minute)
+	if err != nil || len(again) != 1 || again[0].Attempts != 0 || again[0].Status != models.OutboxPending {
+		t.Errorf("expected the resent message to be due with its attempts reset, got %+v, %v", again, err)
+	}
+}
+
+func TestSQLiteCalendarFeeds(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	room, err := repo.GetRoomByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetRoomByID: %v", err)
+	}
+	if len(room.CalendarToken) != 32 {
+		t.Errorf("expected the seeded room to have a calendar token, got %q", room.CalendarToken)
+	}
+
+	token, err := repo.ResetRoomCalendarToken(ctx, 1)
+	if err != nil {
+		t.Fatalf("ResetRoomCalendarToken: %v", err)
+	}
+	room, _ = repo.GetRoomByID(ctx, 1)
+	if token == "" || room.CalendarToken != token {
+		t.Errorf("expected the new token %q, got %q", token, room.CalendarToken)
+	}
+
+	id, err := repo.InsertCalendarFeed(ctx, models.CalendarFeed{RoomID: 1, Name: "Other Site", URL: "https://other.site/1.ics"})
+	if err != nil {
+		t.Fatalf("InsertCalendarFeed: %v", err)
+	}
+
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	result, err := repo.SyncCalendarFeed(ctx, id, []models.RoomRestriction{
+		{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2), Reason: "Other Site", ExternalUID: "a"},
+	})
+	if err != nil || result.Added != 1 {
+		t.Fatalf("expected a block to be added, got %+v, %v", result, err)
+	}
+
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 1)), nil, nil, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the imported block to keep the room from being booked, got %v", err)
+	}
+
+	feeds, err := repo.GetCalendarFeedsForRoom(ctx, 1)
+	if err != nil || len(feeds) != 1 || feeds[0].Name != "Other Site" || feeds[0].LastSyncedAt.IsZero() {
+		t.Errorf("expected the synced feed, got %+v, %v", feeds, err)
+	}
+	all, err := repo.AllCalendarFeeds(ctx)
+	if err != nil || len(all) != 1 || all[0].Room.RoomName == "" {
+		t.Errorf("expected the feed with its room, got %+v, %v", all, err)
+	}
+
+	err = repo.DeleteCalendarFeed(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteCalendarFeed: %v", err)
+	}
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 1)), nil, nil, nil)
+	if err != nil {
+		t.Errorf("expected the room to be free once the feed is deleted, got %v", err)
+	}
+}
+
+func TestSQLiteAPITokens(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	id, secret, err := repo.InsertAPIToken(ctx, models.APIToken{UserID: 1, Name: "Front desk app"})
+	if err != nil {
+		t.Fatalf("InsertAPIToken: %v", err)
+	}
+
+	token, err := repo.AuthenticateAPIToken(ctx, secret)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIToken: %v", err)
+	}
+	if token.ID != id || token.User.ID != 1 || token.User.Email == "" {
+		t.Errorf("expected the token of the admin user, got %+v", token)
+	}
+
+	_, err = repo.AuthenticateAPIToken(ctx, secret+"x")
+	if err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a wrong secret, got %v", err)
+	}
+
+	tokens, err := repo.AllAPITokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0].Name != "Front desk app" || tokens[0].LastUsedAt.IsZero() {
+		t.Errorf("expected the used token, got %+v, %v", tokens, err)
+	}
+
+	err = repo.DeleteAPIToken(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteAPIToken: %v", err)
+	}
+	_, err = repo.AuthenticateAPIToken(ctx, secret)
+	if err != sql.ErrNoRows {
+		t.Errorf("expected a revoked token to be refused, got %v", err)
+	}
+}
+
+func TestSQLiteWebhooks(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	all := []string{models.EventReservationCreated, models.EventBlockAdded}
+	_, secret, err := repo.InsertWebhook(ctx, models.Webhook{URL: "https://one.site/hooks", Events: all})
+	if err != nil {
+		t.Fatalf("InsertWebhook: %v", err)
+	}
+	id, _, err := repo.InsertWebhook(ctx, models.Webhook{URL: "https://two.site/hooks", Events: all[:1]})
+	if err != nil {
+		t.Fatalf("InsertWebhook: %v", err)
+	}
+
+	hooks, err := repo.AllWebhooks(ctx)
+	if err != nil || len(hooks) != 2 || hooks[0].Secret != secret || len(hooks[0].Events) != 2 {
+		t.Errorf("expected the 2 webhooks with their secret and events, got %+v, %v", hooks, err)
+	}
+
+	queued, err := repo.QueueWebhookEvent(ctx, models.EventReservationCreated, []byte(`{"n":1}`))
+	if err != nil || queued != 2 {
+		t.Errorf("expected the event to be queued for both webhooks, got %d, %v", queued, err)
+	}
+	queued, err = repo.QueueWebhookEvent(ctx, models.EventBlockAdded, []byte(`{"n":2}`))
+	if err != nil || queued != 1 {
+		t.Errorf("expected the event to be queued for the first webhook, got %d, %v", queued, err)
+	}
+	queued, _ = repo.QueueWebhookEvent(ctx, models.EventReservationDeleted, []byte(`{"n":3}`))
+	if queued != 0 {
+		t.Errorf("expected no webhook to want the event, got %d", queued)
+	}
+
+	claimed, err := repo.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	if err != nil || len(claimed) != 3 {
+		t.Fatalf("expected 3 deliveries to be claimed, got %d, %v", len(claimed), err)
+	}
+	if string(claimed[0].Payload) != `{"n":1}` || claimed[0].Webhook.URL == "" || claimed[0].Webhook.Secret == "" {
+		t.Errorf("expected a delivery with its payload and webhook, got %+v", claimed[0])
+	}
+
+	again, _ := repo.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	if len(again) != 0 {
+		t.Errorf("expected claimed deliveries to be leased, got %d", len(again))
+	}
+
+	err = repo.DeleteWebhook(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	log, err := repo.RecentWebhookDeliveries(ctx, 10)
+	if err != nil || len(log) != 2 {
+		t.Errorf("expected the deliveries of the removed webhook to go with it, got %d, %v", len(log), err)
+	}
+}
+
+func TestSQLiteWebhookEventsWithChanges(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	_, _, err := repo.InsertWebhook(ctx, models.Webhook{URL: "https://one.site/hooks", Events: models.WebhookEvents})
+	if err != nil {
+		t.Fatalf("InsertWebhook: %v", err)
+	}
+
+	start := time.Date(2050, 5, 1, 0, 0, 0, 0, time.UTC)
+	event := func(typ, payload string) []models.WebhookEvent {
+		return []models.WebhookEvent{{Type: typ, Payload: []byte(payload)}}
+	}
+	created := func(res models.Reservation) ([]models.WebhookEvent, error) {
+		return event(models.EventReservationCreated, res.ConfirmationCode), nil
+	}
+
+	// a reservation whose events can't be made isn't made either
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil, nil,
+		func(res models.Reservation) ([]models.WebhookEvent, error) {
+			return nil, errors.New("no payload")
+		})
+	if err == nil {
+		t.Fatal("expected the reservation to fail with its events")
+	}
+
+	id, code, err := repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil, nil, created)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+
+	// a reservation that isn't made queues nothing
+	_, _, err = repo.CreateReservation(ctx, sqliteTestReservation(1, start, start.AddDate(0, 0, 2)), nil, nil, created)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+
+	err = repo.CancelReservation(ctx, id, nil, event(models.EventReservationCancelled, code))
+	if err != nil {
+		t.Fatalf("CancelReservation: %v", err)
+	}
+
+	blockID, err := repo.InsertBlockForRoom(ctx, models.RoomRestriction{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1)},
+		func(block models.RoomRestriction) ([]models.WebhookEvent, error) {
+			return event(models.EventBlockAdded, fmt.Sprint(block.ID)), nil
+		})
+	if err != nil {
+		t.Fatalf("InsertBlockForRoom: %v", err)
+	}
+
+	err = repo.UpdateBlock(ctx, models.RoomRestriction{ID: blockID, StartDate: start, EndDate: start.AddDate(0, 0, 2)},
+		event(models.EventBlockUpdated, fmt.Sprint(blockID)))
+	if err != nil {
+		t.Fatalf("UpdateBlock: %v", err)
+	}
+
+	err = repo.DeleteBlockById(ctx, blockID, event(models.EventBlockRemoved, fmt.Sprint(blockID)))
+	if err != nil {
+		t.Fatalf("DeleteBlockById: %v", err)
+	}
+
+	deliveries, err := repo.RecentWebhookDeliveries(ctx, 10)
+	if err != nil {
+		t.Fatalf("RecentWebhookDeliveries: %v", err)
+	}
+
+	expected := []string{
+		models.EventBlockRemoved + " " + fmt.Sprint(blockID),
+		models.EventBlockUpdated + " " + fmt.Sprint(blockID),
+		models.EventBlockAdded + " " + fmt.Sprint(blockID),
+		models.EventReservationCancelled + " " + code,
+		models.EventReservationCreated + " " + code,
+	}
+	var got []string
+	for _, x := range deliveries {
+		got = append(got, x.Event+" "+string(x.Payload))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected the deliveries %q, got %q", expected, got)
+	}
+}
+
+func TestSQLiteTakenNights(t *testing.T) {
+	repo := newSQLiteTestRepo(t)
+	ctx := context.Background()
+
+	day := func(d int) time.Time {
+		return time.Date(2050, 4, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	_, _, err := repo.CreateReservation(ctx, sqliteTestReservation(1, day(3), day(5)), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateReservation: %v", err)
+	}
+	_, err = repo.InsertBlockForRoom(ctx, models.RoomRestriction{RoomID: 2, StartDate: day(28), EndDate: day(35)}, nil)
+	if err != nil {
+		t.Fatalf("InsertBlockForRoom: %v", err)
+	}
+	_, err = repo.InsertHold(ctx, 2, day(10), day(11), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("InsertHold: %v", err)
+	}
+	_, err = repo.InsertHold(ctx, 1, day(20), day(22), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("InsertHold: %v", err)
+	}
+
+	nights, err := repo.TakenNights(ctx, day(1), day(31), 0)
+	if err != nil {
+		t.Fatalf("TakenNights: %v", err)
+	}
+
+	format := func(days []time.Time) []string {
+		var out []string
+		for _, d := range days {
+			out = append(out, d.Format("2006-01-02"))
+		}
+		return out
+	}
+
+	// the expired hold is ignored, and the block is cut at the end of the month
+	expected := map[int]string{
+		1: "2050-04-03 2050-04-04",
+		2: "2050-04-10 2050-04-28 2050-04-29 2050-04-30",
+	}
+	if len(nights) != len(expected) {
+		t.Errorf("expected the nights of 2 rooms, got %v", nights)
+	}
+	for id, want := range expected {
+		if got := fmt.Sprint(format(nights[id])); got != "["+want+"]" {
+			t.Errorf("expected room %d to be taken %s, got %s", id, want, got)
+		}
+	}
+
+	nights, err = repo.TakenNights(ctx, day(1), day(31), 1)
+	if err != nil {
+		t.Fatalf("TakenNights: %v", err)
+	}
+	if len(nights) != 1 || len(nights[1]) != 2 {
+		t.Errorf("expected the nights of room 1 only, got %v", nights)
+	}
+}
@@ -1,36 +1,99 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/stayrules"
 )
 
-func (m *testDBRepo) AllUsers() bool {
+// a stay starting on 2045-01-01 is shorter than the minimum stay; searches for all rooms
+// leave room 2 out for it
+var stayRuleTestDate = time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const stayRuleTestReason = "Stays arriving on 01/01/2045 must be at least 3 nights"
+
+func (m *testDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	// if the room id is 2, then fail; otherwise, pass
-	if res.RoomID == 2 {
-		return 0, errors.New("some error)")
+// CreateReservation inserts a reservation and its room restriction into the database
+func (m *testDBRepo) CreateReservation(ctx context.Context, res models.Reservation, holdIDs []int, mail repository.MailFunc, events repository.EventsFunc) (int, string, error) {
+	// if any of the rooms is 2 or 1000, then fail
+	for _, x := range res.Rooms {
+		if x.RoomID == 2 || x.RoomID == 1000 {
+			return 0, "", errors.New("some error")
+		}
 	}
-	return 1, nil
+
+	// a stay starting on 2070-01-01 was just booked by someone else
+	if res.StartDate.Equal(time.Date(2070, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, "", repository.ErrRoomUnavailable
+	}
+
+	if res.StartDate.Equal(stayRuleTestDate) {
+		return 0, "", &stayrules.Violation{Reason: stayRuleTestReason}
+	}
+
+	res.ID = 1
+	res.ConfirmationCode = "LV-TEST-01"
+
+	if events != nil {
+		_, err := events(res)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if mail != nil {
+		msgs, err := mail(res)
+		if err != nil {
+			return 0, "", err
+		}
+		m.send(msgs)
+	}
+
+	return 1, "LV-TEST-01", nil
 }
 
-// InsertRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	if r.RoomID == 1000 {
-		return errors.New("some error")
+// send delivers the emails of a change once it is made
+func (m *testDBRepo) send(mail []models.MailData) {
+	if m.Mailer == nil {
+		return
+	}
+	for _, x := range mail {
+		_ = m.Mailer.Send(x)
+	}
+}
+
+// InsertHold holds a room for a guest who is filling in the reservation form
+func (m *testDBRepo) InsertHold(ctx context.Context, roomID int, start, end, expires time.Time) (int, error) {
+	// a stay starting on 2070-01-01 was just booked by someone else
+	if start.Equal(time.Date(2070, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, repository.ErrRoomUnavailable
 	}
+	return 1, nil
+}
+
+// DeleteHold releases a hold
+func (m *testDBRepo) DeleteHold(ctx context.Context, id int) error {
 	return nil
 }
 
+// DeleteExpiredHolds deletes the holds that have expired
+func (m *testDBRepo) DeleteExpiredHolds(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, string, error) {
 	// set up a test time
 	layout := "01/02/2006"
 	str := "12/31/2049"
@@ -46,22 +109,27 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 	}
 
 	if start == testDateToFail {
-		return false, errors.New("some error")
+		return false, "", errors.New("some error")
 	}
 
 	// if the start date is after 2049-12-31, then return false,
 	// indicating no availability;
 	if start.After(t) {
-		return false, nil
+		return false, "", nil
+	}
+
+	if start.Equal(stayRuleTestDate) {
+		return false, stayRuleTestReason, nil
 	}
 
 	// otherwise, we have availability
-	return true, nil
+	return true, "", nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenityIDs []int) ([]models.Room, []models.RoomExclusion, error) {
 	var rooms []models.Room
+	var exclusions []models.RoomExclusion
 
 	// if the start date is after 2049-12-31, then return empty slice,
 	// indicating no rooms are available;
@@ -78,11 +146,19 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	}
 
 	if start == testDateToFail {
-		return rooms, errors.New("some error")
+		return rooms, exclusions, errors.New("some error")
 	}
 
-	if start.After(t) {
-		return rooms, nil
+	// no room takes more than 4 guests
+	if start.After(t) || guests > 4 {
+		return rooms, exclusions, nil
+	}
+
+	if start.Equal(stayRuleTestDate) {
+		exclusions = append(exclusions, models.RoomExclusion{
+			Room:   models.Room{ID: 2},
+			Reason: stayRuleTestReason,
+		})
 	}
 
 	// otherwise, put an entry into the slice, indicating that some room is
@@ -92,74 +168,521 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	}
 	rooms = append(rooms, room)
 
-	return rooms, nil
+	return rooms, exclusions, nil
 }
 
 // GetRoomByID gets a room by id
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
+	// room 99 was deleted
+	if id == 99 {
+		return room, sql.ErrNoRows
+	}
 	if id > 2 {
 		return room, errors.New("some error")
 	}
+	room.ID = id
+	room.CalendarToken = fmt.Sprintf("room-%d-token", id)
+	return room, nil
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	var room models.Room
+	switch slug {
+	case "jonins-quarters":
+		room = models.Room{ID: 1, RoomName: "Jonins Quarters", Slug: slug}
+	case "hokages-suite":
+		room = models.Room{ID: 2, RoomName: "Hokages Suite", Slug: slug}
+	default:
+		return room, sql.ErrNoRows
+	}
 	return room, nil
 }
 
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+// InsertRoom inserts a room into the database
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	// if the room is named "fail", then fail; otherwise, pass
+	if room.RoomName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	return nil
+}
+
+// DeleteRoom deletes a room from the database
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) AllAmenities(ctx context.Context) ([]models.Amenity, error) {
+	amenities := []models.Amenity{
+		{ID: 1, AmenityName: "Wi-Fi"},
+		{ID: 2, AmenityName: "Balcony"},
+	}
+	return amenities, nil
+}
+
+func (m *testDBRepo) InsertAmenity(ctx context.Context, a models.Amenity) (int, error) {
+	return 3, nil
+}
+
+func (m *testDBRepo) DeleteAmenity(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetAmenitiesForRoom(ctx context.Context, roomID int) ([]models.Amenity, error) {
+	var amenities []models.Amenity
+	return amenities, nil
+}
+
+func (m *testDBRepo) GetSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	var rates []models.SeasonalRate
+	return rates, nil
+}
+
+func (m *testDBRepo) InsertSeasonalRate(ctx context.Context, rate models.SeasonalRate) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetStayRulesForRoom(ctx context.Context, roomID int) ([]models.StayRule, error) {
+	var rules []models.StayRule
+	return rules, nil
+}
+
+func (m *testDBRepo) InsertStayRule(ctx context.Context, rule models.StayRule) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetRateModifiersForRoom(ctx context.Context, roomID int) ([]models.RateModifier, error) {
+	var modifiers []models.RateModifier
+	return modifiers, nil
+}
+
+func (m *testDBRepo) UpdateRateModifiersForRoom(ctx context.Context, roomID int, modifiers []models.RateModifier) error {
+	return nil
+}
+
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	var u models.User
 	return u, nil
 }
 
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	return 1, "", nil
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+// testAPIToken is the secret of the API token of the admin user
+const testAPIToken = "staff-token"
+
+func (m *testDBRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	return []models.APIToken{{
+		ID:     1,
+		UserID: 1,
+		Name:   "Front desk app",
+		User:   models.User{ID: 1, FirstName: "Naruto", LastName: "Uzumaki", Email: "Naruto@LeafVillage.com"},
+	}}, nil
+}
+
+func (m *testDBRepo) InsertAPIToken(ctx context.Context, token models.APIToken) (int, string, error) {
+	return 2, "bk_new-token", nil
+}
+
+// AuthenticateAPIToken knows the token staff-token, of the admin user
+func (m *testDBRepo) AuthenticateAPIToken(ctx context.Context, secret string) (models.APIToken, error) {
+	if secret != testAPIToken {
+		return models.APIToken{}, sql.ErrNoRows
+	}
+	tokens, _ := m.AllAPITokens(ctx)
+	return tokens[0], nil
+}
+
+func (m *testDBRepo) DeleteAPIToken(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
 
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
 	var reservation models.Reservation
+
+	// reservation 1 is upcoming, reservation 2 is cancelled, the rest don't exist
+	if id != 1 && id != 2 {
+		return reservation, sql.ErrNoRows
+	}
+
+	reservation = models.Reservation{
+		ID:               id,
+		ConfirmationCode: fmt.Sprintf("LV-TEST-%02d", id),
+		FirstName:        "Tal",
+		LastName:         "Drori",
+		Email:            "tal@drori.com",
+		StartDate:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:          time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:           1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "Jonin's Quarters",
+		},
+		Rooms: []models.ReservationRoom{
+			{
+				ID:            id,
+				ReservationID: id,
+				RoomID:        1,
+				Room: models.Room{
+					ID:       1,
+					RoomName: "Jonin's Quarters",
+				},
+			},
+		},
+	}
+	if id == 2 {
+		reservation.Cancelled = 1
+	}
+
 	return reservation, nil
 }
 
-func (m *testDBRepo) UpdateReservation(res models.Reservation) error {
+func (m *testDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	switch strings.ToUpper(strings.TrimSpace(code)) {
+	case "LV-TEST-01":
+		return m.GetReservationByID(ctx, 1)
+	case "LV-TEST-02":
+		return m.GetReservationByID(ctx, 2)
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) UpdateReservation(ctx context.Context, res models.Reservation, events []models.WebhookEvent) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
+func (m *testDBRepo) DeleteReservation(ctx context.Context, id int, events []models.WebhookEvent) error {
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int, events []models.WebhookEvent) error {
 	return nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
-	var rooms []models.Room
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData, events []models.WebhookEvent) error {
+	// a stay starting on 2070-01-01 was just booked by someone else
+	if res.StartDate.Equal(time.Date(2070, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return repository.ErrRoomUnavailable
+	}
+	if res.StartDate.Equal(stayRuleTestDate) {
+		return &stayrules.Violation{Reason: stayRuleTestReason}
+	}
+	m.send(mail)
+	return nil
+}
+
+func (m *testDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData, events []models.WebhookEvent) error {
+	// reservation 2 is cancelled
+	if id == 2 {
+		return repository.ErrAlreadyCancelled
+	}
+	m.send(mail)
+	return nil
+}
+
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	rooms := []models.Room{
+		{ID: 2, RoomName: "Hokages Suite", Slug: "hokages-suite", MaxOccupancy: 4, BaseRate: 20000},
+		{ID: 1, RoomName: "Jonins Quarters", Slug: "jonins-quarters", MaxOccupancy: 2, BaseRate: 10000},
+	}
 
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetRestrictionsForRoomByDate knows, for room 1, a reservation for the first two nights
+// asked for, then a hold and a block imported from another booking site. Room 2 has a stay from
+// March 30, 2050 that checks out on April 3, the day an owner block begins.
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
+	if roomID == 2 {
+		checkout := time.Date(2050, 4, 3, 0, 0, 0, 0, time.UTC)
+		if start.Before(checkout.AddDate(0, 0, 3)) && end.After(checkout.AddDate(0, 0, -4)) {
+			restrictions = append(restrictions,
+				models.RoomRestriction{ID: 4, RoomID: 2, ReservationID: 1, RestrictionID: models.RestrictionReservation, StartDate: checkout.AddDate(0, 0, -4), EndDate: checkout},
+				models.RoomRestriction{ID: 5, RoomID: 2, RestrictionID: models.RestrictionOwnerBlock, StartDate: checkout, EndDate: checkout.AddDate(0, 0, 3),
+					Reason: "Painting", Category: "maintenance"},
+			)
+		}
+		return restrictions, nil
+	}
+	if roomID != 1 {
+		return restrictions, nil
+	}
+
+	restrictions = append(restrictions,
+		models.RoomRestriction{ID: 1, RoomID: 1, ReservationID: 1, RestrictionID: models.RestrictionReservation, StartDate: start, EndDate: start.AddDate(0, 0, 2)},
+		models.RoomRestriction{ID: 2, RoomID: 1, RestrictionID: models.RestrictionHold, StartDate: start.AddDate(0, 0, 3), EndDate: start.AddDate(0, 0, 4)},
+		models.RoomRestriction{ID: 3, RoomID: 1, RestrictionID: models.RestrictionExternal, StartDate: start.AddDate(0, 0, 5), EndDate: start.AddDate(0, 0, 8),
+			Reason: "Other Site", FeedID: 1, ExternalUID: "abc-1@other.site"},
+	)
 
 	return restrictions, nil
 }
 
-func (m *testDBRepo) InsertBlockForRoom(id int, start_date time.Time) error {
+// TakenNights knows, for room 1, the nights of the restrictions of GetRestrictionsForRoomByDate,
+// and fails for a month starting on 2060-01-01
+func (m *testDBRepo) TakenNights(ctx context.Context, start, end time.Time, roomID int) (map[int][]time.Time, error) {
+	if start.Equal(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return nil, errors.New("some error")
+	}
+
+	nights := make(map[int][]time.Time)
+	if roomID != 0 && roomID != 1 {
+		return nights, nil
+	}
+
+	for _, n := range []int{0, 1, 3, 5, 6, 7} {
+		nights[1] = append(nights[1], start.AddDate(0, 0, n))
+	}
+	return nights, nil
+}
+
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, block models.RoomRestriction, events repository.BlockEventsFunc) (int, error) {
+	// a stay starting on 2070-01-01 was just booked by someone else
+	if block.StartDate.Equal(time.Date(2070, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	if events != nil {
+		block.ID = 1
+		_, err := events(block)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 1, nil
+}
+
+// GetBlockByID knows block 1, a renovation of room 1 in March 2050
+func (m *testDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	if id != 1 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+	return models.RoomRestriction{
+		ID:            1,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 3, 15, 0, 0, 0, 0, time.UTC),
+		Reason:        "Renovation",
+		Category:      "maintenance",
+		Room:          models.Room{ID: 1, RoomName: "Jonin's Quarters"},
+	}, nil
+}
+
+func (m *testDBRepo) UpdateBlock(ctx context.Context, block models.RoomRestriction, events []models.WebhookEvent) error {
+	// a stay starting on 2070-01-01 was just booked by someone else
+	if block.StartDate.Equal(time.Date(2070, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteBlockById(ctx context.Context, id int, events []models.WebhookEvent) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteBlockById(id int) error {
+func (m *testDBRepo) ResetRoomCalendarToken(ctx context.Context, roomID int) (string, error) {
+	return "new-token", nil
+}
+
+func (m *testDBRepo) AllCalendarFeeds(ctx context.Context) ([]models.CalendarFeed, error) {
+	return m.GetCalendarFeedsForRoom(ctx, 1)
+}
+
+// GetCalendarFeedsForRoom knows feed 1, of room 1, which failed its last sync
+func (m *testDBRepo) GetCalendarFeedsForRoom(ctx context.Context, roomID int) ([]models.CalendarFeed, error) {
+	var feeds []models.CalendarFeed
+	if roomID == 1 {
+		feed, _ := m.GetCalendarFeedByID(ctx, 1)
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
+
+func (m *testDBRepo) GetCalendarFeedByID(ctx context.Context, id int) (models.CalendarFeed, error) {
+	if id != 1 {
+		return models.CalendarFeed{}, sql.ErrNoRows
+	}
+	return models.CalendarFeed{
+		ID:           1,
+		RoomID:       1,
+		Name:         "Other Site",
+		URL:          "https://other.site/calendar/1.ics",
+		LastSyncedAt: time.Now().Add(-time.Hour),
+		LastError:    "not an iCalendar file",
+		Room:         models.Room{ID: 1, RoomName: "Jonin's Quarters"},
+	}, nil
+}
+
+func (m *testDBRepo) InsertCalendarFeed(ctx context.Context, feed models.CalendarFeed) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteCalendarFeed(ctx context.Context, id int) error {
 	return nil
-}
\ No newline at end of file
+}
+
+func (m *testDBRepo) SyncCalendarFeed(ctx context.Context, id int, blocks []models.RoomRestriction) (models.CalendarFeedSync, error) {
+	return models.CalendarFeedSync{Added: len(blocks)}, nil
+}
+
+func (m *testDBRepo) SetCalendarFeedError(ctx context.Context, id int, lastError string) error {
+	return nil
+}
+
+func (m *testDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) MarkOutboxMessageSent(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) RetryOutboxMessage(ctx context.Context, id int, lastError string, next time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) FailOutboxMessage(ctx context.Context, id int, lastError string) error {
+	return nil
+}
+
+// UndeliveredOutboxMessages returns a pending and a failed message
+func (m *testDBRepo) UndeliveredOutboxMessages(ctx context.Context) ([]models.OutboxMessage, error) {
+	return []models.OutboxMessage{
+		{
+			ID:            1,
+			Mail:          models.MailData{To: "john@smith.com", Subject: "Reservation Confirmation"},
+			Status:        models.OutboxPending,
+			Attempts:      2,
+			NextAttemptAt: time.Now().Add(time.Minute),
+			LastError:     "connection refused",
+		},
+		{
+			ID:        2,
+			Mail:      models.MailData{To: "Naruto@LeafVillage.com", Subject: "Reservation Notification"},
+			Status:    models.OutboxFailed,
+			Attempts:  8,
+			LastError: "connection refused",
+		},
+	}, nil
+}
+
+// ResendOutboxMessage fails for message 1000
+func (m *testDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// AllWebhooks knows webhook 1, which is told about new and cancelled reservations
+func (m *testDBRepo) AllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return []models.Webhook{
+		{
+			ID:        1,
+			URL:       "https://partner.site/hooks/bookings",
+			Secret:    "whsec_test",
+			Events:    []string{models.EventReservationCreated, models.EventReservationCancelled},
+			CreatedAt: time.Now().Add(-24 * time.Hour),
+		},
+	}, nil
+}
+
+func (m *testDBRepo) InsertWebhook(ctx context.Context, hook models.Webhook) (int, string, error) {
+	return 2, "whsec_new", nil
+}
+
+func (m *testDBRepo) DeleteWebhook(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *testDBRepo) QueueWebhookEvent(ctx context.Context, event string, payload []byte) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) MarkWebhookDelivered(ctx context.Context, id, responseStatus int) error {
+	return nil
+}
+
+func (m *testDBRepo) RetryWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) FailWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string) error {
+	return nil
+}
+
+// RecentWebhookDeliveries returns a delivered and a failed delivery to webhook 1
+func (m *testDBRepo) RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	hooks, _ := m.AllWebhooks(ctx)
+	return []models.WebhookDelivery{
+		{
+			ID:             2,
+			WebhookID:      1,
+			Event:          models.EventReservationCancelled,
+			Payload:        []byte(`{"id":"evt_2","type":"reservation.cancelled"}`),
+			Status:         models.WebhookFailed,
+			Attempts:       8,
+			ResponseStatus: 503,
+			LastError:      "503 Service Unavailable",
+			CreatedAt:      time.Now().Add(-time.Hour),
+			Webhook:        hooks[0],
+		},
+		{
+			ID:             1,
+			WebhookID:      1,
+			Event:          models.EventReservationCreated,
+			Payload:        []byte(`{"id":"evt_1","type":"reservation.created"}`),
+			Status:         models.WebhookDelivered,
+			Attempts:       1,
+			ResponseStatus: 200,
+			DeliveredAt:    time.Now().Add(-2 * time.Hour),
+			CreatedAt:      time.Now().Add(-2 * time.Hour),
+			Webhook:        hooks[0],
+		},
+	}, nil
+}
+
+// ResendWebhookDelivery fails for delivery 1000
+func (m *testDBRepo) ResendWebhookDelivery(ctx context.Context, id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
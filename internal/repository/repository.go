@@ -1,29 +1,104 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/taldrori/bookings/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room is already taken for some of the requested dates
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrAlreadyCancelled is returned when a reservation was cancelled before, maybe by a request
+// made at the same time
+var ErrAlreadyCancelled = errors.New("reservation is already cancelled")
+
+// MailFunc builds the emails about a reservation once it is stored, with its ID and
+// ConfirmationCode set. An error undoes the reservation.
+type MailFunc func(res models.Reservation) ([]models.MailData, error)
+
+// EventsFunc builds the webhook events about a reservation once it is stored, with its ID and
+// ConfirmationCode set. An error undoes the reservation.
+type EventsFunc func(res models.Reservation) ([]models.WebhookEvent, error)
+
+// BlockEventsFunc builds the webhook events about an owner block once it is stored, with its
+// ID set. An error undoes the block.
+type BlockEventsFunc func(block models.RoomRestriction) ([]models.WebhookEvent, error)
+
+// DatabaseRepo stores the data of the application. Every method takes the context of the
+// request it serves, so that its queries are cancelled when the client goes away.
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(res models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, start_date time.Time) error
-	DeleteBlockById(id int) error
+	AllUsers(ctx context.Context) bool
+	CreateReservation(ctx context.Context, res models.Reservation, holdIDs []int, mail MailFunc, events EventsFunc) (int, string, error)
+	InsertHold(ctx context.Context, roomID int, start, end, expires time.Time) (int, error)
+	DeleteHold(ctx context.Context, id int) error
+	DeleteExpiredHolds(ctx context.Context) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, string, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time, guests int, amenityIDs []int) ([]models.Room, []models.RoomExclusion, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	AllAmenities(ctx context.Context) ([]models.Amenity, error)
+	InsertAmenity(ctx context.Context, a models.Amenity) (int, error)
+	DeleteAmenity(ctx context.Context, id int) error
+	GetAmenitiesForRoom(ctx context.Context, roomID int) ([]models.Amenity, error)
+	GetSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(ctx context.Context, rate models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(ctx context.Context, id int) error
+	GetStayRulesForRoom(ctx context.Context, roomID int) ([]models.StayRule, error)
+	InsertStayRule(ctx context.Context, rule models.StayRule) (int, error)
+	DeleteStayRule(ctx context.Context, id int) error
+	GetRateModifiersForRoom(ctx context.Context, roomID int) ([]models.RateModifier, error)
+	UpdateRateModifiersForRoom(ctx context.Context, roomID int, modifiers []models.RateModifier) error
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	AllAPITokens(ctx context.Context) ([]models.APIToken, error)
+	InsertAPIToken(ctx context.Context, token models.APIToken) (int, string, error)
+	AuthenticateAPIToken(ctx context.Context, secret string) (models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, id int) error
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, res models.Reservation, events []models.WebhookEvent) error
+	DeleteReservation(ctx context.Context, id int, events []models.WebhookEvent) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int, events []models.WebhookEvent) error
+	ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData, events []models.WebhookEvent) error
+	CancelReservation(ctx context.Context, id int, mail []models.MailData, events []models.WebhookEvent) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	TakenNights(ctx context.Context, start, end time.Time, roomID int) (map[int][]time.Time, error)
+	InsertBlockForRoom(ctx context.Context, block models.RoomRestriction, events BlockEventsFunc) (int, error)
+	GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error)
+	UpdateBlock(ctx context.Context, block models.RoomRestriction, events []models.WebhookEvent) error
+	DeleteBlockById(ctx context.Context, id int, events []models.WebhookEvent) error
+	ResetRoomCalendarToken(ctx context.Context, roomID int) (string, error)
+	AllCalendarFeeds(ctx context.Context) ([]models.CalendarFeed, error)
+	GetCalendarFeedsForRoom(ctx context.Context, roomID int) ([]models.CalendarFeed, error)
+	GetCalendarFeedByID(ctx context.Context, id int) (models.CalendarFeed, error)
+	InsertCalendarFeed(ctx context.Context, feed models.CalendarFeed) (int, error)
+	DeleteCalendarFeed(ctx context.Context, id int) error
+	SyncCalendarFeed(ctx context.Context, id int, blocks []models.RoomRestriction) (models.CalendarFeedSync, error)
+	SetCalendarFeedError(ctx context.Context, id int, lastError string) error
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkOutboxMessageSent(ctx context.Context, id int) error
+	RetryOutboxMessage(ctx context.Context, id int, lastError string, next time.Time) error
+	FailOutboxMessage(ctx context.Context, id int, lastError string) error
+	UndeliveredOutboxMessages(ctx context.Context) ([]models.OutboxMessage, error)
+	ResendOutboxMessage(ctx context.Context, id int) error
+	AllWebhooks(ctx context.Context) ([]models.Webhook, error)
+	InsertWebhook(ctx context.Context, hook models.Webhook) (int, string, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	QueueWebhookEvent(ctx context.Context, event string, payload []byte) (int, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id, responseStatus int) error
+	RetryWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error
+	FailWebhookDelivery(ctx context.Context, id, responseStatus int, lastError string) error
+	RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	ResendWebhookDelivery(ctx context.Context, id int) error
 }
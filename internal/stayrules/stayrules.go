@@ -0,0 +1,101 @@
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+// Violation is returned when a stay breaks one of a room's stay rules
+type Violation struct {
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// Check returns a *Violation when a stay from start to end breaks one of rules. Minimum and
+// maximum stays, closed to arrival and arrival weekdays apply to stays that arrive on a date
+// covered by a rule; closed to departure applies to stays that leave on one.
+func Check(rules []models.StayRule, start, end time.Time) error {
+	start = truncateToDay(start)
+	end = truncateToDay(end)
+	nights := int(end.Sub(start).Hours()/24 + 0.5)
+	arrival := start.Format("01/02/2006")
+
+	for _, x := range rules {
+		if covers(x, start) {
+			if x.ClosedToArrival {
+				return &Violation{fmt.Sprintf("No arrivals on %s", arrival)}
+			}
+			if x.MinNights > 0 && nights < x.MinNights {
+				return &Violation{fmt.Sprintf("Stays arriving on %s must be at least %d nights", arrival, x.MinNights)}
+			}
+			if x.MaxNights > 0 && nights > x.MaxNights {
+				return &Violation{fmt.Sprintf("Stays arriving on %s can be at most %d nights", arrival, x.MaxNights)}
+			}
+			if len(x.ArrivalWeekdays) > 0 && !hasWeekday(x.ArrivalWeekdays, start.Weekday()) {
+				return &Violation{fmt.Sprintf("Stays around %s must arrive on a %s", arrival, weekdayList(x.ArrivalWeekdays))}
+			}
+		}
+		if covers(x, end) && x.ClosedToDeparture {
+			return &Violation{fmt.Sprintf("No departures on %s", end.Format("01/02/2006"))}
+		}
+	}
+
+	return nil
+}
+
+// WeekdayMask packs weekdays into a bit mask, for storage
+func WeekdayMask(days []time.Weekday) int {
+	mask := 0
+	for _, d := range days {
+		mask |= 1 << uint(d)
+	}
+	return mask
+}
+
+// Weekdays unpacks a bit mask made by WeekdayMask
+func Weekdays(mask int) []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if mask&(1<<uint(d)) != 0 {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// covers reports whether the rule applies to the date d
+func covers(rule models.StayRule, d time.Time) bool {
+	return !d.Before(truncateToDay(rule.StartDate)) && d.Before(truncateToDay(rule.EndDate))
+}
+
+func hasWeekday(days []time.Weekday, d time.Weekday) bool {
+	for _, x := range days {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+// weekdayList lists days for people, e.g. "Friday or Saturday"
+func weekdayList(days []time.Weekday) string {
+	names := make([]string, len(days))
+	for i, d := range days {
+		names[i] = d.String()
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func truncateToDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
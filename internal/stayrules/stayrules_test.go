@@ -0,0 +1,70 @@
+package stayrules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestCheck(t *testing.T) {
+	rules := []models.StayRule{
+		// summer: at least 3 nights, at most 14, arriving on a Friday or a Saturday
+		{StartDate: date("2050-07-01"), EndDate: date("2050-09-01"), MinNights: 3, MaxNights: 14,
+			ArrivalWeekdays: []time.Weekday{time.Friday, time.Saturday}},
+		// the festival: nobody arrives or leaves
+		{StartDate: date("2050-08-10"), EndDate: date("2050-08-12"), ClosedToArrival: true, ClosedToDeparture: true},
+	}
+
+	var tests = []struct {
+		name  string
+		start string
+		end   string
+		valid bool
+	}{
+		// 2050-07-01 is a Friday
+		{"outside of the rules", "2050-06-01", "2050-06-02", true},
+		{"long enough, on a Friday", "2050-07-01", "2050-07-04", true},
+		{"too short", "2050-07-01", "2050-07-03", false},
+		{"too long", "2050-07-01", "2050-07-20", false},
+		{"arriving on a Monday", "2050-07-04", "2050-07-08", false},
+		{"arriving before the summer", "2050-06-28", "2050-07-01", true},
+		{"leaving during the festival", "2050-08-05", "2050-08-11", false},
+		{"leaving when the festival is over", "2050-08-05", "2050-08-12", true},
+	}
+
+	for _, e := range tests {
+		err := Check(rules, date(e.start), date(e.end))
+		if e.valid && err != nil {
+			t.Errorf("%s: unexpected violation: %s", e.name, err)
+		}
+		if !e.valid {
+			if _, ok := err.(*Violation); !ok {
+				t.Errorf("%s: expected a violation but got %v", e.name, err)
+			}
+		}
+	}
+}
+
+func TestWeekdayMask(t *testing.T) {
+	days := []time.Weekday{time.Sunday, time.Friday, time.Saturday}
+
+	got := Weekdays(WeekdayMask(days))
+	if len(got) != len(days) {
+		t.Fatalf("expected %v but got %v", days, got)
+	}
+	for i := range days {
+		if got[i] != days[i] {
+			t.Errorf("expected %v but got %v", days, got)
+		}
+	}
+
+	if Weekdays(0) != nil {
+		t.Error("expected no weekdays for an empty mask")
+	}
+}
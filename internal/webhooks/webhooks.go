@@ -0,0 +1,171 @@
+// Package webhooks posts the events of the site to the webhooks the admins set up. Every
+// delivery is signed with the secret of its webhook, and made by a dispatcher of the dispatch
+// package.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/taldrori/bookings/internal/dispatch"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+)
+
+// The headers of a delivery. The signature is of the timestamp and the body, so that a
+// receiver can refuse old deliveries played again.
+const (
+	EventHeader     = "X-Bookings-Event"
+	DeliveryHeader  = "X-Bookings-Delivery"
+	TimestampHeader = "X-Bookings-Timestamp"
+	SignatureHeader = "X-Bookings-Signature"
+)
+
+// Event is the body of a delivery. Data is the reservation or the block the event is about,
+// as the API shows it.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Payload encodes an event of eventType about data, with a new random ID that receivers can
+// use to notice deliveries they already had
+func Payload(eventType string, data interface{}) ([]byte, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Event{
+		ID:        "evt_" + hex.EncodeToString(b),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Data:      data,
+	})
+}
+
+// Sign returns the signature of body sent at timestamp, in Unix seconds: sha256= and the hex
+// HMAC-SHA256, keyed with secret, of the timestamp, a dot and the body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at timestamp, in constant time
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Dispatcher makes the due deliveries of the webhooks
+type Dispatcher struct {
+	*dispatch.Dispatcher
+
+	DB     repository.DatabaseRepo
+	Client *http.Client
+}
+
+// New returns a dispatcher of the deliveries of db with the default settings. A webhook has
+// 10 seconds to answer.
+func New(db repository.DatabaseRepo) *Dispatcher {
+	d := &Dispatcher{DB: db, Client: &http.Client{Timeout: 10 * time.Second}}
+	d.Dispatcher = dispatch.New(d)
+	return d
+}
+
+// Claim claims the due deliveries
+func (d *Dispatcher) Claim(ctx context.Context, limit int, lease time.Duration) ([]dispatch.Job, error) {
+	deliveries, err := d.DB.ClaimWebhookDeliveries(ctx, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks: %w", err)
+	}
+
+	jobs := make([]dispatch.Job, 0, len(deliveries))
+	for _, x := range deliveries {
+		jobs = append(jobs, &delivery{d: d, x: x})
+	}
+	return jobs, nil
+}
+
+// delivery is the job of posting an event to a webhook. A delivery that fails its last attempt
+// can be resent from the delivery log.
+type delivery struct {
+	d *Dispatcher
+	x models.WebhookDelivery
+
+	// status is what the webhook answered the attempt with, or 0 when it didn't answer
+	status int
+}
+
+func (j *delivery) Attempts() int {
+	return j.x.Attempts
+}
+
+func (j *delivery) Do(ctx context.Context) error {
+	var err error
+	j.status, err = j.d.post(ctx, j.x)
+	return err
+}
+
+func (j *delivery) Done(ctx context.Context) error {
+	return j.d.DB.MarkWebhookDelivered(ctx, j.x.ID, j.status)
+}
+
+func (j *delivery) Retry(ctx context.Context, err error, next time.Time) error {
+	return j.d.DB.RetryWebhookDelivery(ctx, j.x.ID, j.status, err.Error(), next)
+}
+
+func (j *delivery) Fail(ctx context.Context, err error) error {
+	return j.d.DB.FailWebhookDelivery(ctx, j.x.ID, j.status, err.Error())
+}
+
+func (j *delivery) String() string {
+	return fmt.Sprintf("the %s event to %s", j.x.Event, j.x.Webhook.URL)
+}
+
+// post sends the payload of a delivery to its webhook, signed with the webhook's secret. It
+// returns the status the webhook answered with, or 0 when it didn't answer. Any status but
+// a 2xx is a failure.
+func (d *Dispatcher) post(ctx context.Context, x models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", x.Webhook.URL, bytes.NewReader(x.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bookings-Webhooks/1")
+	req.Header.Set(EventHeader, x.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(x.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(x.Webhook.Secret, timestamp, x.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// read some of the body, so that the connection can be used again
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("the webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,218 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taldrori/bookings/internal/dispatch"
+	"github.com/taldrori/bookings/internal/models"
+	"github.com/taldrori/bookings/internal/repository"
+	"github.com/taldrori/bookings/internal/repository/dbrepo/dbtest"
+)
+
+// receiver is a webhook that checks the signature of what it is sent, and answers status
+type receiver struct {
+	t      *testing.T
+	secret string
+	status int
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+
+	if !Verify(rc.secret, timestamp, body, r.Header.Get(SignatureHeader)) {
+		rc.t.Errorf("the delivery of %s has a bad signature", r.Header.Get(EventHeader))
+	}
+
+	var e Event
+	err := json.Unmarshal(body, &e)
+	if err != nil {
+		rc.t.Errorf("the delivery is not an event: %v", err)
+	}
+	if e.Type != r.Header.Get(EventHeader) {
+		rc.t.Errorf("expected the event header to be %s, got %s", e.Type, r.Header.Get(EventHeader))
+	}
+
+	rc.mu.Lock()
+	rc.events = append(rc.events, e)
+	status := rc.status
+	rc.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+// newTestWebhook returns a SQLite repository with a webhook for event, served by a receiver
+// that answers status
+func newTestWebhook(t *testing.T, event string, status int) (repository.DatabaseRepo, *receiver) {
+	t.Helper()
+
+	repo := dbtest.NewSQLiteRepo(t)
+
+	rc := &receiver{t: t, status: status}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	var err error
+	_, rc.secret, err = repo.InsertWebhook(context.Background(), models.Webhook{URL: srv.URL, Events: []string{event}})
+	if err != nil {
+		t.Fatalf("InsertWebhook: %v", err)
+	}
+
+	return repo, rc
+}
+
+// queue queues an event about a reservation
+func queue(t *testing.T, repo repository.DatabaseRepo, event string) {
+	t.Helper()
+
+	payload, err := Payload(event, map[string]interface{}{"reservation": map[string]int{"id": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.QueueWebhookEvent(context.Background(), event, payload)
+	if err != nil {
+		t.Fatalf("QueueWebhookEvent: %v", err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	repo, rc := newTestWebhook(t, models.EventReservationCreated, http.StatusNoContent)
+	queue(t, repo, models.EventReservationCreated)
+	queue(t, repo, models.EventReservationDeleted)
+
+	d := New(repo)
+	d.Dispatch(context.Background())
+
+	if len(rc.events) != 1 || rc.events[0].Type != models.EventReservationCreated {
+		t.Errorf("expected the webhook to get the event it subscribes to only, got %+v", rc.events)
+	}
+	if stats := d.Stats(); stats != (dispatch.Stats{Delivered: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	log, err := repo.RecentWebhookDeliveries(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].Status != models.WebhookDelivered || log[0].ResponseStatus != http.StatusNoContent || log[0].DeliveredAt.IsZero() {
+		t.Errorf("expected 1 delivered delivery, got %+v", log)
+	}
+}
+
+func TestDispatchGivesUp(t *testing.T) {
+	repo, rc := newTestWebhook(t, models.EventBlockAdded, http.StatusServiceUnavailable)
+	queue(t, repo, models.EventBlockAdded)
+
+	d := New(repo)
+	d.MaxAttempts = 3
+	// without a delay, the 503s are posted again in the same dispatch until the third one
+	d.BaseDelay = 0
+
+	d.Dispatch(context.Background())
+
+	if len(rc.events) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(rc.events))
+	}
+	if stats := d.Stats(); stats != (dispatch.Stats{Retried: 2, Failed: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	log, err := repo.RecentWebhookDeliveries(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := log[0]
+	if x.Status != models.WebhookFailed || x.Attempts != 3 || x.ResponseStatus != http.StatusServiceUnavailable {
+		t.Errorf("expected the delivery to have failed 3 times, got %+v", x)
+	}
+
+	err = repo.ResendWebhookDelivery(context.Background(), x.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.mu.Lock()
+	rc.status = http.StatusOK
+	rc.mu.Unlock()
+	d.Dispatch(context.Background())
+
+	log, _ = repo.RecentWebhookDeliveries(context.Background(), 10)
+	if log[0].Status != models.WebhookDelivered || log[0].Attempts != 1 {
+		t.Errorf("expected the resent delivery to be delivered, got %+v", log[0])
+	}
+}
+
+func TestDispatchBacksOff(t *testing.T) {
+	repo, _ := newTestWebhook(t, models.EventBlockRemoved, http.StatusInternalServerError)
+	queue(t, repo, models.EventBlockRemoved)
+
+	d := New(repo)
+	d.Dispatch(context.Background())
+
+	log, err := repo.RecentWebhookDeliveries(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := log[0]
+	if x.Status != models.WebhookPending || x.Attempts != 1 || x.LastError != "the webhook answered 500 Internal Server Error" {
+		t.Errorf("expected the delivery to be pending after 1 attempt, got %+v", x)
+	}
+	if wait := time.Until(x.NextAttemptAt); wait < 25*time.Second || wait > 35*time.Second {
+		t.Errorf("expected the next attempt in about 30s, got %s", wait)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	repo, rc := newTestWebhook(t, models.EventReservationCancelled, http.StatusOK)
+	queue(t, repo, models.EventReservationCancelled)
+	queue(t, repo, models.EventReservationCancelled)
+
+	d := New(repo)
+	d.PollInterval = time.Hour
+
+	d.Start()
+	stats, finished := d.Stop(context.Background())
+
+	if !finished {
+		t.Error("expected the dispatcher to finish")
+	}
+	if stats.Delivered != 2 || len(rc.events) != 2 {
+		t.Errorf("expected the 2 due deliveries to be made before stopping, got %+v", stats)
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"block.added"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_example"))
+	mac.Write([]byte(`1700000000.{"type":"block.added"}`))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	got := Sign("whsec_example", 1700000000, body)
+	if got != expected {
+		t.Errorf("expected the HMAC of the timestamp and the body, %s, got %s", expected, got)
+	}
+
+	if !Verify("whsec_example", 1700000000, body, got) {
+		t.Error("expected the signature to verify")
+	}
+	if Verify("whsec_other", 1700000000, body, got) {
+		t.Error("expected the signature not to verify with another secret")
+	}
+	if Verify("whsec_example", 1700000001, body, got) {
+		t.Error("expected the signature not to verify at another time")
+	}
+}
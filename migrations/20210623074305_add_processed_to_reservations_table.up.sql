@@ -0,0 +1 @@
+alter table reservations add column processed integer default 0;
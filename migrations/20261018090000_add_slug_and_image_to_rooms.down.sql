@@ -0,0 +1,2 @@
+alter table rooms drop column image;
+alter table rooms drop column slug;
@@ -0,0 +1,2 @@
+alter table rooms add column slug varchar(255) not null default '';
+alter table rooms add column image varchar(255) not null default '';
@@ -0,0 +1,5 @@
+UPDATE public.rooms SET slug = 'jonins-quarters', image = '/static/images/jonins-quarters.png'
+	WHERE room_name = 'Jonins Quarters';
+UPDATE public.rooms SET slug = 'hokages-suite', image = '/static/images/hokages-suite.png'
+	WHERE room_name = 'Hokages Suite';
+UPDATE public.rooms SET slug = concat('room-', id) WHERE slug = '';
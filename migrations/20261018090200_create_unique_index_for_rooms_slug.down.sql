@@ -0,0 +1 @@
+drop index rooms_slug_idx;
@@ -0,0 +1 @@
+create unique index rooms_slug_idx on rooms (slug);
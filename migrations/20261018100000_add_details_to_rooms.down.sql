@@ -0,0 +1,3 @@
+alter table rooms drop column bed_types;
+alter table rooms drop column description;
+alter table rooms drop column max_occupancy;
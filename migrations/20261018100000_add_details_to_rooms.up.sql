@@ -0,0 +1,3 @@
+alter table rooms add column max_occupancy integer not null default 2;
+alter table rooms add column description text not null default '';
+alter table rooms add column bed_types varchar(255) not null default '';
@@ -0,0 +1,6 @@
+create table amenities (
+	id serial primary key,
+	amenity_name varchar(255) not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
@@ -0,0 +1,16 @@
+create table room_amenities (
+	id serial primary key,
+	room_id integer not null,
+	amenity_id integer not null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table room_amenities add constraint room_amenities_rooms_id_fk foreign key (room_id)
+	references rooms (id) on delete cascade on update cascade;
+
+alter table room_amenities add constraint room_amenities_amenities_id_fk foreign key (amenity_id)
+	references amenities (id) on delete cascade on update cascade;
+
+create unique index room_amenities_room_id_amenity_id_idx on room_amenities (room_id, amenity_id);
+create index room_amenities_amenity_id_idx on room_amenities (amenity_id);
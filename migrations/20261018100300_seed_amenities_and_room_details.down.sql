@@ -0,0 +1,2 @@
+delete from room_amenities;
+delete from amenities;
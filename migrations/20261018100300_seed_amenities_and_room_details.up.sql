@@ -0,0 +1,20 @@
+INSERT INTO public.amenities (amenity_name,created_at,updated_at) VALUES
+	 ('Wi-Fi','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Air Conditioning','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Private Bathroom','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Balcony','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Kitchenette','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Hot Spring Bath','2026-10-18 00:00:00.000','2026-10-18 00:00:00.000');
+
+UPDATE public.rooms SET max_occupancy = 2, bed_types = '1 double bed',
+	description = 'A quiet room for a jonin back from a long mission. Rest up before the next chase after the Akatsuki.'
+	WHERE slug = 'jonins-quarters';
+UPDATE public.rooms SET max_occupancy = 4, bed_types = '1 king bed, 1 sofa bed',
+	description = 'The finest suite in the village, with a view over the Hokage Rock.'
+	WHERE slug = 'hokages-suite';
+
+INSERT INTO public.room_amenities (room_id,amenity_id,created_at,updated_at)
+	SELECT r.id, a.id, '2026-10-18 00:00:00.000', '2026-10-18 00:00:00.000'
+	FROM public.rooms r, public.amenities a
+	WHERE (r.slug = 'jonins-quarters' AND a.amenity_name IN ('Wi-Fi', 'Private Bathroom'))
+	OR (r.slug = 'hokages-suite' AND a.amenity_name IN ('Wi-Fi', 'Air Conditioning', 'Private Bathroom', 'Balcony', 'Hot Spring Bath'));
@@ -0,0 +1 @@
+alter table rooms add column base_rate integer default 0;
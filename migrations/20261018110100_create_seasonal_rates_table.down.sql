@@ -0,0 +1 @@
+drop table seasonal_rates;
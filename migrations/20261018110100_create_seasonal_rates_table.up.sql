@@ -0,0 +1,15 @@
+create table seasonal_rates (
+	id serial primary key,
+	room_id integer not null,
+	season_name varchar(255) not null default '',
+	start_date date not null,
+	end_date date not null,
+	nightly_rate integer not null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table seasonal_rates add constraint seasonal_rates_rooms_id_fk foreign key (room_id)
+	references rooms (id) on delete cascade on update cascade;
+
+create index seasonal_rates_room_id_start_date_end_date_idx on seasonal_rates (room_id, start_date, end_date);
@@ -0,0 +1,13 @@
+create table rate_modifiers (
+	id serial primary key,
+	room_id integer not null,
+	weekday integer not null,
+	percent integer not null default 0,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table rate_modifiers add constraint rate_modifiers_rooms_id_fk foreign key (room_id)
+	references rooms (id) on delete cascade on update cascade;
+
+create unique index rate_modifiers_room_id_weekday_idx on rate_modifiers (room_id, weekday);
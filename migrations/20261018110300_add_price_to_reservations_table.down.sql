@@ -0,0 +1,2 @@
+alter table reservations drop column price_breakdown;
+alter table reservations drop column total_price;
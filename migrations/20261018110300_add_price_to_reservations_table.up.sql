@@ -0,0 +1,2 @@
+alter table reservations add column total_price integer default 0;
+alter table reservations add column price_breakdown text default '';
@@ -0,0 +1,2 @@
+delete from rate_modifiers;
+UPDATE public.rooms SET base_rate = 0;
@@ -0,0 +1,6 @@
+UPDATE public.rooms SET base_rate = 12000 WHERE slug = 'jonins-quarters';
+UPDATE public.rooms SET base_rate = 25000 WHERE slug = 'hokages-suite';
+
+INSERT INTO public.rate_modifiers (room_id,weekday,percent,created_at,updated_at)
+	SELECT r.id, w.weekday, 20, '2026-10-18 00:00:00.000', '2026-10-18 00:00:00.000'
+	FROM public.rooms r, (VALUES (5), (6)) AS w(weekday);
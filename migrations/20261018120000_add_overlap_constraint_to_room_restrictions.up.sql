@@ -0,0 +1,28 @@
+-- btree_gist is a trusted extension from Postgres 13 on, which the owner of the database can
+-- create; on older servers, and on hosted ones that limit extensions, an administrator has to
+-- create it before this migration runs
+create extension if not exists btree_gist;
+
+-- the constraint can't be added while restrictions overlap, so list them rather than fail
+-- with only the name of the constraint; see the readme for how to clear them
+do $$
+declare
+	overlaps text;
+begin
+	select string_agg(format('room %s: restriction %s (%s to %s) and restriction %s (%s to %s)',
+			a.room_id, a.id, a.start_date, a.end_date, b.id, b.start_date, b.end_date),
+		E'\n' order by a.room_id, a.start_date, b.start_date)
+	into overlaps
+	from room_restrictions a
+	join room_restrictions b on b.room_id = a.room_id and b.id > a.id
+		and daterange(a.start_date, a.end_date, '[)') && daterange(b.start_date, b.end_date, '[)');
+
+	if overlaps is not null then
+		raise exception E'These room restrictions overlap, remove or move them before migrating:\n%', overlaps
+			using hint = 'See "Overlapping restrictions" in the readme.';
+	end if;
+end
+$$;
+
+alter table room_restrictions add constraint room_restrictions_no_overlap
+	exclude using gist (room_id with =, daterange(start_date, end_date, '[)') with &&);
@@ -0,0 +1,2 @@
+drop index room_restrictions_expires_at_idx;
+alter table room_restrictions drop column expires_at;
@@ -0,0 +1,2 @@
+alter table room_restrictions add column expires_at timestamp null;
+create index room_restrictions_expires_at_idx on room_restrictions (expires_at);
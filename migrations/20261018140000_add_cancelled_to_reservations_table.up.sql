@@ -0,0 +1 @@
+alter table reservations add column cancelled integer default 0;
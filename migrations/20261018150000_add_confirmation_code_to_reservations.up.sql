@@ -0,0 +1 @@
+alter table reservations add column confirmation_code varchar(255) null;
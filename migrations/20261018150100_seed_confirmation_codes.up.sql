@@ -0,0 +1,34 @@
+-- The codes are drawn from the alphabet of newConfirmationCode, in
+-- internal/repository/dbrepo/codes.go, like LV-7KQ3-M9. A code that is already taken is drawn
+-- again, so that the unique index of the next migration can be made; seeded_codes keeps the
+-- codes given so far, with an index to look them up.
+do $$
+declare
+	alphabet constant text := 'ABCDEFGHJKLMNPQRSTUVWXYZ23456789';
+	r record;
+	code text;
+begin
+	create temporary table seeded_codes (code varchar(255) primary key);
+	insert into seeded_codes select distinct confirmation_code from reservations
+		where confirmation_code is not null;
+
+	for r in select id from reservations where confirmation_code is null loop
+		loop
+			code := 'LV-';
+			for i in 1..6 loop
+				if i = 5 then
+					code := code || '-';
+				end if;
+				code := code || substr(alphabet, 1 + floor(random() * length(alphabet))::int, 1);
+			end loop;
+
+			insert into seeded_codes values (code) on conflict do nothing;
+			exit when found;
+		end loop;
+
+		update reservations set confirmation_code = code where id = r.id;
+	end loop;
+
+	drop table seeded_codes;
+end
+$$;
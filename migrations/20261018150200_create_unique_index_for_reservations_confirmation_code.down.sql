@@ -0,0 +1,2 @@
+alter table reservations alter column confirmation_code drop not null;
+drop index reservations_confirmation_code_idx;
@@ -0,0 +1,2 @@
+create unique index reservations_confirmation_code_idx on reservations (confirmation_code);
+alter table reservations alter column confirmation_code set not null;
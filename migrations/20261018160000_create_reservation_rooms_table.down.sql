@@ -0,0 +1 @@
+drop table reservation_rooms;
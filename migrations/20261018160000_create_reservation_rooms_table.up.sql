@@ -0,0 +1,18 @@
+create table reservation_rooms (
+	id serial primary key,
+	reservation_id integer not null,
+	room_id integer not null,
+	total_price integer not null default 0,
+	price_breakdown text null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table reservation_rooms add constraint reservation_rooms_reservations_id_fk foreign key (reservation_id)
+	references reservations (id) on delete cascade on update cascade;
+
+alter table reservation_rooms add constraint reservation_rooms_rooms_id_fk foreign key (room_id)
+	references rooms (id) on delete cascade on update cascade;
+
+create index reservation_rooms_reservation_id_idx on reservation_rooms (reservation_id);
+create index reservation_rooms_room_id_idx on reservation_rooms (room_id);
@@ -0,0 +1 @@
+delete from reservation_rooms;
@@ -0,0 +1,2 @@
+INSERT INTO public.reservation_rooms (reservation_id,room_id,total_price,price_breakdown,created_at,updated_at)
+	SELECT id, room_id, coalesce(total_price, 0), price_breakdown, created_at, updated_at FROM public.reservations;
@@ -0,0 +1,3 @@
+alter table reservations add column price_breakdown text null;
+update reservations r set price_breakdown = rr.price_breakdown from reservation_rooms rr
+	where rr.reservation_id = r.id and rr.room_id = r.room_id;
@@ -0,0 +1,2 @@
+alter table room_restrictions drop column category;
+alter table room_restrictions drop column reason;
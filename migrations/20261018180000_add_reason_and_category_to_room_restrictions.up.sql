@@ -0,0 +1,3 @@
+alter table room_restrictions add column reason varchar(255) not null default '';
+alter table room_restrictions add column category varchar(255) not null default '';
+update room_restrictions set category = 'owner_use' where restriction_id = 2;
@@ -0,0 +1,17 @@
+create table outbox (
+	id serial primary key,
+	to_address varchar(255) not null,
+	from_address varchar(255) not null,
+	subject varchar(255) not null default '',
+	content text not null default '',
+	template varchar(255) not null default '',
+	status varchar(20) not null default 'pending',
+	attempts integer not null default 0,
+	next_attempt_at timestamp not null,
+	last_error text not null default '',
+	sent_at timestamp null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create index outbox_status_next_attempt_at_idx on outbox (status, next_attempt_at);
@@ -0,0 +1 @@
+alter table outbox drop column text_content;
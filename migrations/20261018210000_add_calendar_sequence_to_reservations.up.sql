@@ -0,0 +1 @@
+alter table reservations add column calendar_sequence integer not null default 0;
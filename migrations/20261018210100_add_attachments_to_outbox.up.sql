@@ -0,0 +1 @@
+alter table outbox add column attachments text not null default '';
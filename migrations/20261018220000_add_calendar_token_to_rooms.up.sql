@@ -0,0 +1,2 @@
+alter table rooms add column calendar_token varchar(255) not null default '';
+update rooms set calendar_token = md5(random()::text || clock_timestamp()::text || id::text);
@@ -0,0 +1 @@
+drop index rooms_calendar_token_idx;
@@ -0,0 +1 @@
+create unique index rooms_calendar_token_idx on rooms (calendar_token);
@@ -0,0 +1,15 @@
+create table room_calendar_feeds (
+	id serial primary key,
+	room_id integer not null,
+	name varchar(255) not null default '',
+	url text not null,
+	last_synced_at timestamp,
+	last_error text not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table room_calendar_feeds add constraint room_calendar_feeds_rooms_id_fk foreign key (room_id)
+	references rooms (id) on delete cascade on update cascade;
+
+create index room_calendar_feeds_room_id_idx on room_calendar_feeds (room_id);
@@ -0,0 +1,2 @@
+alter table room_restrictions drop column external_uid;
+alter table room_restrictions drop column feed_id;
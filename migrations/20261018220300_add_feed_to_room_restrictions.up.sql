@@ -0,0 +1,7 @@
+alter table room_restrictions add column feed_id integer;
+alter table room_restrictions add column external_uid varchar(255) not null default '';
+
+alter table room_restrictions add constraint room_restrictions_room_calendar_feeds_id_fk foreign key (feed_id)
+	references room_calendar_feeds (id) on delete cascade on update cascade;
+
+create index room_restrictions_feed_id_idx on room_restrictions (feed_id);
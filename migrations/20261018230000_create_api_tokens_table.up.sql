@@ -0,0 +1,12 @@
+create table api_tokens (
+	id serial primary key,
+	user_id integer not null,
+	name varchar(255) not null default '',
+	token_hash varchar(64) not null,
+	last_used_at timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table api_tokens add constraint api_tokens_users_id_fk foreign key (user_id)
+	references users (id) on delete cascade on update cascade;
@@ -0,0 +1 @@
+drop index api_tokens_token_hash_idx;
@@ -0,0 +1 @@
+create unique index api_tokens_token_hash_idx on api_tokens (token_hash);
@@ -0,0 +1,8 @@
+create table webhooks (
+	id serial primary key,
+	url text not null,
+	secret varchar(255) not null,
+	events text not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
@@ -0,0 +1 @@
+drop table webhook_deliveries;
@@ -0,0 +1,20 @@
+create table webhook_deliveries (
+	id serial primary key,
+	webhook_id integer not null,
+	event varchar(255) not null,
+	payload text not null,
+	status varchar(20) not null default 'pending',
+	attempts integer not null default 0,
+	response_status integer not null default 0,
+	next_attempt_at timestamp not null,
+	last_error text not null default '',
+	delivered_at timestamp null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+alter table webhook_deliveries add constraint webhook_deliveries_webhooks_id_fk foreign key (webhook_id)
+	references webhooks (id) on delete cascade on update cascade;
+
+create index webhook_deliveries_status_next_attempt_at_idx on webhook_deliveries (status, next_attempt_at);
+create index webhook_deliveries_created_at_idx on webhook_deliveries (created_at);
@@ -0,0 +1,10 @@
+// Package migrations holds the SQL migrations of the Postgres schema, which are embedded in the
+// binary and applied by bookings migrate
+package migrations
+
+import "embed"
+
+// FS has a <version>_<name>.up.sql and a <version>_<name>.down.sql file for every migration
+//
+//go:embed *.sql
+var FS embed.FS
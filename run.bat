@@ -1,2 +1,3 @@
 go build -o bookings.exe .\cmd\web\.
+bookings.exe migrate up --dbname=bookings --dbuser=postgres --dbpass=password
 bookings.exe --dbname=bookings --dbuser=postgres --dbpass=password --cache=false --production=false
\ No newline at end of file
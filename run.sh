@@ -1,4 +1,4 @@
 #!/bin/bash
 
-go build -o bookings cmd/web/*.go && ./bookings
-./bookings -dbname=bookings -dbuser=postgres -dbpass=password -cache=false -production=false
\ No newline at end of file
+go build -o bookings cmd/web/*.go && ./bookings migrate up -dbname=bookings -dbuser=postgres -dbpass=password
+./bookings -dbname=bookings -dbuser=postgres -dbpass=password -cache=false -production=false
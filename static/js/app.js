@@ -30,6 +30,7 @@ function AvailabilityPopUp(){
                     showOnFocus: true,
                     minDate: new Date(),
                 })
+                greyOutTakenNights(rp, id);
             },
             didOpen: () => {
                 document.getElementById('start').removeAttribute('disabled');
@@ -64,9 +65,15 @@ function AvailabilityPopUp(){
                                 showConfirmButton: false,
                             })
                         }
+                        else if (data.error) {
+                            const fields = Object.values(data.error.fields || {});
+                            attention.error({
+                                msg: fields.length > 0 ? fields[0][0] : data.error.message,
+                            })
+                        }
                         else{
                             attention.error({
-                                msg: "Room is not available",
+                                msg: data.message || "Room is not available",
                             })
                         }
                     })
@@ -77,6 +84,58 @@ function AvailabilityPopUp(){
     return {"pop": pop}
 }
 
+// greyOutTakenNights keeps the guest from picking the nights room id, or every room when id is
+// not given, is taken for in the date range picker rp: an arrival on a taken night, or a
+// departure the day after one. The nights of a month are asked for when the picker shows it.
+function greyOutTakenNights(rp, id) {
+    const taken = new Set();
+    const loaded = new Set();
+
+    const pad = n => String(n).padStart(2, "0");
+    const day = d => d.getFullYear() + "-" + pad(d.getMonth() + 1) + "-" + pad(d.getDate());
+    const month = d => d.getFullYear() + "-" + pad(d.getMonth() + 1);
+    const dayBefore = d => new Date(d.getFullYear(), d.getMonth(), d.getDate() - 1);
+
+    const [start, end] = rp.datepickers;
+    start.setOptions({beforeShowDay: d => !taken.has(day(d))});
+    end.setOptions({beforeShowDay: d => !taken.has(day(dayBefore(d)))});
+
+    function load(d) {
+        const m = month(d);
+        if (loaded.has(m)) {
+            return;
+        }
+        loaded.add(m);
+
+        fetch("/api/v1/availability/month?month=" + m + (id ? "&room_id=" + id : ""))
+            .then(response => response.ok ? response.json() : {rooms: []})
+            .then(data => {
+                const rooms = {};
+                data.rooms.forEach(room => room.nights.forEach(n => {
+                    if (!n.available) {
+                        rooms[n.date] = (rooms[n.date] || 0) + 1;
+                    }
+                }));
+                Object.keys(rooms).forEach(date => {
+                    if (rooms[date] === data.rooms.length) {
+                        taken.add(date);
+                    }
+                });
+                rp.datepickers.forEach(dp => dp.refresh());
+            })
+            .catch(() => loaded.delete(m));
+    }
+
+    const today = new Date();
+    load(today);
+    load(new Date(today.getFullYear(), today.getMonth() + 1, 1));
+    rp.inputs.forEach(input => input.addEventListener("changeMonth", e => {
+        const d = e.detail.viewDate;
+        load(d);
+        load(new Date(d.getFullYear(), d.getMonth() + 1, 1));
+    }));
+}
+
 function Prompt(){
 
     let toast = function(c) {